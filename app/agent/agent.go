@@ -0,0 +1,310 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// Executor runs local task by name
+type Executor interface {
+	Exec(ctx context.Context, taskName string, params map[string]string, stdout, stderr io.Writer) error
+}
+
+// Agent connects to the hub, registers local tasks and executes jobs received from the hub
+type Agent struct {
+	HubURL        string
+	Token         string
+	Tasks         []string
+	Executor      Executor
+	Client        *http.Client
+	FlushInterval time.Duration // how often job output sent to the hub, default 1s
+	RetryDelay    time.Duration // delay before reconnect after hub error, default 5s
+}
+
+var errNotRegistered = errors.New("agent not registered")
+var errJobGone = errors.New("job not active")
+
+// Run registers agent on the hub and executes received jobs till context cancellation.
+// Connection errors are retried, and the agent re-registers if the hub lost registration, i.e. after restart.
+func (a *Agent) Run(ctx context.Context) error {
+	log.Printf("[INFO] start agent for hub %s, tasks: %v", a.HubURL, a.Tasks)
+	retryDelay := a.RetryDelay
+	if retryDelay == 0 {
+		retryDelay = 5 * time.Second
+	}
+	sleep := func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(retryDelay):
+		}
+	}
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	registered := false
+	for ctx.Err() == nil {
+		if !registered {
+			if err := a.register(ctx); err != nil {
+				log.Printf("[WARN] can't register on hub %s, %v", a.HubURL, err)
+				sleep()
+				continue
+			}
+			registered = true
+		}
+
+		job, err := a.poll(ctx)
+		if err != nil {
+			if errors.Is(err, errNotRegistered) {
+				registered = false
+				continue
+			}
+			if ctx.Err() == nil {
+				log.Printf("[WARN] can't poll hub %s, %v", a.HubURL, err)
+				sleep()
+			}
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a.execute(ctx, *job)
+		}()
+	}
+	return ctx.Err()
+}
+
+// execute runs the job, streams its output and reports the result to the hub.
+// The job canceled if the hub reports it is not active anymore.
+func (a *Agent) execute(ctx context.Context, job Job) {
+	log.Printf("[INFO] execute job %s, task %s", job.ID, job.Task)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lw := &logStreamer{send: func(chunks []LogChunk) error { return a.sendLog(ctx, job.ID, chunks) }, cancel: cancel}
+	flushInterval := a.FlushInterval
+	if flushInterval == 0 {
+		flushInterval = time.Second
+	}
+	done := make(chan struct{})
+	var flushWg sync.WaitGroup
+	flushWg.Add(1)
+	go func() {
+		defer flushWg.Done()
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				lw.flush(true)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := a.Executor.Exec(ctx, job.Task, job.Params, lw.writer(streamStdout), lw.writer(streamStderr))
+	close(done)
+	flushWg.Wait() // the final flush is not sent along with the one of ticker
+	for i := 0; lw.flush(false) && i < finalFlushRetries; i++ {
+		time.Sleep(flushInterval)
+	}
+
+	res := Result{}
+	if err != nil {
+		res.Error = err.Error()
+		res.ExitCode = 1
+		var ec interface{ ExitCode() int }
+		if errors.As(err, &ec) && ec.ExitCode() > 0 {
+			res.ExitCode = ec.ExitCode()
+		}
+		log.Printf("[WARN] job %s failed, %v", job.ID, err)
+	}
+	// result is sent with the parent context, as job context may be canceled already
+	if e := a.call(context.WithoutCancel(ctx), http.MethodPost, "/agent/jobs/"+job.ID+"/result", res, nil); e != nil {
+		log.Printf("[WARN] can't send result of job %s, %v", job.ID, e)
+	}
+}
+
+func (a *Agent) register(ctx context.Context) error {
+	req := struct {
+		Tasks []string `json:"tasks"`
+	}{Tasks: a.Tasks}
+	return a.call(ctx, http.MethodPost, "/agent/register", req, nil)
+}
+
+// poll waits for the next job, returns nil job if no job available
+func (a *Agent) poll(ctx context.Context) (*Job, error) {
+	job := Job{}
+	if err := a.call(ctx, http.MethodGet, "/agent/poll", nil, &job); err != nil {
+		return nil, err
+	}
+	if job.ID == "" {
+		return nil, nil
+	}
+	return &job, nil
+}
+
+func (a *Agent) sendLog(ctx context.Context, id string, chunks []LogChunk) error {
+	return a.call(ctx, http.MethodPost, "/agent/jobs/"+id+"/log", chunks, nil)
+}
+
+// call makes request to the hub. Request body is sent as is for []byte and as json otherwise.
+// Response decoded to resp if set and response is not empty.
+func (a *Agent) call(ctx context.Context, method, path string, req, resp any) error {
+	var body io.Reader
+	switch v := req.(type) {
+	case nil:
+	case []byte:
+		body = bytes.NewReader(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("can't marshal request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(a.HubURL, "/")+path, body)
+	if err != nil {
+		return fmt.Errorf("can't make request: %w", err)
+	}
+	httpReq.Header.Set("Authorization", "Bearer "+a.Token)
+	client := a.Client
+	if client == nil {
+		client = &http.Client{Timeout: 2 * time.Minute}
+	}
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close() //nolint
+
+	switch httpResp.StatusCode {
+	case http.StatusOK:
+		if resp == nil {
+			return nil
+		}
+		if err := json.NewDecoder(httpResp.Body).Decode(resp); err != nil {
+			return fmt.Errorf("can't decode response: %w", err)
+		}
+		return nil
+	case http.StatusNoContent:
+		return nil
+	case http.StatusConflict:
+		return errNotRegistered
+	case http.StatusGone:
+		return errJobGone
+	default:
+		return fmt.Errorf("unexpected status %d from %s", httpResp.StatusCode, path)
+	}
+}
+
+// logStreamer collects job output of both streams and sends it to the hub on flush.
+// Output kept as chunks of the same stream, all of them sent with a single request in order of writes.
+// Output not sent because of hub error kept till the next flush, the oldest dropped above maxPendingLog.
+type logStreamer struct {
+	mu     sync.Mutex
+	chunks []logChunk
+	size   int // size of data of all chunks
+	send   func(chunks []LogChunk) error
+	cancel func()
+}
+
+type logChunk struct {
+	stream string
+	data   []byte
+}
+
+const (
+	maxPendingLog     = 1 << 20 // max size of job output kept by agent till sent
+	finalFlushRetries = 3       // number of retries of the final flush failed to send job output
+)
+
+// writer returns writer of the output stream
+func (l *logStreamer) writer(stream string) io.Writer {
+	return &streamWriter{streamer: l, stream: stream}
+}
+
+func (l *logStreamer) write(stream string, p []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.size += len(p)
+	if n := len(l.chunks); n > 0 && l.chunks[n-1].stream == stream {
+		l.chunks[n-1].data = append(l.chunks[n-1].data, p...)
+		return
+	}
+	l.chunks = append(l.chunks, logChunk{stream: stream, data: bytes.Clone(p)})
+}
+
+// flush sends collected output. With heartbeat set empty output is sent as well, to learn if job was canceled.
+// Returns true if output not sent and kept for the next flush.
+func (l *logStreamer) flush(heartbeat bool) bool {
+	l.mu.Lock()
+	chunks, size := l.chunks, l.size
+	l.chunks, l.size = nil, 0
+	l.mu.Unlock()
+	if len(chunks) == 0 && !heartbeat {
+		return false
+	}
+	req := make([]LogChunk, 0, len(chunks))
+	for _, c := range chunks {
+		req = append(req, LogChunk{Stream: c.stream, Data: string(c.data)})
+	}
+	err := l.send(req)
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, errJobGone) {
+		log.Printf("[WARN] job canceled by hub")
+		l.cancel()
+		return false
+	}
+	log.Printf("[WARN] can't send job output, %v", err)
+	l.requeue(chunks, size)
+	return len(chunks) > 0
+}
+
+// requeue returns unsent chunks ahead of output written since, the oldest chunks dropped above maxPendingLog
+func (l *logStreamer) requeue(chunks []logChunk, size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(chunks) > 0 && len(l.chunks) > 0 && chunks[len(chunks)-1].stream == l.chunks[0].stream {
+		last := &chunks[len(chunks)-1]
+		last.data = append(last.data, l.chunks[0].data...)
+		l.chunks = l.chunks[1:]
+	}
+	l.chunks, l.size = append(chunks, l.chunks...), size+l.size
+	dropped := 0
+	for l.size > maxPendingLog && len(l.chunks) > 1 {
+		dropped += len(l.chunks[0].data)
+		l.size -= len(l.chunks[0].data)
+		l.chunks = l.chunks[1:]
+	}
+	if dropped > 0 {
+		log.Printf("[WARN] %d bytes of job output dropped, not sent to hub", dropped)
+	}
+}
+
+// streamWriter writes to logStreamer output of the stream
+type streamWriter struct {
+	streamer *logStreamer
+	stream   string
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	w.streamer.write(w.stream, p)
+	return len(p), nil
+}
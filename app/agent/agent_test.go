@@ -0,0 +1,294 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/task"
+)
+
+func TestAgent_Run(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: 100 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	exec := &execMock{fn: func(_ context.Context, taskName string, _ map[string]string, w, errW io.Writer) error {
+		_, _ = fmt.Fprintf(w, "run %s\n", taskName)
+		if taskName == "bad" {
+			_, _ = fmt.Fprint(errW, "error\n")
+			return errors.New("task failed")
+		}
+		return nil
+	}}
+	agt := &Agent{HubURL: ts.URL, Token: "token1", Tasks: []string{"good", "bad"}, Executor: exec,
+		FlushInterval: 10 * time.Millisecond, RetryDelay: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	agentDone := make(chan struct{})
+	go func() {
+		_ = agt.Run(ctx)
+		close(agentDone)
+	}()
+
+	waitFor(t, func() bool { _, ok := hub.Runner("good"); return ok })
+
+	r, _ := hub.Runner("good")
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	require.NoError(t, r.Run(context.Background(), "", stdout, stderr))
+	assert.Equal(t, "run good\n", stdout.String())
+	assert.Empty(t, stderr.String())
+
+	r, _ = hub.Runner("bad")
+	stdout, stderr = bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	err := r.Run(context.Background(), "", stdout, stderr)
+	require.Error(t, err)
+	assert.Equal(t, "agent agent1: task failed", err.Error())
+	assert.Equal(t, "run bad\n", stdout.String())
+	assert.Equal(t, "error\n", stderr.String(), "stderr of the task reported as stderr on the hub")
+
+	cancel()
+	<-agentDone
+}
+
+func TestAgent_RunWithParams(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: 100 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	exec := &execMock{fn: func(_ context.Context, _ string, params map[string]string, w, _ io.Writer) error {
+		_, _ = fmt.Fprintf(w, "version %s\n", params["VERSION"])
+		return &ExitError{Message: "exit status 3", Code: 3}
+	}}
+	agt := &Agent{HubURL: ts.URL, Token: "token1", Tasks: []string{"deploy"}, Executor: exec,
+		FlushInterval: 10 * time.Millisecond, RetryDelay: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = agt.Run(ctx) }()
+
+	waitFor(t, func() bool { _, ok := hub.Runner("deploy"); return ok })
+	r, _ := hub.Runner("deploy")
+	lw := bytes.NewBuffer(nil)
+	err := r.Run(task.WithParams(context.Background(), map[string]string{"VERSION": "1.2"}), "", lw, lw)
+	require.Error(t, err)
+	assert.Equal(t, "version 1.2\n", lw.String())
+	var exitErr *ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 3, exitErr.ExitCode())
+}
+
+func TestAgent_RunCanceledByHub(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: 100 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	canceled := make(chan struct{})
+	exec := &execMock{fn: func(ctx context.Context, _ string, _ map[string]string, _, _ io.Writer) error {
+		<-ctx.Done()
+		close(canceled)
+		return ctx.Err()
+	}}
+	agt := &Agent{HubURL: ts.URL, Token: "token1", Tasks: []string{"slow"}, Executor: exec,
+		FlushInterval: 10 * time.Millisecond, RetryDelay: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = agt.Run(ctx) }()
+
+	waitFor(t, func() bool { _, ok := hub.Runner("slow"); return ok })
+	r, _ := hub.Runner("slow")
+	runCtx, runCancel := context.WithCancel(context.Background())
+	go func() {
+		for exec.calls() == 0 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		runCancel()
+	}()
+	err := r.Run(runCtx, "", io.Discard, io.Discard)
+	require.ErrorIs(t, err, context.Canceled)
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("job on agent not canceled")
+	}
+}
+
+func TestAgent_ExecuteFinalFlush(t *testing.T) {
+	var mu sync.Mutex
+	var logs []string
+	var inFlight, maxInFlight int
+	resultSent := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/agent/jobs/job1/result" {
+			close(resultSent)
+			return
+		}
+		chunks := []LogChunk{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&chunks))
+		mu.Lock()
+		inFlight++
+		maxInFlight = max(maxInFlight, inFlight)
+		mu.Unlock()
+		time.Sleep(30 * time.Millisecond) // slow hub, the final flush made while the heartbeat is sent
+		mu.Lock()
+		inFlight--
+		logs = append(logs, chunkLines(chunks)...)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	exec := &execMock{fn: func(_ context.Context, _ string, _ map[string]string, w, errW io.Writer) error {
+		_, _ = fmt.Fprint(w, "first\n")
+		time.Sleep(15 * time.Millisecond)
+		_, _ = fmt.Fprint(w, "last\n")
+		_, _ = fmt.Fprint(errW, "warning\n")
+		_, _ = fmt.Fprint(w, "done\n")
+		return nil
+	}}
+	agt := &Agent{HubURL: ts.URL, Token: "token1", Executor: exec, FlushInterval: 10 * time.Millisecond}
+	agt.execute(context.Background(), Job{ID: "job1", Task: "task1"})
+	<-resultSent
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 1, maxInFlight, "log chunks sent one by one")
+	assert.Equal(t, "stdout:first\nstdout:last\nstderr:warning\nstdout:done\n", strings.Join(logs, ""),
+		"chunks of streams sent in order of writes")
+}
+
+func TestAgent_ExecuteRetryLog(t *testing.T) {
+	var mu sync.Mutex
+	var logs []string
+	var firstChunks, rejected int
+	resultSent := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/agent/jobs/job1/result" {
+			close(resultSent)
+			return
+		}
+		chunks := []LogChunk{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&chunks))
+		mu.Lock()
+		defer mu.Unlock()
+		if firstChunks == 0 {
+			firstChunks = len(chunks)
+		}
+		if len(chunks) > 0 && rejected < 2 {
+			rejected++
+			http.Error(w, "too many requests", http.StatusTooManyRequests)
+			return
+		}
+		logs = append(logs, chunkLines(chunks)...)
+	}))
+	defer ts.Close()
+
+	exec := &execMock{fn: func(_ context.Context, _ string, _ map[string]string, w, errW io.Writer) error {
+		for i := 0; i < 3; i++ {
+			_, _ = fmt.Fprintf(w, "out %d\n", i)
+			_, _ = fmt.Fprintf(errW, "err %d\n", i)
+		}
+		time.Sleep(15 * time.Millisecond)
+		_, _ = fmt.Fprint(w, "done\n")
+		return nil
+	}}
+	agt := &Agent{HubURL: ts.URL, Token: "token1", Executor: exec, FlushInterval: 10 * time.Millisecond}
+	agt.execute(context.Background(), Job{ID: "job1", Task: "task1"})
+	<-resultSent
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 2, rejected)
+	assert.Equal(t, 6, firstChunks, "interleaved streams sent with a single request")
+	assert.Equal(t, "stdout:out 0\nstderr:err 0\nstdout:out 1\nstderr:err 1\nstdout:out 2\nstderr:err 2\nstdout:done\n",
+		strings.Join(logs, ""), "output not sent because of hub error kept and sent in order")
+}
+
+func TestLogStreamer_RequeueLimit(t *testing.T) {
+	l := &logStreamer{send: func([]LogChunk) error { return errors.New("failed") }, cancel: func() {}}
+	_, _ = l.writer(streamStdout).Write([]byte(strings.Repeat("a", maxPendingLog)))
+	_, _ = l.writer(streamStderr).Write([]byte("warning\n"))
+	l.flush(false)
+	_, _ = l.writer(streamStderr).Write([]byte("error\n"))
+	l.flush(false)
+
+	require.Len(t, l.chunks, 1, "the oldest output dropped above the limit")
+	assert.Equal(t, logChunk{stream: streamStderr, data: []byte("warning\nerror\n")}, l.chunks[0])
+	assert.Equal(t, 14, l.size)
+}
+
+func TestAgent_Reregister(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: 20 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	agt := &Agent{HubURL: ts.URL, Token: "token1", Tasks: []string{"t1"}, Executor: &execMock{},
+		RetryDelay: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = agt.Run(ctx) }()
+	waitFor(t, func() bool { return len(hub.Agents()) == 1 })
+
+	// emulate hub restart
+	hub.mu.Lock()
+	hub.agents = map[string]*agentState{}
+	hub.mu.Unlock()
+	waitFor(t, func() bool { return len(hub.Agents()) == 1 })
+}
+
+type execMock struct {
+	mu    sync.Mutex
+	count int
+	fn    func(ctx context.Context, taskName string, params map[string]string, stdout, stderr io.Writer) error
+}
+
+func (e *execMock) Exec(ctx context.Context, taskName string, params map[string]string, stdout, stderr io.Writer) error {
+	e.mu.Lock()
+	e.count++
+	e.mu.Unlock()
+	if e.fn == nil {
+		return nil
+	}
+	return e.fn(ctx, taskName, params, stdout, stderr)
+}
+
+func (e *execMock) calls() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.count
+}
+
+// chunkLines splits chunks of job output to lines prefixed with the stream
+func chunkLines(chunks []LogChunk) []string {
+	var res []string
+	for _, c := range chunks {
+		for _, line := range strings.SplitAfter(c.Data, "\n") {
+			if line != "" {
+				res = append(res, c.Stream+":"+line)
+			}
+		}
+	}
+	return res
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met")
+}
@@ -0,0 +1,449 @@
+// Package agent implements hub/agent mode. The hub is a public updater receiving triggers and dispatching
+// jobs for tasks owned by agents. Agents are updaters on private hosts, connected to the hub with outbound
+// long-polling requests. Agents register their tasks, receive jobs, stream logs and report results back.
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+
+	"github.com/umputun/updater/app/task"
+)
+
+// Runner executes commands
+type Runner interface {
+	Run(ctx context.Context, command string, stdout, stderr io.Writer) error
+}
+
+// Hub accepts agents and dispatches jobs for tasks registered by agents
+type Hub struct {
+	Tokens       map[string]string // agent name -> token
+	PollWait     time.Duration     // max time of poll request waiting for a job, default 30s
+	OfflineAfter time.Duration     // agent considered offline if not polled for this time, default 2m
+	AckTimeout   time.Duration     // max time from sending the job till the first report of agent, default 30s
+
+	once    sync.Once
+	handler http.Handler
+	mu      sync.Mutex
+	agents  map[string]*agentState
+	jobs    map[string]*hubJob
+}
+
+// Job is a task execution request sent to agent
+type Job struct {
+	ID     string            `json:"id"`
+	Task   string            `json:"task"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// LogChunk is a part of job output of the stream, reported by agent
+type LogChunk struct {
+	Stream string `json:"stream"` // stdout or stderr
+	Data   string `json:"data"`
+}
+
+// output streams of the job, sent with log chunks
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// Result is a job result reported by agent, empty error means success
+type Result struct {
+	Error    string `json:"error,omitempty"`
+	ExitCode int    `json:"exit_code,omitempty"`
+}
+
+// ExitError is a job failure reported by agent
+type ExitError struct {
+	Message string
+	Code    int
+}
+
+func (e *ExitError) Error() string { return e.Message }
+
+// ExitCode returns exit code of the failed task on agent
+func (e *ExitError) ExitCode() int { return e.Code }
+
+type agentState struct {
+	name     string
+	tasks    []string
+	lastSeen time.Time
+	queue    chan *hubJob
+}
+
+type hubJob struct {
+	Job
+	agent   string
+	stdout  io.Writer
+	stderr  io.Writer
+	done    chan error
+	sent    chan struct{} // closed when the job sent to agent
+	acked   chan struct{} // closed on the first log chunk or result of agent
+	ackOnce sync.Once
+}
+
+// ack marks the job as received by agent
+func (j *hubJob) ack() {
+	j.ackOnce.Do(func() { close(j.acked) })
+}
+
+type ctxKey string
+
+const agentNameKey ctxKey = "agent"
+
+// ServeHTTP handles agent requests, all of them under /agent/ path
+func (h *Hub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.once.Do(func() {
+		h.mu.Lock()
+		if h.agents == nil {
+			h.agents = map[string]*agentState{}
+		}
+		if h.jobs == nil {
+			h.jobs = map[string]*hubJob{}
+		}
+		h.mu.Unlock()
+		mux := http.NewServeMux()
+		mux.HandleFunc("POST /agent/register", h.registerCtrl)
+		mux.HandleFunc("GET /agent/poll", h.pollCtrl)
+		mux.HandleFunc("POST /agent/jobs/{id}/log", h.logCtrl)
+		mux.HandleFunc("POST /agent/jobs/{id}/result", h.resultCtrl)
+		h.handler = h.auth(mux)
+	})
+	h.handler.ServeHTTP(w, r)
+}
+
+// Runner returns runner dispatching the task to an online agent registered it
+func (h *Hub) Runner(taskName string) (Runner, bool) {
+	if h.agentFor(taskName) == "" {
+		return nil, false
+	}
+	return &hubRunner{hub: h, task: taskName}, true
+}
+
+// Agents returns names of online agents and their tasks
+func (h *Hub) Agents() map[string][]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	res := map[string][]string{}
+	for name, a := range h.agents {
+		if h.online(a) {
+			res[name] = a.tasks
+		}
+	}
+	return res
+}
+
+// agentFor returns the name of online agent owning the task, the first by name if many
+func (h *Hub) agentFor(taskName string) string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	names := make([]string, 0, len(h.agents))
+	for name := range h.agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		a := h.agents[name]
+		if !h.online(a) {
+			continue
+		}
+		for _, t := range a.tasks {
+			if strings.EqualFold(t, taskName) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+func (h *Hub) online(a *agentState) bool {
+	offlineAfter := h.OfflineAfter
+	if offlineAfter == 0 {
+		offlineAfter = 2 * time.Minute
+	}
+	return time.Since(a.lastSeen) < offlineAfter
+}
+
+// dispatch queues the job to agent
+func (h *Hub) dispatch(agentName string, j *hubJob) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	a, ok := h.agents[agentName]
+	if !ok {
+		return fmt.Errorf("agent %s not registered", agentName)
+	}
+	select {
+	case a.queue <- j:
+		h.jobs[j.ID] = j
+		return nil
+	default:
+		return fmt.Errorf("agent %s queue is full", agentName)
+	}
+}
+
+// requeue returns the job not received by agent to its queue, unless the job is canceled already
+func (h *Hub) requeue(a *agentState, j *hubJob) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.jobs[j.ID]; !ok {
+		return
+	}
+	select {
+	case a.queue <- j:
+	default:
+		j.done <- fmt.Errorf("agent %s queue is full", a.name)
+	}
+}
+
+// drop removes the job, it is called when job is completed or canceled
+func (h *Hub) drop(id string) {
+	h.mu.Lock()
+	delete(h.jobs, id)
+	h.mu.Unlock()
+}
+
+// job returns active job of the agent
+func (h *Hub) job(agentName, id string) (*hubJob, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	j, ok := h.jobs[id]
+	if !ok || j.agent != agentName {
+		return nil, false
+	}
+	return j, true
+}
+
+// auth middleware checks bearer token and puts agent name to request context
+func (h *Hub) auth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token != "" {
+			for name, t := range h.Tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+					next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), agentNameKey, name)))
+					return
+				}
+			}
+		}
+		http.Error(w, "rejected", http.StatusUnauthorized)
+	})
+}
+
+// POST /agent/register, body {"tasks": ["task1", "task2"]}
+func (h *Hub) registerCtrl(w http.ResponseWriter, r *http.Request) {
+	name := r.Context().Value(agentNameKey).(string)
+	req := struct {
+		Tasks []string `json:"tasks"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "failed to decode request", http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	a, ok := h.agents[name]
+	if !ok {
+		a = &agentState{name: name, queue: make(chan *hubJob, 100)}
+		h.agents[name] = a
+	}
+	a.tasks = req.Tasks
+	a.lastSeen = time.Now()
+	h.mu.Unlock()
+
+	log.Printf("[INFO] agent %s registered, tasks: %v", name, req.Tasks)
+	rest.RenderJSON(w, rest.JSON{"agent": name, "tasks": len(req.Tasks)})
+}
+
+// GET /agent/poll, waits for the next job. Responds with 204 if no job available during poll wait time
+// and with 409 if agent is not registered, i.e. after hub restart.
+func (h *Hub) pollCtrl(w http.ResponseWriter, r *http.Request) {
+	name := r.Context().Value(agentNameKey).(string)
+	h.mu.Lock()
+	a, ok := h.agents[name]
+	if ok {
+		a.lastSeen = time.Now()
+	}
+	h.mu.Unlock()
+	if !ok {
+		http.Error(w, "not registered", http.StatusConflict)
+		return
+	}
+
+	wait := h.PollWait
+	if wait == 0 {
+		wait = 30 * time.Second
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case j := <-a.queue:
+			if _, active := h.job(name, j.ID); !active {
+				continue // canceled while in queue
+			}
+			if err := sendJob(w, r, j.Job); err != nil {
+				// agent disconnected, the job is waiting for the next poll
+				log.Printf("[WARN] can't send job %s to agent %s, requeued, %v", j.ID, name, err)
+				h.requeue(a, j)
+				return
+			}
+			close(j.sent)
+			log.Printf("[INFO] job %s for task %s sent to agent %s", j.ID, j.Task, name)
+			return
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// sendJob writes the job to poll response, returns error if the agent can't get it
+func sendJob(w http.ResponseWriter, r *http.Request, j Job) error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return fmt.Errorf("can't marshal job: %w", err)
+	}
+	if err = r.Context().Err(); err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if _, err = w.Write(data); err != nil {
+		return fmt.Errorf("can't write job: %w", err)
+	}
+	if err = http.NewResponseController(w).Flush(); err != nil {
+		return fmt.Errorf("can't flush job: %w", err)
+	}
+	return nil
+}
+
+// POST /agent/jobs/{id}/log, body [{"stream": "stdout", "data": "output"}], chunks of job output in order of writes.
+// Empty list is a heartbeat. Responds with 410 if job is not active anymore, i.e. canceled on the hub side.
+func (h *Hub) logCtrl(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.job(r.Context().Value(agentNameKey).(string), r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not active", http.StatusGone)
+		return
+	}
+	chunks := []LogChunk{}
+	if err := json.NewDecoder(r.Body).Decode(&chunks); err != nil {
+		http.Error(w, "failed to decode request", http.StatusBadRequest)
+		return
+	}
+	for _, c := range chunks {
+		if c.Stream != streamStdout && c.Stream != streamStderr {
+			http.Error(w, "unknown stream", http.StatusBadRequest)
+			return
+		}
+	}
+	j.ack()
+	for _, c := range chunks {
+		out := j.stdout
+		if c.Stream == streamStderr {
+			out = j.stderr
+		}
+		if _, err := io.WriteString(out, c.Data); err != nil {
+			http.Error(w, "failed to write log", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// POST /agent/jobs/{id}/result, body {"error": "message", "exit_code": 1}
+func (h *Hub) resultCtrl(w http.ResponseWriter, r *http.Request) {
+	j, ok := h.job(r.Context().Value(agentNameKey).(string), r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not active", http.StatusGone)
+		return
+	}
+	res := Result{}
+	if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+		http.Error(w, "failed to decode request", http.StatusBadRequest)
+		return
+	}
+	j.ack()
+	var err error
+	if res.Error != "" {
+		err = &ExitError{Message: res.Error, Code: res.ExitCode}
+	}
+	select {
+	case j.done <- err:
+	default:
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// hubRunner executes task on agent
+type hubRunner struct {
+	hub  *Hub
+	task string
+}
+
+// Run dispatches job to agent and waits for its result. The command is ignored, agent runs its own task.
+// Agent streams stdout and stderr of the task, they are written to stdout and stderr. The job failed if agent doesn't report
+// anything during ack timeout after the job sent to it, i.e. the agent lost the poll response.
+func (r *hubRunner) Run(ctx context.Context, _ string, stdout, stderr io.Writer) error {
+	agentName := r.hub.agentFor(r.task)
+	if agentName == "" {
+		return fmt.Errorf("no online agent for task %s", r.task)
+	}
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	j := &hubJob{Job: Job{ID: id, Task: r.task, Params: task.ParamsFromContext(ctx)}, agent: agentName, stdout: stdout,
+		stderr: stderr, done: make(chan error, 1), sent: make(chan struct{}), acked: make(chan struct{})}
+	if err := r.hub.dispatch(agentName, j); err != nil {
+		return err
+	}
+	defer r.hub.drop(id)
+	log.Printf("[INFO] job %s for task %s queued to agent %s", id, r.task, agentName)
+
+	ackTimeout := r.hub.AckTimeout
+	if ackTimeout == 0 {
+		ackTimeout = 30 * time.Second
+	}
+	sent, acked := j.sent, j.acked
+	var ackTimer <-chan time.Time
+	for {
+		select {
+		case <-sent:
+			sent, ackTimer = nil, time.After(ackTimeout)
+		case <-acked:
+			acked, ackTimer = nil, nil
+		case <-ackTimer:
+			return fmt.Errorf("agent %s didn't confirm job %s in %v", agentName, id, ackTimeout)
+		case err := <-j.done:
+			if err != nil {
+				return fmt.Errorf("agent %s: %w", agentName, err)
+			}
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func newID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("can't make job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHub_Auth(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	resp := hubCall(t, ts.URL, "", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = hubCall(t, ts.URL, "bad", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string][]string{"agent1": {"t1"}}, hub.Agents())
+}
+
+func TestHub_PollNotRegistered(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: 10 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	resp := hubCall(t, ts.URL, "token1", http.MethodGet, "/agent/poll", "")
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = hubCall(t, ts.URL, "token1", http.MethodGet, "/agent/poll", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestHub_Runner(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1", "agent2": "token2"}, PollWait: time.Second}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	_, ok := hub.Runner("t1")
+	assert.False(t, ok, "no agents registered")
+
+	resp := hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/register", `{"tasks":["t1","t2"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = hubCall(t, ts.URL, "token2", http.MethodPost, "/agent/register", `{"tasks":["t3"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	r, ok := hub.Runner("T1")
+	require.True(t, ok)
+	_, ok = hub.Runner("t4")
+	assert.False(t, ok)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	var runErr error
+	go func() {
+		defer wg.Done()
+		runErr = r.Run(context.Background(), "", stdout, stderr)
+	}()
+
+	resp = hubCall(t, ts.URL, "token2", http.MethodGet, "/agent/poll", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "job not sent to agent without the task")
+
+	resp = hubCall(t, ts.URL, "token1", http.MethodGet, "/agent/poll", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	job := Job{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(t, "T1", job.Task)
+	assert.NotEmpty(t, job.ID)
+
+	resp = hubCall(t, ts.URL, "token2", http.MethodPost, "/agent/jobs/"+job.ID+"/log", `[]`)
+	assert.Equal(t, http.StatusGone, resp.StatusCode, "job belongs to another agent")
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/jobs/"+job.ID+"/log", `[{"stream":"stdout","data":"line1\n"}]`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/jobs/"+job.ID+"/log",
+		`[{"stream":"stderr","data":"warning\n"},{"stream":"stdout","data":"line2\n"}]`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/jobs/"+job.ID+"/log",
+		`[{"stream":"stdout","data":"line3\n"},{"stream":"bad","data":"line3\n"}]`)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "nothing written with unknown stream")
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/jobs/"+job.ID+"/log", "line3\n")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/jobs/"+job.ID+"/result", `{"error":"failed"}`)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	wg.Wait()
+	require.Error(t, runErr)
+	assert.Equal(t, "agent agent1: failed", runErr.Error())
+	assert.Equal(t, "line1\nline2\n", stdout.String())
+	assert.Equal(t, "warning\n", stderr.String())
+
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/jobs/"+job.ID+"/log", `[]`)
+	assert.Equal(t, http.StatusGone, resp.StatusCode, "job completed")
+}
+
+func TestHub_RunnerCanceled(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: 50 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	resp := hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	r, ok := hub.Runner("t1")
+	require.True(t, ok)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := r.Run(ctx, "", bytes.NewBuffer(nil), bytes.NewBuffer(nil))
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	resp = hubCall(t, ts.URL, "token1", http.MethodGet, "/agent/poll", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode, "canceled job not sent")
+}
+
+func TestHub_RequeueNotSent(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: time.Second}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	resp := hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	r, ok := hub.Runner("t1")
+	require.True(t, ok)
+	runErr := make(chan error, 1)
+	go func() { runErr <- r.Run(context.Background(), "", bytes.NewBuffer(nil), bytes.NewBuffer(nil)) }()
+
+	// agent disconnected, the response with the job can't be written
+	req := httptest.NewRequest(http.MethodGet, "/agent/poll", http.NoBody)
+	req.Header.Set("Authorization", "Bearer token1")
+	hub.ServeHTTP(&failWriter{ResponseRecorder: httptest.NewRecorder()}, req)
+
+	resp = hubCall(t, ts.URL, "token1", http.MethodGet, "/agent/poll", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode, "job sent with the next poll")
+	job := Job{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+	resp = hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/jobs/"+job.ID+"/result", `{}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.NoError(t, <-runErr)
+}
+
+func TestHub_AckTimeout(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: time.Second, AckTimeout: 50 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	resp := hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	r, ok := hub.Runner("t1")
+	require.True(t, ok)
+	runErr := make(chan error, 1)
+	go func() { runErr <- r.Run(context.Background(), "", bytes.NewBuffer(nil), bytes.NewBuffer(nil)) }()
+
+	resp = hubCall(t, ts.URL, "token1", http.MethodGet, "/agent/poll", "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	job := Job{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&job))
+
+	select {
+	case err := <-runErr:
+		require.Error(t, err)
+		assert.Equal(t, "agent agent1 didn't confirm job "+job.ID+" in 50ms", err.Error())
+	case <-time.After(time.Second):
+		t.Fatal("job not failed without ack")
+	}
+}
+
+func TestHub_Offline(t *testing.T) {
+	hub := &Hub{Tokens: map[string]string{"agent1": "token1"}, OfflineAfter: 50 * time.Millisecond}
+	ts := httptest.NewServer(hub)
+	defer ts.Close()
+
+	resp := hubCall(t, ts.URL, "token1", http.MethodPost, "/agent/register", `{"tasks":["t1"]}`)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	_, ok := hub.Runner("t1")
+	assert.True(t, ok)
+	time.Sleep(60 * time.Millisecond)
+	_, ok = hub.Runner("t1")
+	assert.False(t, ok)
+	assert.Empty(t, hub.Agents())
+}
+
+func hubCall(t *testing.T, url, token, method, path, body string) *http.Response {
+	req, err := http.NewRequest(method, url+path, strings.NewReader(body))
+	require.NoError(t, err)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+// failWriter fails to write response, as if the client disconnected
+type failWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w *failWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
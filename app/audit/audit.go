@@ -0,0 +1,194 @@
+// Package audit implements append-only audit log of requests. Records kept as JSON lines, every record
+// has a hash of its content and the hash of the previous record, so any change of the log is detectable.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Decisions of the request
+const (
+	Accepted = "accepted"
+	Rejected = "rejected"
+)
+
+// Record is a single audit record
+type Record struct {
+	Seq       int64             `json:"seq"`
+	Time      time.Time         `json:"time"`
+	ClientIP  string            `json:"client_ip"`
+	UserAgent string            `json:"user_agent,omitempty"`
+	Method    string            `json:"method"`
+	Path      string            `json:"path"`
+	Identity  string            `json:"identity,omitempty"` // authenticated key name, i.e. secret, admin or token:subject
+	Task      string            `json:"task,omitempty"`
+	Params    map[string]string `json:"params,omitempty"` // parameters with secret values masked
+	Status    int               `json:"status"`
+	Decision  string            `json:"decision"`
+	Reason    string            `json:"reason,omitempty"`
+	Job       string            `json:"job,omitempty"`
+	Prev      string            `json:"prev"` // hash of the previous record, empty for the first one
+	Hash      string            `json:"hash"`
+}
+
+// Log writes records to the file, and to syslog optionally
+type Log struct {
+	mu     sync.Mutex
+	file   *os.File
+	syslog io.Writer
+	seq    int64
+	last   string
+}
+
+// maxLine limits the size of a record
+const maxLine = 16 * 1024 * 1024
+
+// New opens the audit log file, creates it if missing. The chain continues from the last record of the file.
+// Optional syslog gets a copy of every record, the file can be empty to send records to syslog only.
+func New(path string, syslog io.Writer) (*Log, error) {
+	if path == "" {
+		return &Log{syslog: syslog}, nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600) //nolint:gosec // path from options
+	if err != nil {
+		return nil, fmt.Errorf("can't open audit log: %w", err)
+	}
+	res := &Log{file: f, syslog: syslog}
+	last, err := lastRecord(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("can't read audit log %s: %w", path, err)
+	}
+	if last != nil {
+		res.seq, res.last = last.Seq, last.Hash
+	}
+	return res, nil
+}
+
+// Record adds the record to the log, sequence number, hashes and time (if not set) filled in
+func (l *Log) Record(rec Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if rec.Time.IsZero() {
+		rec.Time = time.Now()
+	}
+	rec.Time = rec.Time.UTC()
+	rec.Seq, rec.Prev = l.seq+1, l.last
+	hash, err := rec.hash()
+	if err != nil {
+		return err
+	}
+	rec.Hash = hash
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("can't marshal audit record: %w", err)
+	}
+	if l.file != nil {
+		if _, err = l.file.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("can't write audit record: %w", err)
+		}
+	}
+	l.seq, l.last = rec.Seq, rec.Hash
+	if l.syslog != nil {
+		if _, err = l.syslog.Write(line); err != nil {
+			return fmt.Errorf("can't send audit record to syslog: %w", err)
+		}
+	}
+	return nil
+}
+
+// Close closes the log file
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
+
+// Verify checks sequence and hash chain of all records and returns the number of records.
+// The error points to the first broken record.
+func Verify(r io.Reader) (int64, error) {
+	var seq int64
+	var prev string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxLine)
+	for line := 1; scanner.Scan(); line++ {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return seq, fmt.Errorf("line %d: invalid record: %w", line, err)
+		}
+		if rec.Seq != seq+1 {
+			return seq, fmt.Errorf("line %d: unexpected sequence %d, expected %d", line, rec.Seq, seq+1)
+		}
+		if rec.Prev != prev {
+			return seq, fmt.Errorf("line %d: broken chain, previous hash mismatch", line)
+		}
+		hash, err := rec.hash()
+		if err != nil {
+			return seq, fmt.Errorf("line %d: %w", line, err)
+		}
+		if hash != rec.Hash {
+			return seq, fmt.Errorf("line %d: record modified, hash mismatch", line)
+		}
+		seq, prev = rec.Seq, rec.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return seq, fmt.Errorf("can't read audit log: %w", err)
+	}
+	return seq, nil
+}
+
+// hash returns sha256 of the record without its own hash, the record includes the hash of the previous one
+func (r Record) hash() (string, error) {
+	r.Hash = ""
+	data, err := json.Marshal(r)
+	if err != nil {
+		return "", fmt.Errorf("can't marshal audit record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// lastRecord returns the last record of the file, nil if the file is empty. The tail of the file read
+// in growing chunks until the beginning of the last line found.
+func lastRecord(f *os.File) (*Record, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	for chunk := int64(64 * 1024); ; chunk *= 4 {
+		size := min(fi.Size(), chunk)
+		buf := make([]byte, size)
+		if _, err = f.ReadAt(buf, fi.Size()-size); err != nil {
+			return nil, err
+		}
+		buf = bytes.TrimRight(buf, "\n")
+		if len(buf) == 0 {
+			return nil, nil
+		}
+		idx := bytes.LastIndexByte(buf, '\n')
+		if idx < 0 && size < fi.Size() {
+			if size >= maxLine {
+				return nil, errors.New("last record too long")
+			}
+			continue
+		}
+		var rec Record
+		if err = json.Unmarshal(buf[idx+1:], &rec); err != nil {
+			return nil, fmt.Errorf("invalid last record: %w", err)
+		}
+		return &rec, nil
+	}
+}
@@ -0,0 +1,129 @@
+package audit
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	syslog := &bytes.Buffer{}
+	l, err := New(path, syslog)
+	require.NoError(t, err)
+	require.NoError(t, l.Record(Record{ClientIP: "10.0.0.1", Method: "POST", Path: "/update", Identity: "secret",
+		Task: "deploy", Params: map[string]string{"VERSION": "1.0"}, Status: 200, Decision: Accepted, Job: "job1"}))
+	require.NoError(t, l.Record(Record{ClientIP: "10.0.0.2", Method: "GET", Path: "/update/deploy/***", Status: 403,
+		Decision: Rejected, Reason: "invalid key"}))
+	require.NoError(t, l.Close())
+
+	// reopened log continues the chain
+	l, err = New(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, l.Record(Record{ClientIP: "10.0.0.3", Method: "GET", Path: "/tasks", Status: 200, Decision: Accepted}))
+	require.NoError(t, l.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 3)
+	assert.Contains(t, lines[0], `"seq":1,`)
+	assert.Contains(t, lines[0], `"prev":"",`)
+	assert.Contains(t, lines[2], `"seq":3,`)
+	assert.Equal(t, lines[0]+lines[1], syslog.String(), "syslog gets the same records")
+
+	n, err := Verify(bytes.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(path, nil)
+	require.NoError(t, err)
+	for _, task := range []string{"t1", "t2", "t3"} {
+		require.NoError(t, l.Record(Record{Time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.Local), Task: task,
+			Status: 200, Decision: Accepted}))
+	}
+	require.NoError(t, l.Close())
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.SplitAfter(string(data), "\n")[:3]
+
+	tbl := []struct {
+		name string
+		log  string
+		n    int64
+		err  string
+	}{
+		{"empty", "", 0, ""},
+		{"valid", string(data), 3, ""},
+		{"modified", lines[0] + strings.Replace(lines[1], `"t2"`, `"t4"`, 1) + lines[2], 1,
+			"line 2: record modified, hash mismatch"},
+		{"removed", lines[0] + lines[2], 1, "line 2: unexpected sequence 3, expected 2"},
+		{"head removed", lines[1] + lines[2], 0, "line 1: unexpected sequence 2, expected 1"},
+		{"reordered", lines[1] + lines[0], 0, "line 1: unexpected sequence 2, expected 1"},
+		{"garbage", lines[0] + "garbage\n", 1, "line 2: invalid record: invalid character 'g' looking for beginning of value"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			n, err := Verify(strings.NewReader(tt.log))
+			assert.Equal(t, tt.n, n)
+			if tt.err != "" {
+				require.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestVerify_ForgedChain(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := New(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, l.Record(Record{Task: "t1", Decision: Accepted}))
+	require.NoError(t, l.Record(Record{Task: "t2", Decision: Accepted}))
+	require.NoError(t, l.Close())
+
+	// the first record replaced with a valid one, but the next record keeps the original previous hash
+	forged := filepath.Join(t.TempDir(), "forged.log")
+	fl, err := New(forged, nil)
+	require.NoError(t, err)
+	require.NoError(t, fl.Record(Record{Task: "forged", Decision: Accepted}))
+	require.NoError(t, fl.Close())
+	orig, err := os.ReadFile(path)
+	require.NoError(t, err)
+	first, err := os.ReadFile(forged)
+	require.NoError(t, err)
+
+	_, err = Verify(strings.NewReader(string(first) + strings.SplitAfter(string(orig), "\n")[1]))
+	require.EqualError(t, err, "line 2: broken chain, previous hash mismatch")
+}
+
+func TestLog_SyslogOnly(t *testing.T) {
+	syslog := &bytes.Buffer{}
+	l, err := New("", syslog)
+	require.NoError(t, err)
+	require.NoError(t, l.Record(Record{Task: "t1", Decision: Accepted}))
+	require.NoError(t, l.Record(Record{Task: "t2", Decision: Rejected}))
+	require.NoError(t, l.Close())
+	assert.Contains(t, syslog.String(), `"seq":2,`)
+}
+
+func TestNew_Errors(t *testing.T) {
+	dir := t.TempDir()
+	_, err := New(filepath.Join(dir, "missing", "audit.log"), nil)
+	require.Error(t, err)
+
+	path := filepath.Join(dir, "audit.log")
+	require.NoError(t, os.WriteFile(path, []byte("not json\n"), 0o600))
+	_, err = New(path, nil)
+	require.ErrorContains(t, err, "invalid last record")
+}
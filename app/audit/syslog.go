@@ -0,0 +1,18 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"fmt"
+	"io"
+	"log/syslog"
+)
+
+// Syslog returns writer sending records to local syslog with auth facility
+func Syslog(tag string) (io.Writer, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, fmt.Errorf("can't connect to syslog: %w", err)
+	}
+	return w, nil
+}
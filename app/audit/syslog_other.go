@@ -0,0 +1,13 @@
+//go:build windows || plan9
+
+package audit
+
+import (
+	"errors"
+	"io"
+)
+
+// Syslog is not supported on this platform
+func Syslog(string) (io.Writer, error) {
+	return nil, errors.New("syslog not supported")
+}
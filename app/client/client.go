@@ -0,0 +1,229 @@
+// Package client implements client of updater api. It triggers tasks, checks jobs and follows their logs.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client calls updater api
+type Client struct {
+	URL          string // base url of updater, i.e. http://localhost:8080
+	Key          string // secret key of updater
+	HTTPClient   *http.Client
+	PollInterval time.Duration // interval between job checks while waiting, default 1s
+	Sign         bool          // sign requests with HMAC instead of passing the key
+	TraceParent  string        // optional W3C trace context passed with requests, i.e. TRACEPARENT of CI job
+}
+
+// Job is a single execution of the task, as reported by updater
+type Job struct {
+	ID         string        `json:"id"`
+	Task       string        `json:"task"`
+	Status     string        `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	ExitCode   int           `json:"exit_code"`
+	TraceID    string        `json:"trace_id,omitempty"`
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Line is a single line of job output with the time it started and the stream, stdout or stderr
+type Line struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// Done returns true if the job completed, successfully or not
+func (j Job) Done() bool { return j.Status == "success" || j.Status == "failed" }
+
+// Trigger starts the task with parameters in background and returns id of the job
+func (c *Client) Trigger(ctx context.Context, taskName string, params map[string]string) (jobID string, err error) {
+	req := struct {
+		Task   string            `json:"task"`
+		Secret string            `json:"secret,omitempty"`
+		Async  bool              `json:"async"`
+		Params map[string]string `json:"params,omitempty"`
+	}{Task: taskName, Async: true, Params: params}
+	if !c.Sign {
+		req.Secret = c.Key
+	}
+
+	resp := struct {
+		Job string `json:"job"`
+	}{}
+	if err := c.call(ctx, http.MethodPost, "/update", req, &resp); err != nil {
+		return "", fmt.Errorf("can't trigger task %s: %w", taskName, err)
+	}
+	return resp.Job, nil
+}
+
+// Job returns job by id
+func (c *Client) Job(ctx context.Context, id string) (Job, error) {
+	res := Job{}
+	if err := c.call(ctx, http.MethodGet, "/jobs/"+url.PathEscape(id), nil, &res); err != nil {
+		return Job{}, fmt.Errorf("can't get job %s: %w", id, err)
+	}
+	return res, nil
+}
+
+// Logs returns job output starting from offset and the offset of the next portion
+func (c *Client) Logs(ctx context.Context, id string, offset int) (data string, next int, err error) {
+	resp := struct {
+		Data string `json:"data"`
+		Next int    `json:"next"`
+	}{}
+	path := fmt.Sprintf("/jobs/%s/logs?offset=%d", url.PathEscape(id), offset)
+	if err := c.call(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return "", 0, fmt.Errorf("can't get logs of job %s: %w", id, err)
+	}
+	return resp.Data, resp.Next, nil
+}
+
+// Lines returns lines of job output starting from line offset and the offset of the next portion
+func (c *Client) Lines(ctx context.Context, id string, offset int) (lines []Line, next int, err error) {
+	resp := struct {
+		Lines []Line `json:"lines"`
+		Next  int    `json:"next"`
+	}{}
+	path := fmt.Sprintf("/jobs/%s/lines?offset=%d", url.PathEscape(id), offset)
+	if err := c.call(ctx, http.MethodGet, path, nil, &resp); err != nil {
+		return nil, 0, fmt.Errorf("can't get lines of job %s: %w", id, err)
+	}
+	return resp.Lines, resp.Next, nil
+}
+
+// Wait waits for the job completion and returns the completed job.
+// If logWriter is set, the job output is copied to it as it goes.
+func (c *Client) Wait(ctx context.Context, id string, logWriter io.Writer) (Job, error) {
+	offset := 0
+	return c.wait(ctx, id, func() error {
+		if logWriter == nil {
+			return nil
+		}
+		data, next, err := c.Logs(ctx, id, offset)
+		if err != nil {
+			return err
+		}
+		_, _ = io.WriteString(logWriter, data)
+		offset = next
+		return nil
+	})
+}
+
+// WaitLines waits for the job completion like Wait, lines of the job output passed to fn as they go
+func (c *Client) WaitLines(ctx context.Context, id string, fn func(Line)) (Job, error) {
+	offset := 0
+	return c.wait(ctx, id, func() error {
+		lines, next, err := c.Lines(ctx, id, offset)
+		if err != nil {
+			return err
+		}
+		for _, l := range lines {
+			fn(l)
+		}
+		offset = next
+		return nil
+	})
+}
+
+// wait polls the job till completion, follow called after each check of the job
+func (c *Client) wait(ctx context.Context, id string, follow func() error) (Job, error) {
+	interval := c.PollInterval
+	if interval == 0 {
+		interval = time.Second
+	}
+	for {
+		job, err := c.Job(ctx, id)
+		if err != nil {
+			return Job{}, err
+		}
+		if err = follow(); err != nil {
+			return Job{}, err
+		}
+		if job.Done() {
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return Job{}, ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// authorize sets signature headers if signing enabled, or passes the key as bearer token
+func (c *Client) authorize(req *http.Request, body []byte) error {
+	if !c.Sign {
+		req.Header.Set("Authorization", "Bearer "+c.Key)
+		return nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("can't make nonce: %w", err)
+	}
+	ts, nonce := strconv.FormatInt(time.Now().Unix(), 10), hex.EncodeToString(b)
+	mac := hmac.New(sha256.New, []byte(c.Key))
+	mac.Write([]byte(req.Method + "\n" + req.URL.RequestURI() + "\n" + ts + "\n" + nonce + "\n"))
+	mac.Write(body)
+	req.Header.Set("X-Updater-Timestamp", ts)
+	req.Header.Set("X-Updater-Nonce", nonce)
+	req.Header.Set("X-Updater-Signature", hex.EncodeToString(mac.Sum(nil)))
+	return nil
+}
+
+func (c *Client) call(ctx context.Context, method, path string, body, res any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("can't marshal request: %w", err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.URL, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("can't make request: %w", err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.TraceParent != "" {
+		req.Header.Set("traceparent", c.TraceParent)
+	}
+	if err = c.authorize(req, data); err != nil {
+		return err
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("status %d, %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return fmt.Errorf("can't decode response: %w", err)
+	}
+	return nil
+}
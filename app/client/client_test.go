@@ -0,0 +1,134 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_TriggerAndWait(t *testing.T) {
+	var checks int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /update", func(w http.ResponseWriter, r *http.Request) {
+		req := struct {
+			Task   string            `json:"task"`
+			Secret string            `json:"secret"`
+			Async  bool              `json:"async"`
+			Params map[string]string `json:"params"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal(t, "deploy", req.Task)
+		assert.Equal(t, "12345", req.Secret)
+		assert.True(t, req.Async)
+		assert.Equal(t, map[string]string{"VERSION": "1.2"}, req.Params)
+		assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", r.Header.Get("traceparent"))
+		_, _ = w.Write([]byte(`{"submitted":"ok","task":"deploy","job":"job1"}`))
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer 12345", r.Header.Get("Authorization"))
+		if atomic.AddInt32(&checks, 1) < 3 {
+			_, _ = w.Write([]byte(`{"id":"job1","task":"deploy","status":"running"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"job1","task":"deploy","status":"failed","error":"exit status 3","exit_code":3}`))
+	})
+	mux.HandleFunc("GET /jobs/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer 12345", r.Header.Get("Authorization"))
+		switch r.URL.Query().Get("offset") {
+		case "0":
+			_, _ = w.Write([]byte(`{"data":"line1\n","next":6}`))
+		case "6":
+			_, _ = w.Write([]byte(`{"data":"line2\n","next":12}`))
+		default:
+			_, _ = w.Write([]byte(`{"data":"","next":12}`))
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cl := &Client{URL: ts.URL + "/", Key: "12345", PollInterval: 10 * time.Millisecond, TraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	id, err := cl.Trigger(context.Background(), "deploy", map[string]string{"VERSION": "1.2"})
+	require.NoError(t, err)
+	assert.Equal(t, "job1", id)
+
+	lw := bytes.NewBuffer(nil)
+	job, err := cl.Wait(context.Background(), id, lw)
+	require.NoError(t, err)
+	assert.Equal(t, Job{ID: "job1", Task: "deploy", Status: "failed", Error: "exit status 3", ExitCode: 3}, job)
+	assert.True(t, job.Done())
+	assert.Equal(t, "line1\nline2\n", lw.String())
+	assert.Equal(t, int32(3), atomic.LoadInt32(&checks))
+
+	data, next, err := cl.Logs(context.Background(), id, 6)
+	require.NoError(t, err)
+	assert.Equal(t, "line2\n", data)
+	assert.Equal(t, 12, next)
+}
+
+func TestClient_WaitLines(t *testing.T) {
+	var checks int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		if atomic.AddInt32(&checks, 1) < 2 {
+			_, _ = w.Write([]byte(`{"id":"job1","task":"deploy","status":"running"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"id":"job1","task":"deploy","status":"success"}`))
+	})
+	mux.HandleFunc("GET /jobs/{id}/lines", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("offset") {
+		case "0":
+			_, _ = w.Write([]byte(`{"lines":[{"time":"2024-05-01T10:00:00Z","stream":"stdout","text":"line1"}],"next":1}`))
+		case "1":
+			_, _ = w.Write([]byte(`{"lines":[{"time":"2024-05-01T10:00:02Z","stream":"stderr","text":"line2"}],"next":2}`))
+		default:
+			_, _ = w.Write([]byte(`{"lines":[],"next":2}`))
+		}
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cl := &Client{URL: ts.URL, Key: "12345", PollInterval: 10 * time.Millisecond}
+	var lines []Line
+	job, err := cl.WaitLines(context.Background(), "job1", func(l Line) { lines = append(lines, l) })
+	require.NoError(t, err)
+	assert.Equal(t, "success", job.Status)
+	assert.Equal(t, []Line{
+		{Time: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC), Stream: "stdout", Text: "line1"},
+		{Time: time.Date(2024, 5, 1, 10, 0, 2, 0, time.UTC), Stream: "stderr", Text: "line2"},
+	}, lines)
+
+	res, next, err := cl.Lines(context.Background(), "job1", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 2, next)
+	require.Len(t, res, 1)
+	assert.Equal(t, "line2", res[0].Text)
+}
+
+func TestClient_Errors(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /update", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unknown parameter X", http.StatusBadRequest)
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "job not found", http.StatusNotFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cl := &Client{URL: ts.URL, Key: "12345"}
+	_, err := cl.Trigger(context.Background(), "deploy", map[string]string{"X": "1"})
+	assert.EqualError(t, err, "can't trigger task deploy: status 400, unknown parameter X")
+	_, err = cl.Job(context.Background(), "bad")
+	assert.EqualError(t, err, "can't get job bad: status 404, job not found")
+	_, err = cl.Wait(context.Background(), "bad", nil)
+	assert.EqualError(t, err, "can't get job bad: status 404, job not found")
+}
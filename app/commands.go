@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/umputun/updater/app/audit"
+	"github.com/umputun/updater/app/client"
+	"github.com/umputun/updater/app/keyhash"
+)
+
+// serverOpts defines updater server used by client commands
+type serverOpts struct {
+	Server string `long:"server" env:"SERVER" default:"http://localhost:8080" description:"updater server url"`
+	Sign   bool   `long:"sign" env:"SIGN" description:"sign requests instead of passing the key"`
+}
+
+type triggerCmd struct {
+	serverOpts
+	Params []string `long:"param" description:"task parameter, name=value"`
+	Wait   bool     `long:"wait" description:"wait for task completion"`
+	Follow bool     `long:"follow" description:"wait for task completion and show its output"`
+	Args   struct {
+		Task string `positional-arg-name:"task"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type statusCmd struct {
+	serverOpts
+	Args struct {
+		Job string `positional-arg-name:"job"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type logsCmd struct {
+	serverOpts
+	Follow     bool `long:"follow" description:"follow the output until job completion"`
+	Timestamps bool `short:"t" long:"timestamps" description:"show time and stream of each line"`
+	Args       struct {
+		Job string `positional-arg-name:"job"`
+	} `positional-args:"yes" required:"yes"`
+}
+
+type hashKeyCmd struct {
+	Algo string `long:"algo" choice:"bcrypt" choice:"argon2id" default:"bcrypt" description:"hash algorithm"`
+	Args struct {
+		Key string `positional-arg-name:"key"`
+	} `positional-args:"yes"`
+}
+
+type auditCmd struct {
+	Verify struct {
+		Args struct {
+			File string `positional-arg-name:"file"`
+		} `positional-args:"yes"`
+	} `command:"verify" description:"verify audit log, --audit-log file if not set"`
+}
+
+// runCommand executes client command and returns exit code, it is the exit code of the task if waited for
+func runCommand(ctx context.Context, name string, out io.Writer) (int, error) {
+	switch name {
+	case "trigger":
+		return runTrigger(ctx, opts.Trigger, out)
+	case "status":
+		cl := &client.Client{URL: opts.Status.Server, Key: opts.SecretKey, Sign: opts.Status.Sign}
+		job, err := cl.Job(ctx, opts.Status.Args.Job)
+		if err != nil {
+			return 1, err
+		}
+		printJob(out, job)
+		return 0, nil
+	case "logs":
+		cl := &client.Client{URL: opts.Logs.Server, Key: opts.SecretKey, Sign: opts.Logs.Sign}
+		if opts.Logs.Timestamps {
+			return runLines(ctx, cl, opts.Logs.Args.Job, opts.Logs.Follow, out)
+		}
+		if opts.Logs.Follow {
+			job, err := cl.Wait(ctx, opts.Logs.Args.Job, out)
+			if err != nil {
+				return 1, err
+			}
+			return job.ExitCode, nil
+		}
+		data, _, err := cl.Logs(ctx, opts.Logs.Args.Job, 0)
+		if err != nil {
+			return 1, err
+		}
+		_, _ = io.WriteString(out, data)
+		return 0, nil
+	case "hash-key":
+		return runHashKey(opts.HashKey, os.Stdin, out)
+	case "audit":
+		file := opts.Audit.Verify.Args.File
+		if file == "" {
+			file = opts.AuditLog
+		}
+		return runAuditVerify(file, out)
+	}
+	return 1, fmt.Errorf("unknown command %s", name)
+}
+
+// runLines prints lines of the job output with time and stream, follows the output till job completion if set
+func runLines(ctx context.Context, cl *client.Client, id string, follow bool, out io.Writer) (int, error) {
+	printLine := func(l client.Line) {
+		_, _ = fmt.Fprintf(out, "%s %s %s\n", l.Time.Local().Format("2006-01-02 15:04:05.000"), l.Stream, l.Text)
+	}
+	if follow {
+		job, err := cl.WaitLines(ctx, id, printLine)
+		if err != nil {
+			return 1, err
+		}
+		return job.ExitCode, nil
+	}
+	lines, _, err := cl.Lines(ctx, id, 0)
+	if err != nil {
+		return 1, err
+	}
+	for _, l := range lines {
+		printLine(l)
+	}
+	return 0, nil
+}
+
+func runTrigger(ctx context.Context, cmd triggerCmd, out io.Writer) (int, error) {
+	params := map[string]string{}
+	for _, p := range cmd.Params {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || k == "" {
+			return 1, fmt.Errorf("invalid parameter %q, expected name=value", p)
+		}
+		params[k] = v
+	}
+
+	// trace of CI job continued by updater, if the job runs with TRACEPARENT
+	cl := &client.Client{URL: cmd.Server, Key: opts.SecretKey, Sign: cmd.Sign, TraceParent: os.Getenv("TRACEPARENT")}
+	id, err := cl.Trigger(ctx, cmd.Args.Task, params)
+	if err != nil {
+		return 1, err
+	}
+	_, _ = fmt.Fprintf(out, "job %s submitted\n", id)
+	if !cmd.Wait && !cmd.Follow {
+		return 0, nil
+	}
+
+	var logWriter io.Writer
+	if cmd.Follow {
+		logWriter = out
+	}
+	job, err := cl.Wait(ctx, id, logWriter)
+	if err != nil {
+		return 1, err
+	}
+	printJob(out, job)
+	return job.ExitCode, nil
+}
+
+// runHashKey prints hash of the key, the key read from the first line of input if not passed as argument
+func runHashKey(cmd hashKeyCmd, in io.Reader, out io.Writer) (int, error) {
+	key := cmd.Args.Key
+	if key == "" {
+		line, err := bufio.NewReader(in).ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 1, fmt.Errorf("can't read key: %w", err)
+		}
+		key = strings.TrimRight(line, "\r\n")
+	}
+	hash, err := keyhash.Hash(key, cmd.Algo)
+	if err != nil {
+		return 1, err
+	}
+	_, _ = fmt.Fprintln(out, hash)
+	return 0, nil
+}
+
+// runAuditVerify checks hash chain of the audit log
+func runAuditVerify(file string, out io.Writer) (int, error) {
+	if file == "" {
+		return 1, errors.New("audit log file required")
+	}
+	f, err := os.Open(file) //nolint:gosec // file from options
+	if err != nil {
+		return 1, fmt.Errorf("can't open audit log: %w", err)
+	}
+	defer f.Close() //nolint:errcheck // read only
+	n, err := audit.Verify(f)
+	if err != nil {
+		return 1, fmt.Errorf("audit log %s is broken after %d valid records, %w", file, n, err)
+	}
+	_, _ = fmt.Fprintf(out, "audit log %s verified, %d records\n", file, n)
+	return 0, nil
+}
+
+func printJob(out io.Writer, job client.Job) {
+	_, _ = fmt.Fprintf(out, "job %s, task %s, status %s", job.ID, job.Task, job.Status)
+	if job.Done() {
+		_, _ = fmt.Fprintf(out, ", duration %s, exit code %d", job.Duration, job.ExitCode)
+	}
+	if job.Error != "" {
+		_, _ = fmt.Fprintf(out, ", error: %s", job.Error)
+	}
+	_, _ = fmt.Fprintln(out)
+}
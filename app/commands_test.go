@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/audit"
+	"github.com/umputun/updater/app/keyhash"
+)
+
+func TestRunCommand(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /update", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"submitted":"ok","task":"deploy","job":"job1"}`))
+	})
+	mux.HandleFunc("GET /jobs/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"id":"job1","task":"deploy","status":"failed","error":"exit status 3","exit_code":3}`))
+	})
+	mux.HandleFunc("GET /jobs/{id}/logs", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"data":"line1\n","next":6}`))
+	})
+	mux.HandleFunc("GET /jobs/{id}/lines", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(`{"lines":[{"time":"2024-05-01T10:00:00Z","stream":"stderr","text":"line1"}],"next":1}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	opts.SecretKey = "12345"
+	defer func() { opts.SecretKey = "" }()
+
+	out := bytes.NewBuffer(nil)
+	cmd := triggerCmd{serverOpts: serverOpts{Server: ts.URL}, Params: []string{"VERSION=1.2"}}
+	cmd.Args.Task = "deploy"
+	code, err := runTrigger(context.Background(), cmd, out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "job job1 submitted\n", out.String())
+
+	out.Reset()
+	cmd.Follow = true
+	code, err = runTrigger(context.Background(), cmd, out)
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, "job job1 submitted\nline1\njob job1, task deploy, status failed, duration 0s, exit code 3, "+
+		"error: exit status 3\n", out.String())
+
+	cmd.Params = []string{"VERSION"}
+	_, err = runTrigger(context.Background(), cmd, out)
+	assert.EqualError(t, err, `invalid parameter "VERSION", expected name=value`)
+
+	out.Reset()
+	opts.Status.Server, opts.Status.Args.Job = ts.URL, "job1"
+	code, err = runCommand(context.Background(), "status", out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Contains(t, out.String(), "job job1, task deploy, status failed")
+
+	out.Reset()
+	opts.Logs.Server, opts.Logs.Args.Job = ts.URL, "job1"
+	code, err = runCommand(context.Background(), "logs", out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "line1\n", out.String())
+
+	out.Reset()
+	opts.Logs.Timestamps = true
+	defer func() { opts.Logs.Timestamps = false }()
+	code, err = runCommand(context.Background(), "logs", out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	ts0 := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Local().Format("2006-01-02 15:04:05.000")
+	assert.Equal(t, ts0+" stderr line1\n", out.String())
+
+	out.Reset()
+	opts.Logs.Follow = true
+	defer func() { opts.Logs.Follow = false }()
+	code, err = runCommand(context.Background(), "logs", out)
+	require.NoError(t, err)
+	assert.Equal(t, 3, code)
+	assert.Equal(t, ts0+" stderr line1\n", out.String())
+}
+
+func TestRunHashKey(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	cmd := hashKeyCmd{Algo: keyhash.Bcrypt}
+	cmd.Args.Key = "secret"
+	code, err := runHashKey(cmd, strings.NewReader(""), out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.True(t, keyhash.Match(strings.TrimSpace(out.String()), "secret"))
+
+	out.Reset()
+	cmd = hashKeyCmd{Algo: keyhash.Argon2id}
+	code, err = runHashKey(cmd, strings.NewReader("secret2\n"), out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.True(t, strings.HasPrefix(out.String(), "$argon2id$"))
+	assert.True(t, keyhash.Match(strings.TrimSpace(out.String()), "secret2"), "key read from input")
+
+	_, err = runHashKey(hashKeyCmd{Algo: keyhash.Bcrypt}, strings.NewReader(""), out)
+	assert.EqualError(t, err, "empty key")
+}
+
+func TestRunAuditVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := audit.New(path, nil)
+	require.NoError(t, err)
+	require.NoError(t, l.Record(audit.Record{Task: "deploy", Decision: audit.Accepted}))
+	require.NoError(t, l.Record(audit.Record{Task: "deploy", Decision: audit.Rejected}))
+	require.NoError(t, l.Close())
+
+	out := bytes.NewBuffer(nil)
+	code, err := runAuditVerify(path, out)
+	require.NoError(t, err)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "audit log "+path+" verified, 2 records\n", out.String())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, bytes.Replace(data, []byte("rejected"), []byte("accepted"), 1), 0o600))
+	code, err = runAuditVerify(path, out)
+	assert.Equal(t, 1, code)
+	assert.EqualError(t, err, "audit log "+path+" is broken after 1 valid records, line 2: record modified, hash mismatch")
+
+	_, err = runAuditVerify("", out)
+	assert.EqualError(t, err, "audit log file required")
+}
@@ -0,0 +1,244 @@
+// Package events publishes job lifecycle events as CloudEvents in structured JSON mode to http endpoints.
+// Events delivered in background, each endpoint gets events in the order they were published. Failed delivery
+// retried with growing delay, events not delivered after all retries written to dead-letter file.
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// Types of job events
+const (
+	JobQueued    = "com.github.umputun.updater.job.queued"
+	JobStarted   = "com.github.umputun.updater.job.started"
+	JobStep      = "com.github.umputun.updater.job.step"
+	JobSucceeded = "com.github.umputun.updater.job.succeeded"
+	JobFailed    = "com.github.umputun.updater.job.failed"
+)
+
+const (
+	specVersion       = "1.0"
+	contentType       = "application/cloudevents+json"
+	queueSize         = 1000 // events waiting for delivery to a single endpoint
+	defaultRetryDelay = time.Second
+	maxRetryDelay     = time.Minute
+)
+
+// Event is a single CloudEvents event
+type Event struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"` // id of the job
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+	TraceParent     string    `json:"traceparent,omitempty"` // distributed tracing extension, trace of the job
+	Data            any       `json:"data,omitempty"`
+}
+
+// Opts defines endpoints and delivery of events
+type Opts struct {
+	Endpoints  []string      // urls receiving events
+	Source     string        // source of events, i.e. external url of updater
+	Retries    int           // delivery attempts after the failed one, no retries if 0
+	RetryDelay time.Duration // delay before the first retry, doubled with every next one, 1s if not set
+	DeadLetter string        // file of events failed to deliver, such events only logged if empty
+	Client     *http.Client  // http client, with 10s timeout if not set
+}
+
+// Bus delivers published events to endpoints, every endpoint has its own queue
+type Bus struct {
+	opts   Opts
+	queues map[string]chan Event
+
+	mu     sync.RWMutex // guards closed, queues are not written after close
+	closed bool
+
+	ctx    context.Context // canceled when close time is over, stops retries and requests
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	dlMu sync.Mutex
+}
+
+// New makes bus and starts delivery of events to endpoints
+func New(opts Opts) *Bus {
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	b := &Bus{opts: opts, queues: make(map[string]chan Event, len(opts.Endpoints))}
+	b.ctx, b.cancel = context.WithCancel(context.Background())
+	for _, endpoint := range opts.Endpoints {
+		queue := make(chan Event, queueSize)
+		b.queues[endpoint] = queue
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+			for ev := range queue {
+				b.deliver(endpoint, ev)
+			}
+		}()
+	}
+	return b
+}
+
+// Publish queues the event to all endpoints, it doesn't wait for delivery. Spec version, id, source and time
+// (if not set) filled in. The event written to dead-letter file if the queue of endpoint is full.
+func (b *Bus) Publish(ev Event) {
+	ev.SpecVersion, ev.ID, ev.Source = specVersion, newID(), b.opts.Source
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	if ev.Data != nil && ev.DataContentType == "" {
+		ev.DataContentType = "application/json"
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for endpoint, queue := range b.queues {
+		if b.closed {
+			b.deadLetter(endpoint, ev, errors.New("event bus closed"))
+			continue
+		}
+		select {
+		case queue <- ev:
+		default:
+			b.deadLetter(endpoint, ev, errors.New("queue of endpoint is full"))
+		}
+	}
+}
+
+// Close stops accepting events and waits until queued events delivered. When ctx is done, retries and
+// requests are stopped and events not delivered yet written to dead-letter file.
+func (b *Bus) Close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		for _, queue := range b.queues {
+			close(queue)
+		}
+	}
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		b.cancel()
+		return nil
+	case <-ctx.Done():
+		b.cancel()
+		<-done
+		return fmt.Errorf("events not delivered on close: %w", ctx.Err())
+	}
+}
+
+// deliver sends the event to endpoint with retries, the event written to dead-letter file if not delivered
+func (b *Bus) deliver(endpoint string, ev Event) {
+	body, err := json.Marshal(ev)
+	if err != nil {
+		b.deadLetter(endpoint, ev, fmt.Errorf("can't marshal event: %w", err))
+		return
+	}
+	delay := b.opts.RetryDelay
+	for attempt := 0; ; attempt++ {
+		if attempt == 0 && b.ctx.Err() != nil {
+			b.deadLetter(endpoint, ev, errors.New("event bus closed"))
+			return
+		}
+		retry, err := b.send(endpoint, body)
+		if err == nil {
+			return
+		}
+		if !retry || attempt >= b.opts.Retries {
+			b.deadLetter(endpoint, ev, err)
+			return
+		}
+		log.Printf("[DEBUG] event %s to %s failed, retry in %v, %v", ev.ID, endpoint, delay, err)
+		select {
+		case <-b.ctx.Done():
+			b.deadLetter(endpoint, ev, err)
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxRetryDelay)
+	}
+}
+
+// send posts the event to endpoint, returns true if failed delivery can be retried
+func (b *Bus) send(endpoint string, body []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(b.ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return false, fmt.Errorf("can't make request: %w", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := b.opts.Client.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("can't send event: %w", err)
+	}
+	defer resp.Body.Close() //nolint:errcheck // response body not used
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+	return retry, fmt.Errorf("event rejected, status %d", resp.StatusCode)
+}
+
+// deadLetter is a record of dead-letter file
+type deadLetter struct {
+	Time     time.Time `json:"time"`
+	Endpoint string    `json:"endpoint"`
+	Error    string    `json:"error"`
+	Event    Event     `json:"event"`
+}
+
+// deadLetter writes the event failed to deliver to dead-letter file as JSON line
+func (b *Bus) deadLetter(endpoint string, ev Event, reason error) {
+	log.Printf("[WARN] event %s %s of %s not delivered to %s, %v", ev.Type, ev.ID, ev.Subject, endpoint, reason)
+	if b.opts.DeadLetter == "" {
+		return
+	}
+	data, err := json.Marshal(deadLetter{Time: time.Now(), Endpoint: endpoint, Error: reason.Error(), Event: ev})
+	if err != nil {
+		log.Printf("[WARN] can't marshal dead letter, %v", err)
+		return
+	}
+	b.dlMu.Lock()
+	defer b.dlMu.Unlock()
+	f, err := os.OpenFile(b.opts.DeadLetter, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640) //nolint:gosec // path from options
+	if err != nil {
+		log.Printf("[WARN] can't open dead-letter file, %v", err)
+		return
+	}
+	defer f.Close() //nolint:errcheck // write error checked
+	if _, err = f.Write(append(data, '\n')); err != nil {
+		log.Printf("[WARN] can't write dead-letter file, %v", err)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
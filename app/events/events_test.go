@@ -0,0 +1,190 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus_Publish(t *testing.T) {
+	var mu sync.Mutex
+	var received []Event
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "application/cloudevents+json", r.Header.Get("Content-Type"))
+		ev := Event{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&ev))
+		mu.Lock()
+		received = append(received, ev)
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	b := New(Opts{Endpoints: []string{ts.URL, ts.URL + "/second"}, Source: "https://updater.example.com"})
+	for _, typ := range []string{JobQueued, JobStarted, JobSucceeded} {
+		b.Publish(Event{Type: typ, Subject: "job1", Data: map[string]string{"task": "deploy"}})
+	}
+	require.NoError(t, b.Close(context.Background()))
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Len(t, received, 6)
+	var types []string
+	for _, ev := range received {
+		assert.Equal(t, "1.0", ev.SpecVersion)
+		assert.Len(t, ev.ID, 32)
+		assert.Equal(t, "https://updater.example.com", ev.Source)
+		assert.Equal(t, "job1", ev.Subject)
+		assert.Equal(t, "application/json", ev.DataContentType)
+		assert.WithinDuration(t, time.Now(), ev.Time, time.Minute)
+		assert.Equal(t, map[string]any{"task": "deploy"}, ev.Data)
+		types = append(types, ev.Type)
+	}
+	assert.Equal(t, 2, strings.Count(strings.Join(types, " "), JobQueued))
+	ids := map[string]bool{}
+	for _, ev := range received {
+		ids[ev.ID] = true
+	}
+	assert.Len(t, ids, 3, "the same event sent to both endpoints")
+}
+
+func TestBus_Retries(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "dead.json")
+	b := New(Opts{Endpoints: []string{ts.URL}, Retries: 3, RetryDelay: time.Millisecond, DeadLetter: deadLetter})
+	b.Publish(Event{Type: JobStarted, Subject: "job1"})
+	require.NoError(t, b.Close(context.Background()))
+	assert.Equal(t, int32(3), attempts.Load())
+	assert.NoFileExists(t, deadLetter)
+}
+
+func TestBus_DeadLetter(t *testing.T) {
+	var attempts atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		if r.URL.Path == "/bad" {
+			http.Error(w, "bad event", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "dead.json")
+	b := New(Opts{Endpoints: []string{ts.URL + "/bad", ts.URL + "/failed"}, Retries: 2, RetryDelay: time.Millisecond,
+		DeadLetter: deadLetter})
+	b.Publish(Event{Type: JobFailed, Subject: "job1"})
+	require.NoError(t, b.Close(context.Background()))
+	assert.Equal(t, int32(4), attempts.Load(), "rejected event not retried, failed one retried twice")
+
+	b.Publish(Event{Type: JobFailed, Subject: "job2"}) // bus closed
+
+	recs := readDeadLetters(t, deadLetter)
+	require.Len(t, recs, 4)
+	errs := map[string]string{}
+	for _, rec := range recs {
+		errs[rec.Event.Subject+" "+strings.TrimPrefix(rec.Endpoint, ts.URL)] = rec.Error
+		assert.Equal(t, JobFailed, rec.Event.Type)
+	}
+	assert.Equal(t, map[string]string{
+		"job1 /bad":    "event rejected, status 400",
+		"job1 /failed": "event rejected, status 500",
+		"job2 /bad":    "event bus closed",
+		"job2 /failed": "event bus closed",
+	}, errs)
+}
+
+func TestBus_CloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	deadLetter := filepath.Join(t.TempDir(), "dead.json")
+	b := New(Opts{Endpoints: []string{ts.URL}, Retries: 5, DeadLetter: deadLetter})
+	b.Publish(Event{Type: JobStarted, Subject: "job1"})
+	b.Publish(Event{Type: JobSucceeded, Subject: "job1"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	st := time.Now()
+	require.EqualError(t, b.Close(ctx), "events not delivered on close: context deadline exceeded")
+	assert.Less(t, time.Since(st), 5*time.Second)
+
+	recs := readDeadLetters(t, deadLetter)
+	require.Len(t, recs, 2)
+	assert.Equal(t, JobStarted, recs[0].Event.Type)
+	assert.Contains(t, recs[0].Error, "can't send event")
+	assert.Equal(t, JobSucceeded, recs[1].Event.Type)
+	assert.Equal(t, "event bus closed", recs[1].Error)
+}
+
+func TestBus_QueueFull(t *testing.T) {
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer ts.Close()
+
+	deadLetter := filepath.Join(t.TempDir(), "dead.json")
+	b := New(Opts{Endpoints: []string{ts.URL}, DeadLetter: deadLetter})
+	for range queueSize + 2 { // one event may be taken by delivery already
+		b.Publish(Event{Type: JobStep, Subject: "job1"})
+	}
+	close(release)
+	recs := readDeadLetters(t, deadLetter)
+	require.NotEmpty(t, recs)
+	assert.Equal(t, "queue of endpoint is full", recs[0].Error)
+	require.NoError(t, b.Close(context.Background()))
+}
+
+func readDeadLetters(t *testing.T, file string) []deadLetter {
+	t.Helper()
+	fh, err := os.Open(file) //nolint:gosec // test file
+	if os.IsNotExist(err) {
+		return nil
+	}
+	require.NoError(t, err)
+	defer fh.Close() //nolint:errcheck // read only
+	data, err := io.ReadAll(fh)
+	require.NoError(t, err)
+	var res []deadLetter
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if line == "" {
+			continue
+		}
+		rec := deadLetter{}
+		require.NoError(t, json.Unmarshal([]byte(line), &rec))
+		res = append(res, rec)
+	}
+	return res
+}
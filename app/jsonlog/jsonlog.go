@@ -0,0 +1,97 @@
+// Package jsonlog converts lines of lgr logger to json records, one record per line.
+// Logger set up with the format returned by Format and writes to Writer, output of jobs written
+// with Job writer carries job id, task, trigger of the job and the output stream.
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Record is a single json line of the log
+type Record struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Message string    `json:"msg"`
+	Caller  string    `json:"caller,omitempty"`
+	Job     string    `json:"job,omitempty"`
+	Task    string    `json:"task,omitempty"`
+	Trigger string    `json:"trigger,omitempty"`
+	Stream  string    `json:"stream,omitempty"`
+}
+
+// Format returns lgr template of the lines parsed by Writer, caller info added in debug mode only
+// as it is expensive to collect. Fields separated by tabs, the message goes last as it may have tabs.
+func Format(dbg bool) string {
+	if dbg {
+		return "{{.Level}}\t{{.CallerFile}}:{{.CallerLine}} {{.CallerFunc}}\t{{.Message}}"
+	}
+	return "{{.Level}}\t\t{{.Message}}"
+}
+
+// Writer writes lines of lgr formatted with Format as json records
+type Writer struct {
+	out io.Writer
+	now func() time.Time
+	mu  sync.Mutex
+}
+
+// New makes Writer writing json records to out
+func New(out io.Writer) *Writer {
+	return &Writer{out: out, now: time.Now}
+}
+
+// Write converts log line to json record. Logger writes the whole line at once, so no buffering needed.
+func (w *Writer) Write(p []byte) (int, error) {
+	rec := Record{Level: "INFO", Message: strings.TrimSuffix(string(p), "\n")}
+	if parts := strings.SplitN(rec.Message, "\t", 3); len(parts) == 3 {
+		rec.Level, rec.Caller, rec.Message = strings.TrimSpace(parts[0]), parts[1], parts[2]
+	}
+	if err := w.write(rec); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Job returns writer of job output stream, each line of the output written as info record with job id, task,
+// trigger and stream
+func (w *Writer) Job(id, taskName, trigger, stream string) io.Writer {
+	return &jobWriter{w: w, rec: Record{Level: "INFO", Job: id, Task: taskName, Trigger: trigger, Stream: stream}}
+}
+
+func (w *Writer) write(rec Record) error {
+	rec.Time = w.now()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("can't marshal log record: %w", err)
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if _, err = w.out.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("can't write log record: %w", err)
+	}
+	return nil
+}
+
+type jobWriter struct {
+	w   *Writer
+	rec Record
+}
+
+// Write makes a record of each line, the output usually comes line by line from masker.
+// Incomplete line written as is, without waiting for the rest of it.
+func (j *jobWriter) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimSuffix(p, []byte("\n")), []byte("\n")) {
+		rec := j.rec
+		rec.Message = strings.TrimSuffix(string(line), "\r")
+		if err := j.w.write(rec); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
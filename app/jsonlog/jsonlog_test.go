@@ -0,0 +1,106 @@
+package jsonlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriter_Logger(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := New(&buf)
+	w.now = func() time.Time { return time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC) }
+
+	l := log.New(log.Out(w), log.Err(w), log.Format(Format(false)))
+	l.Logf("[INFO] task started")
+	l.Logf("[WARN] some\twarning\nsecond line")
+	l.Logf("[ERROR] failed, %v", errors.New("oops"))
+	l.Logf("no level")
+
+	recs := parse(t, buf.String())
+	require.Len(t, recs, 4, "error written once, out and err are the same writer")
+	assert.Equal(t, Record{Time: w.now(), Level: "INFO", Message: "task started"}, recs[0])
+	assert.Equal(t, Record{Time: w.now(), Level: "WARN", Message: "some\twarning\nsecond line"}, recs[1])
+	assert.Equal(t, Record{Time: w.now(), Level: "ERROR", Message: "failed, oops"}, recs[2])
+	assert.Equal(t, Record{Time: w.now(), Level: "INFO", Message: "no level"}, recs[3])
+	assert.Contains(t, buf.String(), `{"time":"2024-05-06T07:08:09Z","level":"INFO","msg":"task started"}`+"\n")
+}
+
+func TestWriter_LoggerDebug(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := New(&buf)
+	l := log.New(log.Out(w), log.Err(w), log.Debug, log.Format(Format(true)))
+	l.Logf("[DEBUG] details")
+
+	recs := parse(t, buf.String())
+	require.Len(t, recs, 1)
+	assert.Equal(t, "DEBUG", recs[0].Level)
+	assert.Equal(t, "details", recs[0].Message)
+	assert.Contains(t, recs[0].Caller, "jsonlog/jsonlog_test.go:")
+	assert.Contains(t, recs[0].Caller, "TestWriter_LoggerDebug")
+}
+
+func TestWriter_NotFormatted(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := New(&buf)
+	n, err := w.Write([]byte("plain line\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 11, n)
+	recs := parse(t, buf.String())
+	require.Len(t, recs, 1)
+	assert.Equal(t, "INFO", recs[0].Level)
+	assert.Equal(t, "plain line", recs[0].Message)
+}
+
+func TestWriter_Job(t *testing.T) {
+	buf := bytes.Buffer{}
+	w := New(&buf)
+	jw := w.Job("abc123", "task1", "secret", "stderr")
+
+	n, err := jw.Write([]byte("line1\r\nline2\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 13, n)
+	_, err = jw.Write([]byte("no newline"))
+	require.NoError(t, err)
+
+	recs := parse(t, buf.String())
+	require.Len(t, recs, 3)
+	for i, msg := range []string{"line1", "line2", "no newline"} {
+		assert.Equal(t, "INFO", recs[i].Level)
+		assert.Equal(t, msg, recs[i].Message)
+		assert.Equal(t, "abc123", recs[i].Job)
+		assert.Equal(t, "task1", recs[i].Task)
+		assert.Equal(t, "secret", recs[i].Trigger)
+		assert.Equal(t, "stderr", recs[i].Stream)
+	}
+}
+
+func TestWriter_Error(t *testing.T) {
+	w := New(errWriter{})
+	_, err := w.Write([]byte("INFO \t\tmsg\n"))
+	require.EqualError(t, err, "can't write log record: write failed")
+	_, err = w.Job("id", "task", "", "").Write([]byte("msg\n"))
+	require.EqualError(t, err, "can't write log record: write failed")
+}
+
+func parse(t *testing.T, s string) []Record {
+	t.Helper()
+	var res []Record
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		var rec Record
+		require.NoError(t, json.Unmarshal([]byte(line), &rec), line)
+		res = append(res, rec)
+	}
+	return res
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("write failed") }
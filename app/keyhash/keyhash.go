@@ -0,0 +1,142 @@
+// Package keyhash hashes keys with bcrypt or argon2id and matches keys against plain or hashed values.
+// Argon2id hashes use PHC string format, i.e. $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+package keyhash
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// supported algorithms
+const (
+	Bcrypt   = "bcrypt"
+	Argon2id = "argon2id"
+)
+
+// argon2id parameters, as recommended by OWASP
+const (
+	argonMemory  = 19 * 1024
+	argonTime    = 2
+	argonThreads = 1
+	argonKeyLen  = 32
+	argonSaltLen = 16
+
+	argonMaxMemory = 1 << 20 // max memory of argon2id hash to match, in KiB
+)
+
+// verified keeps digests of hash and key pairs matched already, so expensive hash comparison done once per key
+var verified sync.Map
+
+// Hash makes hash of the key with given algorithm
+func Hash(key, alg string) (string, error) {
+	if key == "" {
+		return "", errors.New("empty key")
+	}
+	switch alg {
+	case Bcrypt:
+		res, err := bcrypt.GenerateFromPassword([]byte(key), bcrypt.DefaultCost)
+		if err != nil {
+			return "", fmt.Errorf("can't make bcrypt hash: %w", err)
+		}
+		return string(res), nil
+	case Argon2id:
+		salt := make([]byte, argonSaltLen)
+		if _, err := rand.Read(salt); err != nil {
+			return "", fmt.Errorf("can't make salt: %w", err)
+		}
+		hash := argon2.IDKey([]byte(key), salt, argonTime, argonMemory, argonThreads, argonKeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, argonMemory, argonTime, argonThreads,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+	}
+	return "", fmt.Errorf("unknown algorithm %q", alg)
+}
+
+// IsHash returns true if the value is bcrypt or argon2id hash
+func IsHash(val string) bool {
+	return isBcrypt(val) || strings.HasPrefix(val, "$argon2id$")
+}
+
+// Match checks the key against expected value, plain or hashed. Comparison is constant time for plain values.
+// Empty expected value never matches.
+func Match(expected, key string) bool {
+	if expected == "" {
+		return false
+	}
+	if !IsHash(expected) {
+		return subtle.ConstantTimeCompare([]byte(key), []byte(expected)) == 1
+	}
+	digest := sha256.Sum256([]byte(expected + "\x00" + key))
+	if _, ok := verified.Load(digest); ok {
+		return true
+	}
+	var ok bool
+	if isBcrypt(expected) {
+		ok = bcrypt.CompareHashAndPassword([]byte(expected), []byte(key)) == nil
+	} else {
+		ok = matchArgon2id(expected, key)
+	}
+	if ok {
+		verified.Store(digest, true)
+	}
+	return ok
+}
+
+func isBcrypt(val string) bool {
+	return strings.HasPrefix(val, "$2a$") || strings.HasPrefix(val, "$2b$") || strings.HasPrefix(val, "$2y$")
+}
+
+func matchArgon2id(expected, key string) bool {
+	p, err := parseArgon2id(expected)
+	if err != nil {
+		return false
+	}
+	actual := argon2.IDKey([]byte(key), p.salt, p.time, p.memory, p.threads, uint32(len(p.hash))) //nolint:gosec // hash length is small
+	return subtle.ConstantTimeCompare(actual, p.hash) == 1
+}
+
+// argonParams are parameters of argon2id hash with its salt and key
+type argonParams struct {
+	memory, time uint32
+	threads      uint8
+	salt, hash   []byte
+}
+
+// parseArgon2id parses argon2id hash in PHC format. Zero parameters, too much memory and empty salt or key rejected,
+// as argon2 panics on some of them.
+func parseArgon2id(val string) (argonParams, error) {
+	parts := strings.Split(val, "$") // "", "argon2id", "v=19", "m=..,t=..,p=..", salt, hash
+	if len(parts) != 6 {
+		return argonParams{}, errors.New("invalid argon2id hash format")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argonParams{}, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+	res := argonParams{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &res.memory, &res.time, &res.threads); err != nil {
+		return argonParams{}, fmt.Errorf("invalid argon2id parameters %q: %w", parts[3], err)
+	}
+	if res.memory == 0 || res.time == 0 || res.threads == 0 {
+		return argonParams{}, fmt.Errorf("invalid argon2id parameters %q, must be positive", parts[3])
+	}
+	if res.memory > argonMaxMemory {
+		return argonParams{}, fmt.Errorf("argon2id memory %d above the limit %d", res.memory, argonMaxMemory)
+	}
+	var err error
+	if res.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(res.salt) == 0 {
+		return argonParams{}, errors.New("invalid argon2id salt")
+	}
+	if res.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(res.hash) == 0 {
+		return argonParams{}, errors.New("invalid argon2id key")
+	}
+	return res, nil
+}
@@ -0,0 +1,74 @@
+package keyhash
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashAndMatch(t *testing.T) {
+	for _, alg := range []string{Bcrypt, Argon2id} {
+		t.Run(alg, func(t *testing.T) {
+			hash, err := Hash("secret", alg)
+			require.NoError(t, err)
+			assert.True(t, IsHash(hash))
+			assert.NotContains(t, hash, "secret")
+
+			assert.True(t, Match(hash, "secret"))
+			assert.True(t, Match(hash, "secret"), "cached match")
+			assert.False(t, Match(hash, "secret1"))
+			assert.False(t, Match(hash, ""))
+
+			other, err := Hash("secret", alg)
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, other, "salted")
+		})
+	}
+
+	_, err := Hash("secret", "md5")
+	assert.EqualError(t, err, `unknown algorithm "md5"`)
+	_, err = Hash("", Bcrypt)
+	assert.EqualError(t, err, "empty key")
+}
+
+func TestMatch(t *testing.T) {
+	tbl := []struct {
+		expected, key string
+		res           bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "secret2", false},
+		{"", "", false},
+		{"$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy", "wrong", false},
+		{"$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHQ$aGFzaA", "secret", false},
+		{"$argon2id$v=19$bad", "secret", false},
+		{"$argon2id$v=18$m=19456,t=2,p=1$c2FsdHNhbHQ$aGFzaA", "secret", false},
+		{"$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHQ$aGFzaA", "secret", false},
+		{"$argon2id$v=19$m=19456,t=2,p=0$c2FsdHNhbHQ$aGFzaA", "secret", false},
+		{"$argon2id$v=19$m=0,t=2,p=1$c2FsdHNhbHQ$aGFzaA", "secret", false},
+		{"$argon2id$v=19$m=4294967295,t=2,p=1$c2FsdHNhbHQ$aGFzaA", "secret", false},
+		{"$argon2id$v=19$m=19456,t=2,p=1$$aGFzaA", "secret", false},
+		{"$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHQ$", "secret", false},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.res, Match(tt.expected, tt.key), tt.expected)
+	}
+
+	for _, val := range []string{"$argon2id$v=19$m=19456,t=0,p=1$c2FsdHNhbHQ$aGFzaA",
+		"$argon2id$v=19$m=19456,t=2,p=0$c2FsdHNhbHQ$aGFzaA", "$argon2id$v=19$m=0,t=2,p=1$c2FsdHNhbHQ$aGFzaA"} {
+		_, err := parseArgon2id(val)
+		assert.ErrorContains(t, err, "must be positive", val)
+	}
+	_, err := parseArgon2id("$argon2id$v=19$m=19456,t=2,p=1$$aGFzaA")
+	assert.EqualError(t, err, "invalid argon2id salt")
+	_, err = parseArgon2id("$argon2id$v=19$m=19456,t=2,p=1$c2FsdHNhbHQ$")
+	assert.EqualError(t, err, "invalid argon2id key")
+
+	// argon2id hash in PHC format
+	hash, err := Hash("secret", Argon2id)
+	require.NoError(t, err)
+	_, err = parseArgon2id(hash)
+	require.NoError(t, err)
+	assert.Regexp(t, `^\$argon2id\$v=19\$m=19456,t=2,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`, hash)
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -13,27 +15,105 @@ import (
 	"github.com/go-pkgz/syncs"
 	"github.com/umputun/go-flags"
 
+	"github.com/umputun/updater/app/agent"
+	"github.com/umputun/updater/app/audit"
+	"github.com/umputun/updater/app/events"
+	"github.com/umputun/updater/app/jsonlog"
+	"github.com/umputun/updater/app/keyhash"
+	"github.com/umputun/updater/app/oidc"
 	"github.com/umputun/updater/app/server"
+	"github.com/umputun/updater/app/systemd"
 	"github.com/umputun/updater/app/task"
+	"github.com/umputun/updater/app/tracing"
 )
 
 var revision string
 
 var opts struct {
-	Config      string        `short:"f" long:"file" env:"CONF" default:"updater.yml" description:"config file"`
-	Listen      string        `short:"l" long:"listen" env:"LISTEN" default:"localhost:8080" description:"listen on host:port"`
-	SecretKey   string        `short:"k" long:"key" env:"KEY" required:"true" description:"secret key"`
-	Batch       bool          `short:"b" long:"batch" description:"batch mode for multi-line scripts"`
-	Limit       int           `long:"limit" default:"10" description:"limit how many concurrent update can be running"`
-	TimeOut     time.Duration `long:"timeout" default:"1m" description:"for how long batch update task can be running"`
-	UpdateDelay time.Duration `long:"update-delay" default:"1s" description:"delay between updates"`
-	Dbg         bool          `long:"dbg" env:"DEBUG" description:"show debug info"`
+	Config      string            `short:"f" long:"file" env:"CONF" default:"updater.yml" description:"config file"`
+	Listen      string            `short:"l" long:"listen" env:"LISTEN" default:"localhost:8080" description:"listen on host:port or unix:///path/to.sock"`
+	SocketMode  string            `long:"socket-mode" env:"SOCKET_MODE" default:"0660" description:"permissions of unix socket"`
+	SecretKey   string            `short:"k" long:"key" env:"KEY" description:"secret key, plain or bcrypt/argon2id hash"`
+	TLSCert     string            `long:"tls-cert" env:"TLS_CERT" description:"tls certificate file"`
+	TLSKey      string            `long:"tls-key" env:"TLS_KEY" description:"tls key file"`
+	TLSClientCA string            `long:"tls-client-ca" env:"TLS_CLIENT_CA" description:"CA bundle verifying client certificates"`
+	Batch       bool              `short:"b" long:"batch" description:"batch mode for multi-line scripts"`
+	Limit       int               `long:"limit" default:"10" description:"limit how many concurrent update can be running"`
+	TimeOut     time.Duration     `long:"timeout" default:"1m" description:"for how long batch update task can be running"`
+	UpdateDelay time.Duration     `long:"update-delay" default:"1s" description:"delay between updates"`
+	Compose     string            `long:"compose" env:"COMPOSE" default:"docker compose" description:"compose command for compose tasks"`
+	AdminKey    string            `long:"admin-key" env:"ADMIN_KEY" description:"admin key, enables web dashboard and admin api"`
+	SecretsFile string            `long:"secrets-file" env:"SECRETS_FILE" description:"age encrypted yaml file with secrets"`
+	SecretsKey  string            `long:"secrets-key" env:"SECRETS_KEY" description:"age identity file decrypting secrets file"`
+	StateFile   string            `long:"state" env:"STATE" description:"file keeping disabled tasks and deploy freeze"`
+	FreezeQueue bool              `long:"freeze-queue" env:"FREEZE_QUEUE" description:"queue triggers during deploy freeze"`
+	Approvers   map[string]string `long:"approver" env:"APPROVERS" env-delim:"," description:"approver name and key, name:key"`
+	Notify      string            `long:"approval-notify" env:"APPROVAL_NOTIFY" description:"url receiving pending approvals"`
+	BaseURL     string            `long:"base-url" env:"BASE_URL" description:"external url of updater, used in links, required for approvals"`
+	SignedOnly  bool              `long:"signed-only" env:"SIGNED_ONLY" description:"accept signed requests only"`
+	MaxSkew     time.Duration     `long:"max-skew" env:"MAX_SKEW" default:"5m" description:"max clock skew of signed requests"`
+	NonceFile   string            `long:"nonce-file" env:"NONCE_FILE" description:"file keeping nonces of signed requests"`
+	Proxies     []string          `long:"trusted-proxies" env:"TRUSTED_PROXIES" env-delim:"," description:"trusted proxies passing client ip, ip or network"`
+	MaxFailures int               `long:"max-auth-failures" env:"MAX_AUTH_FAILURES" default:"10" description:"failed authentications from ip before ban"`
+	BanDuration time.Duration     `long:"ban-duration" env:"BAN_DURATION" default:"15m" description:"how long ip banned for"`
+	Grace       time.Duration     `long:"shutdown-grace" env:"SHUTDOWN_GRACE" default:"1m" description:"how long running jobs waited for on shutdown"`
+	Deadline    time.Duration     `long:"shutdown-deadline" env:"SHUTDOWN_DEADLINE" default:"2m" description:"hard deadline of shutdown"`
+	AuditLog    string            `long:"audit-log" env:"AUDIT_LOG" description:"audit log file of requests"`
+	AuditSyslog bool              `long:"audit-syslog" env:"AUDIT_SYSLOG" description:"send audit records to syslog"`
+	Agents      map[string]string `long:"agent" env:"AGENTS" env-delim:"," description:"enable hub mode, agent name and token, name:token"`
+	LogFormat   string            `long:"log-format" env:"LOG_FORMAT" choice:"text" choice:"json" default:"text" description:"log format"`
+	Dbg         bool              `long:"dbg" env:"DEBUG" description:"show debug info"`
+
+	JobLogs struct {
+		Dir      string        `long:"dir" env:"DIR" description:"directory of per-job log files, enables job logs"`
+		MaxAge   time.Duration `long:"max-age" env:"MAX_AGE" default:"720h" description:"remove job logs older than this, 0 to keep"`
+		MaxFiles int           `long:"max-files" env:"MAX_FILES" default:"1000" description:"max number of job logs kept, 0 for unlimited"`
+		MaxSize  int64         `long:"max-size" env:"MAX_SIZE" default:"10485760" description:"max size of job log in bytes, 0 for unlimited"`
+	} `group:"job-logs" namespace:"job-logs" env-namespace:"JOB_LOGS"`
+
+	Trace struct {
+		Exporter string  `long:"exporter" env:"EXPORTER" choice:"none" choice:"otlp" choice:"stdout" choice:"file" default:"none" description:"exporter of spans, enables tracing"`
+		Endpoint string  `long:"endpoint" env:"ENDPOINT" description:"otlp http endpoint url (default: OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318)"`
+		File     string  `long:"file" env:"FILE" description:"file of spans for file exporter"`
+		Ratio    float64 `long:"ratio" env:"RATIO" default:"1" description:"ratio of sampled traces"`
+	} `group:"trace" namespace:"trace" env-namespace:"TRACE"`
+
+	Events struct {
+		URLs       []string      `long:"url" env:"URL" env-delim:"," description:"url receiving job events as CloudEvents, enables events"`
+		Retries    int           `long:"retries" env:"RETRIES" default:"5" description:"retries of failed event delivery"`
+		RetryDelay time.Duration `long:"retry-delay" env:"RETRY_DELAY" default:"1s" description:"delay before the first retry, doubled with every next one"`
+		DeadLetter string        `long:"dead-letter" env:"DEAD_LETTER" description:"file keeping events failed to deliver"`
+	} `group:"events" namespace:"events" env-namespace:"EVENTS"`
+
+	Hub struct {
+		URL   string `long:"url" env:"URL" description:"hub url, runs as agent of the hub if set"`
+		Token string `long:"token" env:"TOKEN" description:"agent token"`
+	} `group:"hub" namespace:"hub" env-namespace:"HUB"`
+
+	ACME struct {
+		Domains   []string `long:"domain" env:"DOMAIN" env-delim:"," description:"domain to issue certificate for, enables acme"`
+		CacheDir  string   `long:"cache" env:"CACHE" default:"var/acme" description:"directory keeping issued certificates"`
+		Email     string   `long:"email" env:"EMAIL" description:"contact email of acme account"`
+		Directory string   `long:"directory" env:"DIRECTORY" description:"acme directory url (default: Let's Encrypt)"`
+	} `group:"acme" namespace:"acme" env-namespace:"ACME"`
+
+	OIDC struct {
+		JWKSURL  string `long:"jwks-url" env:"JWKS_URL" description:"url of JWKS verifying bearer tokens"`
+		JWKSFile string `long:"jwks-file" env:"JWKS_FILE" description:"local JWKS file verifying bearer tokens"`
+		Issuer   string `long:"issuer" env:"ISSUER" description:"expected token issuer"`
+		Audience string `long:"audience" env:"AUDIENCE" description:"expected token audience, required with jwks"`
+	} `group:"oidc" namespace:"oidc" env-namespace:"OIDC"`
+
+	Trigger triggerCmd `command:"trigger" description:"trigger task on updater server"`
+	Status  statusCmd  `command:"status" description:"show job status"`
+	Logs    logsCmd    `command:"logs" description:"show job output"`
+	HashKey hashKeyCmd `command:"hash-key" description:"make bcrypt or argon2id hash of the key"`
+	Audit   auditCmd   `command:"audit" description:"audit log tools"`
 }
 
 func main() {
-	fmt.Printf("updater %s\n", revision)
-
 	p := flags.NewParser(&opts, flags.PassDoubleDash|flags.HelpFlag)
+	p.SubcommandsOptional = true
 	if _, err := p.Parse(); err != nil {
 		if err.(*flags.Error).Type != flags.ErrHelp {
 			fmt.Printf("%v\n", err)
@@ -42,7 +122,26 @@ func main() {
 		p.WriteHelp(os.Stderr)
 		os.Exit(2)
 	}
-	setupLog(opts.Dbg)
+	jsonLog := setupLog(opts.Dbg, opts.LogFormat)
+	localCmd := p.Active != nil && (p.Active.Name == "hash-key" || p.Active.Name == "audit")
+	if opts.SecretKey == "" && !localCmd {
+		fmt.Println("the required flag `-k, --key' was not specified")
+		os.Exit(1)
+	}
+
+	if p.Active != nil {
+		// client commands call updater server, local commands work without it. Output goes to stdout as is
+		code, err := runCommand(context.Background(), p.Active.Name, os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		os.Exit(code)
+	}
+	if jsonLog != nil {
+		log.Printf("[INFO] updater %s", revision) // stdout has json records only
+	} else {
+		fmt.Printf("updater %s\n", revision)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -63,16 +162,176 @@ func main() {
 	if err != nil {
 		log.Fatalf("[ERROR] can't load config %q, %v", opts.Config, err)
 	}
-	runner := &task.ShellRunner{BatchMode: opts.Batch, Limiter: syncs.NewSemaphore(opts.Limit), TimeOut: opts.TimeOut}
+	for _, t := range conf.Tasks {
+		if !t.ApprovalRequired() {
+			continue
+		}
+		if opts.BaseURL == "" {
+			// approval links can't be made from the request host, it is set by the client
+			log.Fatalf("[ERROR] task %s requires approval, set --base-url for approval links", t.Name)
+		}
+		if len(opts.Approvers) == 0 && opts.AdminKey == "" {
+			log.Printf("[WARN] task %s requires approval, but no approvers set", t.Name)
+		}
+	}
+	if opts.SignedOnly && keyhash.IsHash(opts.SecretKey) {
+		log.Fatalf("[ERROR] signed requests require plain secret key, hashed key can't be used with --signed-only")
+	}
+	socketMode, err := strconv.ParseUint(opts.SocketMode, 8, 32)
+	if err != nil {
+		log.Fatalf("[ERROR] invalid socket mode %q, %v", opts.SocketMode, err)
+	}
+	proxies, err := task.ParseCIDRs(opts.Proxies)
+	if err != nil {
+		log.Fatalf("[ERROR] invalid trusted proxies, %v", err)
+	}
+	allowCIDRs, _ := task.ParseCIDRs(conf.AllowCIDRs) // validated on config load
+	denyCIDRs, _ := task.ParseCIDRs(conf.DenyCIDRs)
+	masker, err := conf.Masker()
+	if err != nil {
+		log.Fatalf("[ERROR] can't make masker of secrets, %v", err)
+	}
+	secrets, err := conf.LoadSecrets(opts.SecretsFile, opts.SecretsKey)
+	if err != nil {
+		log.Fatalf("[ERROR] can't load secrets, %v", err)
+	}
+	if len(secrets) > 0 {
+		log.Printf("[INFO] %d secrets loaded", len(secrets))
+	}
+	for _, v := range secrets {
+		masker.Add(v) // secrets from environment are inherited by all tasks, so masked in the output of any task
+	}
+	limiter := syncs.NewSemaphore(opts.Limit)
+	runner := &task.ShellRunner{BatchMode: opts.Batch, Limiter: limiter, TimeOut: opts.TimeOut}
+	dispatcher := &task.Dispatcher{Config: conf, ComposeCmd: opts.Compose, BatchMode: opts.Batch, Limiter: limiter}
+
+	var hub *agent.Hub
+	if len(opts.Agents) > 0 {
+		hub = &agent.Hub{Tokens: opts.Agents}
+	}
 
 	srv := server.Rest{
-		Listen:      opts.Listen,
+		Listen:     opts.Listen,
+		SocketMode: os.FileMode(socketMode),
+		TLS: server.TLSOpts{CertFile: opts.TLSCert, KeyFile: opts.TLSKey, ClientCA: opts.TLSClientCA,
+			ACMEDomains: opts.ACME.Domains, ACMECacheDir: opts.ACME.CacheDir, ACMEEmail: opts.ACME.Email,
+			ACMEDirectory: opts.ACME.Directory},
 		Version:     revision,
 		SecretKey:   opts.SecretKey,
 		Config:      conf,
 		Runner:      runner,
 		UpdateDelay: opts.UpdateDelay,
 		Timeout:     opts.TimeOut,
+		Limit:       opts.Limit,
+		AdminKey:    opts.AdminKey,
+		StateFile:   opts.StateFile,
+		FreezeQueue: opts.FreezeQueue,
+
+		Approvers:      opts.Approvers,
+		ApprovalNotify: opts.Notify,
+		BaseURL:        opts.BaseURL,
+
+		RequireSignature: opts.SignedOnly,
+		MaxSkew:          opts.MaxSkew,
+		NonceFile:        opts.NonceFile,
+
+		TrustedProxies:  proxies,
+		AllowCIDRs:      allowCIDRs,
+		DenyCIDRs:       denyCIDRs,
+		MaxAuthFailures: opts.MaxFailures,
+		BanDuration:     opts.BanDuration,
+		Masker:          masker,
+		Secrets:         secrets,
+
+		JSONLog: jsonLog,
+		JobLogs: server.JobLogsOpts{Dir: opts.JobLogs.Dir, MaxAge: opts.JobLogs.MaxAge, MaxFiles: opts.JobLogs.MaxFiles,
+			MaxSize: opts.JobLogs.MaxSize},
+
+		ShutdownGrace:    opts.Grace,
+		ShutdownDeadline: opts.Deadline,
+		RunnerFor: func(name string) (server.Runner, bool) {
+			if _, ok := conf.GetTask(name); ok || hub == nil {
+				return dispatcher.Runner(name) // local tasks take priority over tasks of agents
+			}
+			return hub.Runner(name)
+		},
+	}
+	if opts.OIDC.JWKSURL != "" || opts.OIDC.JWKSFile != "" || opts.OIDC.Issuer != "" {
+		if opts.OIDC.Audience == "" {
+			// without audience tokens issued for any other service would be accepted
+			log.Fatalf("[ERROR] oidc audience required, set --oidc.audience")
+		}
+		log.Printf("[INFO] oidc tokens enabled, issuer %q, audience %q", opts.OIDC.Issuer, opts.OIDC.Audience)
+		srv.TokenVerifier = &oidc.Verifier{JWKSURL: opts.OIDC.JWKSURL, JWKSFile: opts.OIDC.JWKSFile,
+			Issuer: opts.OIDC.Issuer, Audience: opts.OIDC.Audience}
+	}
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		log.Fatalf("[ERROR] socket activation failed, %v", err)
+	}
+	if len(listeners) > 0 {
+		log.Printf("[INFO] systemd socket activation, %d listeners", len(listeners))
+		srv.Listener = listeners[0]
+	}
+	if opts.AuditLog != "" || opts.AuditSyslog {
+		auditLog, err := makeAuditLog(opts.AuditLog, opts.AuditSyslog)
+		if err != nil {
+			log.Fatalf("[ERROR] can't make audit log, %v", err)
+		}
+		defer auditLog.Close() //nolint:errcheck // closed on exit
+		srv.Audit = auditLog
+	}
+	traceOpts := tracing.Opts{Exporter: opts.Trace.Exporter, Endpoint: opts.Trace.Endpoint, File: opts.Trace.File,
+		Ratio: opts.Trace.Ratio, Service: "updater", Version: revision}
+	shutdownTracing, err := tracing.Setup(ctx, traceOpts)
+	if err != nil {
+		log.Fatalf("[ERROR] can't set up tracing, %v", err)
+	}
+	if traceOpts.Enabled() {
+		log.Printf("[INFO] tracing enabled, exporter %s, ratio %v", opts.Trace.Exporter, opts.Trace.Ratio)
+	}
+	defer func() {
+		// pending spans flushed on exit
+		flushCtx, flushCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer flushCancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}()
+	if len(opts.Events.URLs) > 0 {
+		source := opts.BaseURL
+		if source == "" {
+			source = "updater"
+		}
+		bus := events.New(events.Opts{Endpoints: opts.Events.URLs, Source: source, Retries: opts.Events.Retries,
+			RetryDelay: opts.Events.RetryDelay, DeadLetter: opts.Events.DeadLetter})
+		log.Printf("[INFO] job events enabled, endpoints: %d", len(opts.Events.URLs))
+		defer func() {
+			// events of the last jobs delivered on exit
+			closeCtx, closeCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer closeCancel()
+			if err := bus.Close(closeCtx); err != nil {
+				log.Printf("[WARN] %v", err)
+			}
+		}()
+		srv.Events = bus
+	}
+	if hub != nil {
+		log.Printf("[INFO] hub mode, agents: %d", len(opts.Agents))
+		srv.AgentHub = hub
+	}
+
+	if opts.Hub.URL != "" {
+		tasks := make([]string, 0, len(conf.Tasks))
+		for _, t := range conf.Tasks {
+			tasks = append(tasks, t.Name)
+		}
+		agt := &agent.Agent{HubURL: opts.Hub.URL, Token: opts.Hub.Token, Tasks: tasks, Executor: &srv}
+		go func() {
+			if err := agt.Run(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("[ERROR] agent failed, %v", err)
+			}
+		}()
 	}
 
 	if err := srv.Run(ctx); err != nil {
@@ -82,10 +341,34 @@ func main() {
 	}
 }
 
-func setupLog(dbg bool) {
+// makeAuditLog opens audit log file and connects to syslog if enabled
+func makeAuditLog(file string, toSyslog bool) (*audit.Log, error) {
+	var syslog io.Writer
+	if toSyslog {
+		var err error
+		if syslog, err = audit.Syslog("updater"); err != nil {
+			return nil, err
+		}
+	}
+	log.Printf("[INFO] audit log %q, syslog: %v", file, toSyslog)
+	return audit.New(file, syslog)
+}
+
+// setupLog sets up logger, returns json writer in json format used to log output of jobs with job details
+func setupLog(dbg bool, format string) *jsonlog.Writer {
+	if format == "json" {
+		jw := jsonlog.New(os.Stdout)
+		opts := []log.Option{log.Out(jw), log.Err(jw), log.Format(jsonlog.Format(dbg))}
+		if dbg {
+			opts = append(opts, log.Debug)
+		}
+		log.Setup(opts...)
+		return jw
+	}
 	if dbg {
 		log.Setup(log.Debug, log.CallerFile, log.CallerFunc, log.Msec, log.LevelBraces)
-		return
+		return nil
 	}
 	log.Setup(log.Msec, log.LevelBraces)
+	return nil
 }
@@ -0,0 +1,204 @@
+// Package mask redacts secrets in the output of tasks. Secrets are literal values, i.e. passwords and tokens,
+// and regex patterns.
+package mask
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Mask replaces secrets in the output
+const Mask = "****"
+
+// MinLength is the minimal length of masked value, shorter values are ignored to keep the output readable
+const MinLength = 3
+
+// maxPending limits the size of incomplete line kept by the writer, the longer line written without waiting
+// for newline, except for the tail which may be the beginning of a secret
+const maxPending = 64 * 1024
+
+// Masker keeps secret values and patterns. Nil Masker is valid and has nothing to mask.
+type Masker struct {
+	mu       sync.RWMutex
+	values   map[string]struct{}
+	patterns []*regexp.Regexp
+}
+
+// New makes Masker with regex patterns. If the pattern has groups only groups are masked,
+// i.e. `password=(\S+)` keeps "password=" in the output.
+func New(patterns ...string) (*Masker, error) {
+	res := &Masker{values: map[string]struct{}{}}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mask pattern %q: %w", p, err)
+		}
+		res.patterns = append(res.patterns, re)
+	}
+	return res, nil
+}
+
+// Add registers secret values. Multi-line values are masked line by line.
+func (m *Masker) Add(values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, v := range lines(values) {
+		m.values[v] = struct{}{}
+	}
+}
+
+// Writer returns writer masking secrets of the masker and extra values, i.e. secrets of the task.
+// The output is written line by line, so secrets split between writes are masked as well.
+// Close writes the incomplete last line. Writer passes data as is if there is nothing to mask.
+func (m *Masker) Writer(w io.Writer, extra ...string) io.WriteCloser {
+	values := lines(extra)
+	var patterns []*regexp.Regexp
+	if m != nil {
+		m.mu.RLock()
+		for v := range m.values {
+			values = append(values, v)
+		}
+		patterns = m.patterns
+		m.mu.RUnlock()
+	}
+	if len(values) == 0 && len(patterns) == 0 {
+		return nopCloser{w}
+	}
+	// longer values first, so a value containing another one masked as a whole
+	sort.Slice(values, func(i, j int) bool {
+		if len(values[i]) != len(values[j]) {
+			return len(values[i]) > len(values[j])
+		}
+		return values[i] < values[j]
+	})
+	pairs := make([]string, 0, 2*len(values))
+	for _, v := range values {
+		pairs = append(pairs, v, Mask)
+	}
+	return &Writer{w: w, replacer: strings.NewReplacer(pairs...), patterns: patterns, values: values}
+}
+
+// String returns the string with secrets masked
+func (m *Masker) String(s string) string {
+	buf := bytes.Buffer{}
+	w := m.Writer(&buf)
+	_, _ = io.WriteString(w, s)
+	_ = w.Close()
+	return buf.String()
+}
+
+// Writer masks secrets in lines written to the underlying writer
+type Writer struct {
+	mu       sync.Mutex
+	w        io.Writer
+	replacer *strings.Replacer
+	patterns []*regexp.Regexp
+	values   []string // masked values, the longest first
+	pending  []byte
+}
+
+// Write masks and writes complete lines, the incomplete line kept until the next write or close
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending = append(w.pending, p...)
+	end := bytes.LastIndexAny(w.pending, "\r\n") + 1
+	if end == 0 && len(w.pending) < maxPending {
+		return len(p), nil
+	}
+	if end == 0 {
+		end = w.cut()
+	}
+	if err := w.flush(end); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Close writes the incomplete last line, the underlying writer is not closed
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.flush(len(w.pending))
+}
+
+// cut returns the size of the too long line written without newline. The tail which can be the beginning
+// of a secret kept pending, as well as a secret crossing the cut, so secrets split between writes are masked.
+func (w *Writer) cut() int {
+	if len(w.values) == 0 {
+		return len(w.pending)
+	}
+	res := max(len(w.pending)-len(w.values[0])+1, 0)
+	for moved := true; moved; {
+		moved = false
+		for _, v := range w.values {
+			from, to := max(res-len(v)+1, 0), min(res+len(v)-1, len(w.pending))
+			if from >= to {
+				continue
+			}
+			if i := bytes.Index(w.pending[from:to], []byte(v)); i >= 0 && from+i < res {
+				res, moved = from+i, true
+			}
+		}
+	}
+	return res
+}
+
+func (w *Writer) flush(n int) error {
+	if n == 0 {
+		return nil
+	}
+	s := w.replacer.Replace(string(w.pending[:n]))
+	for _, re := range w.patterns {
+		s = maskPattern(re, s)
+	}
+	w.pending = append(w.pending[:0], w.pending[n:]...)
+	if _, err := io.WriteString(w.w, s); err != nil {
+		return fmt.Errorf("can't write masked output: %w", err)
+	}
+	return nil
+}
+
+// maskPattern masks matches of the pattern, or matched groups if the pattern has them
+func maskPattern(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, Mask)
+	}
+	res := strings.Builder{}
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		for g := 1; g <= re.NumSubexp(); g++ {
+			start, end := m[2*g], m[2*g+1]
+			if start < last || start == end { // group not matched, empty or nested in the masked one
+				continue
+			}
+			res.WriteString(s[last:start])
+			res.WriteString(Mask)
+			last = end
+		}
+	}
+	res.WriteString(s[last:])
+	return res.String()
+}
+
+// lines splits values to trimmed lines, empty and too short lines dropped
+func lines(values []string) []string {
+	res := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, line := range strings.FieldsFunc(v, func(r rune) bool { return r == '\n' || r == '\r' }) {
+			if line = strings.TrimSpace(line); len(line) >= MinLength {
+				res = append(res, line)
+			}
+		}
+	}
+	return res
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,127 @@
+package mask
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasker_String(t *testing.T) {
+	m, err := New(`ghp_[A-Za-z0-9]{8}`, `(?i)password=(\S+)`, `user (\w+) token (\w+)`)
+	require.NoError(t, err)
+	m.Add("s3cret", "s3cret-long", "ab", "", "-----BEGIN KEY-----\nline1-of-key\n  line2-of-key\n-----END KEY-----")
+
+	tbl := []struct {
+		in, out string
+	}{
+		{"nothing to mask\n", "nothing to mask\n"},
+		{"docker login -p s3cret\n", "docker login -p ****\n"},
+		{"value s3cret-long and s3cret\n", "value **** and ****\n"},
+		{"ab is too short\n", "ab is too short\n"},
+		{"token ghp_abcd1234 used\n", "token **** used\n"},
+		{"PASSWORD=qwerty other=1\n", "PASSWORD=**** other=1\n"},
+		{"user bob token xyz\n", "user **** token ****\n"},
+		{"line1-of-key\nline2-of-key\n", "****\n****\n"},
+		{"no newline s3cret", "no newline ****"},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.out, m.String(tt.in), tt.in)
+	}
+}
+
+func TestMasker_Nil(t *testing.T) {
+	var m *Masker
+	assert.Equal(t, "value s3cret\n", m.String("value s3cret\n"))
+
+	buf := bytes.Buffer{}
+	w := m.Writer(&buf, "s3cret")
+	_, err := io.WriteString(w, "value s3cret\n")
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	assert.Equal(t, "value ****\n", buf.String(), "extra values masked")
+
+	buf.Reset()
+	w = m.Writer(&buf)
+	_, err = io.WriteString(w, "partial")
+	require.NoError(t, err)
+	assert.Equal(t, "partial", buf.String(), "written as is, nothing to mask")
+}
+
+func TestWriter_SplitWrites(t *testing.T) {
+	m, err := New(`token=(\w+)`)
+	require.NoError(t, err)
+	m.Add("s3cret-value")
+	buf := bytes.Buffer{}
+	w := m.Writer(&buf, "param-secret")
+
+	for _, s := range []string{"login s3cr", "et-val", "ue ok\ntok", "en=abc", "def and param-", "sec", "ret\r", "progress 50%"} {
+		_, err = io.WriteString(w, s)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, "login **** ok\ntoken=**** and ****\r", buf.String(), "complete lines written")
+	require.NoError(t, w.Close())
+	assert.Equal(t, "login **** ok\ntoken=**** and ****\rprogress 50%", buf.String(), "incomplete line written on close")
+}
+
+func TestWriter_LongLine(t *testing.T) {
+	m, err := New()
+	require.NoError(t, err)
+	m.Add("s3cret")
+	buf := bytes.Buffer{}
+	w := m.Writer(&buf)
+	_, err = io.WriteString(w, strings.Repeat("x", maxPending)+" s3cret ")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", maxPending)+" ", buf.String(), "too long line written without newline")
+	require.NoError(t, w.Close())
+	assert.Equal(t, strings.Repeat("x", maxPending)+" **** ", buf.String())
+}
+
+func TestWriter_LongLineSplitSecret(t *testing.T) {
+	m, err := New()
+	require.NoError(t, err)
+	m.Add("s3cret-value", "val")
+	for _, split := range []int{1, 3, 6, 11} {
+		buf := bytes.Buffer{}
+		w := m.Writer(&buf)
+		// the line reaches max pending in the middle of the secret
+		_, err = io.WriteString(w, strings.Repeat("x", maxPending-split)+"s3cret-value"[:split])
+		require.NoError(t, err)
+		assert.NotContains(t, buf.String(), "s3", "split %d", split)
+		_, err = io.WriteString(w, "s3cret-value"[split:]+" ok\n")
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("x", maxPending-split)+"**** ok\n", buf.String(), "split %d", split)
+	}
+
+	buf := bytes.Buffer{}
+	w := m.Writer(&buf)
+	_, err = io.WriteString(w, strings.Repeat("x", maxPending-4)+"s3cret-value abc")
+	require.NoError(t, err)
+	assert.Equal(t, strings.Repeat("x", maxPending-4), buf.String(), "secret crossing the cut kept pending")
+	require.NoError(t, w.Close())
+	assert.Equal(t, strings.Repeat("x", maxPending-4)+"**** abc", buf.String())
+}
+
+func TestWriter_Error(t *testing.T) {
+	m, err := New()
+	require.NoError(t, err)
+	m.Add("s3cret")
+	w := m.Writer(errWriter{})
+	_, err = io.WriteString(w, "partial")
+	require.NoError(t, err)
+	_, err = io.WriteString(w, "line\n")
+	require.EqualError(t, err, "can't write masked output: failed")
+}
+
+func TestNew_InvalidPattern(t *testing.T) {
+	_, err := New(`ok`, `bad(`)
+	require.ErrorContains(t, err, `invalid mask pattern "bad("`)
+}
+
+type errWriter struct{}
+
+func (errWriter) Write([]byte) (int, error) { return 0, errors.New("failed") }
@@ -0,0 +1,335 @@
+// Package oidc verifies JWT bearer tokens, i.e. GitHub Actions OIDC tokens, with keys from JWKS.
+// RS256, RS384, RS512, ES256, ES384 and ES512 signatures supported.
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Claims of the verified token
+type Claims map[string]any
+
+// String returns string claim, empty if not set or not a string
+func (c Claims) String(name string) string {
+	res, _ := c[name].(string)
+	return res
+}
+
+// Verifier verifies JWT tokens with keys loaded from JWKS url or file
+type Verifier struct {
+	JWKSURL  string        // url of JWKS, i.e. https://token.actions.githubusercontent.com/.well-known/jwks
+	JWKSFile string        // local JWKS file, used if url not set
+	Issuer   string        // expected iss claim, not checked if empty
+	Audience string        // expected aud claim, required, all tokens rejected if empty
+	Leeway   time.Duration // allowed clock skew for exp, nbf and iat, default 1m
+	Client   *http.Client
+
+	mu       sync.Mutex
+	keys     map[string]crypto.PublicKey // kid -> key
+	loadedAt time.Time
+}
+
+const (
+	defaultLeeway = time.Minute
+	minReload     = time.Minute // keys not reloaded more often, even for unknown kid
+	maxKeysAge    = time.Hour   // keys reloaded after this time
+)
+
+// Verify checks token signature and standard claims, and returns all claims of the token
+func (v *Verifier) Verify(token string) (Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	header := struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("invalid header: %w", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid signature encoding: %w", err)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	claims := Claims{}
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("invalid claims: %w", err)
+	}
+	if err = v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *Verifier) checkClaims(claims Claims) error {
+	leeway := v.Leeway
+	if leeway <= 0 {
+		leeway = defaultLeeway
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return errors.New("exp claim required")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return errors.New("token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-leeway)) {
+		return errors.New("token not valid yet")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Before(time.Unix(int64(iat), 0).Add(-leeway)) {
+		return errors.New("token issued in the future")
+	}
+	if v.Issuer != "" && claims.String("iss") != v.Issuer {
+		return fmt.Errorf("unexpected issuer %q", claims.String("iss"))
+	}
+	if v.Audience == "" {
+		return errors.New("audience not configured") // tokens of any audience are not accepted
+	}
+	if !hasAudience(claims["aud"], v.Audience) {
+		return errors.New("unexpected audience")
+	}
+	return nil
+}
+
+func hasAudience(aud any, expected string) bool {
+	switch a := aud.(type) {
+	case string:
+		return a == expected
+	case []any:
+		for _, v := range a {
+			if s, ok := v.(string); ok && s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns public key by id, keys reloaded if the key is unknown or keys are too old
+func (v *Verifier) key(kid string) (crypto.PublicKey, error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	key, ok := v.keys[kid]
+	age := time.Since(v.loadedAt)
+	if ok && age < maxKeysAge {
+		return key, nil
+	}
+	if v.keys == nil || age >= minReload {
+		keys, err := v.load()
+		if err != nil {
+			if ok {
+				return key, nil // use known key if reload failed
+			}
+			return nil, err
+		}
+		v.keys, v.loadedAt = keys, time.Now()
+	}
+	if key, ok = v.keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// load reads JWKS from url or file
+func (v *Verifier) load() (map[string]crypto.PublicKey, error) {
+	var data []byte
+	switch {
+	case v.JWKSURL != "":
+		client := v.Client
+		if client == nil {
+			client = &http.Client{Timeout: 10 * time.Second}
+		}
+		resp, err := client.Get(v.JWKSURL)
+		if err != nil {
+			return nil, fmt.Errorf("can't load jwks: %w", err)
+		}
+		defer resp.Body.Close() //nolint
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("can't load jwks, status %d", resp.StatusCode)
+		}
+		if data, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err != nil {
+			return nil, fmt.Errorf("can't read jwks: %w", err)
+		}
+	case v.JWKSFile != "":
+		var err error
+		if data, err = os.ReadFile(v.JWKSFile); err != nil {
+			return nil, fmt.Errorf("can't read jwks: %w", err)
+		}
+	default:
+		return nil, errors.New("no jwks source")
+	}
+	return parseJWKS(data)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// parseJWKS parses RSA and EC keys of JWKS, keys of other types and encryption keys are skipped
+func parseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("can't parse jwks: %w", err)
+	}
+	res := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+		}
+		if key != nil {
+			res[k.Kid] = key
+		}
+	}
+	return res, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg[min(2, len(alg)):] {
+	case "256":
+		hash = crypto.SHA256
+	case "384":
+		hash = crypto.SHA384
+	case "512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported algorithm %q", alg)
+	}
+	digest := hashOf(hash, signed)
+
+	switch {
+	case strings.HasPrefix(alg, "RS"):
+		k, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key doesn't match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, sig); err != nil {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case strings.HasPrefix(alg, "ES"):
+		k, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key doesn't match algorithm %s", alg)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return errors.New("invalid signature")
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %q", alg)
+}
+
+func hashOf(h crypto.Hash, data []byte) []byte {
+	switch h {
+	case crypto.SHA384:
+		d := sha512.Sum384(data)
+		return d[:]
+	case crypto.SHA512:
+		d := sha512.Sum512(data)
+		return d[:]
+	default:
+		d := sha256.Sum256(data)
+		return d[:]
+	}
+}
+
+func decodeSegment(seg string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("invalid key encoding")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
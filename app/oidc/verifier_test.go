@@ -0,0 +1,188 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier_VerifyFile(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	file := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(file, jwks(t, map[string]crypto.PublicKey{"rsa1": &rsaKey.PublicKey, "ec1": &ecKey.PublicKey}), 0o600))
+	v := Verifier{JWKSFile: file, Issuer: "https://token.actions.githubusercontent.com", Audience: "updater"}
+
+	now := time.Now().Unix()
+	good := map[string]any{"iss": v.Issuer, "aud": "updater", "exp": now + 60, "iat": now,
+		"repository": "umputun/updater", "ref": "refs/heads/master"}
+
+	claims, err := v.Verify(sign(t, "rsa1", rsaKey, good))
+	require.NoError(t, err)
+	assert.Equal(t, "umputun/updater", claims.String("repository"))
+	assert.Equal(t, "refs/heads/master", claims.String("ref"))
+
+	claims, err = v.Verify(sign(t, "ec1", ecKey, good))
+	require.NoError(t, err)
+	assert.Equal(t, "umputun/updater", claims.String("repository"))
+
+	tbl := []struct {
+		name   string
+		token  string
+		errMsg string
+	}{
+		{"malformed", "abc.def", "malformed token"},
+		{"unknown key", sign(t, "rsa2", rsaKey, good), `unknown key "rsa2"`},
+		{"wrong key", sign(t, "ec1", rsaKey, good), "key doesn't match algorithm RS256"},
+		{"expired", sign(t, "rsa1", rsaKey, with(good, "exp", now-120)), "token expired"},
+		{"no exp", sign(t, "rsa1", rsaKey, with(good, "exp", nil)), "exp claim required"},
+		{"not yet", sign(t, "rsa1", rsaKey, with(good, "nbf", now+300)), "token not valid yet"},
+		{"issuer", sign(t, "rsa1", rsaKey, with(good, "iss", "https://example.com")), `unexpected issuer "https://example.com"`},
+		{"audience", sign(t, "rsa1", rsaKey, with(good, "aud", "other")), "unexpected audience"},
+		{"audience not in list", sign(t, "rsa1", rsaKey, with(good, "aud", []string{"other"})), "unexpected audience"},
+		{"no audience", sign(t, "rsa1", rsaKey, with(good, "aud", nil)), "unexpected audience"},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := v.Verify(tt.token)
+			assert.EqualError(t, err, tt.errMsg)
+		})
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		token := sign(t, "rsa1", rsaKey, good)
+		other := sign(t, "rsa1", rsaKey, with(good, "repository", "evil/repo"))
+		parts, otherParts := strings.Split(token, "."), strings.Split(other, ".")
+		_, err := v.Verify(otherParts[0] + "." + otherParts[1] + "." + parts[2])
+		assert.EqualError(t, err, "invalid signature")
+	})
+
+	t.Run("audience list", func(t *testing.T) {
+		_, err := v.Verify(sign(t, "rsa1", rsaKey, with(good, "aud", []string{"other", "updater"})))
+		assert.NoError(t, err)
+	})
+
+	t.Run("audience not configured", func(t *testing.T) {
+		noAud := Verifier{JWKSFile: file, Issuer: v.Issuer}
+		_, err := noAud.Verify(sign(t, "rsa1", rsaKey, good))
+		assert.EqualError(t, err, "audience not configured")
+	})
+}
+
+func TestVerifier_VerifyURL(t *testing.T) {
+	key1, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key2, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var calls int32
+	keys := map[string]crypto.PublicKey{"k1": &key1.PublicKey}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write(jwks(t, keys))
+	}))
+	defer ts.Close()
+
+	v := Verifier{JWKSURL: ts.URL, Audience: "updater"}
+	claims := map[string]any{"exp": time.Now().Add(time.Minute).Unix(), "aud": "updater", "repository": "umputun/updater"}
+	_, err = v.Verify(sign(t, "k1", key1, claims))
+	require.NoError(t, err)
+	_, err = v.Verify(sign(t, "k1", key1, claims))
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "keys cached")
+
+	// unknown key doesn't reload keys too often
+	_, err = v.Verify(sign(t, "k2", key2, claims))
+	assert.EqualError(t, err, `unknown key "k2"`)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	// rotated keys picked up after min reload interval
+	keys = map[string]crypto.PublicKey{"k2": &key2.PublicKey}
+	v.loadedAt = time.Now().Add(-2 * minReload)
+	_, err = v.Verify(sign(t, "k2", key2, claims))
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestVerifier_NoSource(t *testing.T) {
+	v := Verifier{}
+	_, err := v.Verify("eyJhbGciOiJSUzI1NiJ9.e30.c2ln")
+	assert.EqualError(t, err, "no jwks source")
+}
+
+func with(claims map[string]any, name string, val any) map[string]any {
+	res := map[string]any{}
+	for k, v := range claims {
+		res[k] = v
+	}
+	if val == nil {
+		delete(res, name)
+		return res
+	}
+	res[name] = val
+	return res
+}
+
+// sign makes RS256 or ES256 token, depending on the key type
+func sign(t *testing.T, kid string, key crypto.Signer, claims map[string]any) string {
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, digest[:])
+		require.NoError(t, err)
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func jwks(t *testing.T, keys map[string]crypto.PublicKey) []byte {
+	enc := func(b []byte) string { return base64.RawURLEncoding.EncodeToString(b) }
+	res := []map[string]string{}
+	for kid, key := range keys {
+		switch k := key.(type) {
+		case *rsa.PublicKey:
+			res = append(res, map[string]string{"kty": "RSA", "kid": kid, "use": "sig", "alg": "RS256",
+				"n": enc(k.N.Bytes()), "e": enc(big.NewInt(int64(k.E)).Bytes())})
+		case *ecdsa.PublicKey:
+			res = append(res, map[string]string{"kty": "EC", "kid": kid, "crv": "P-256",
+				"x": enc(k.X.FillBytes(make([]byte, 32))), "y": enc(k.Y.FillBytes(make([]byte, 32)))})
+		}
+	}
+	data, err := json.Marshal(map[string]any{"keys": res})
+	require.NoError(t, err)
+	return data
+}
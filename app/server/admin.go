@@ -0,0 +1,122 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+	"github.com/go-pkgz/routegroup"
+)
+
+// adminRoutes sets routes of admin api, all of them require admin key as bearer token
+func (s *Rest) adminRoutes(router *routegroup.Bundle) {
+	router.Use(s.keyAuth, adminOnly)
+	router.HandleFunc("POST /tasks/{name}/disable", s.adminDisableCtrl)
+	router.HandleFunc("POST /tasks/{name}/enable", s.adminEnableCtrl)
+	router.HandleFunc("GET /freeze", s.adminFreezeStatusCtrl)
+	router.HandleFunc("POST /freeze", s.adminFreezeCtrl)
+	router.HandleFunc("DELETE /freeze", s.adminUnfreezeCtrl)
+	router.HandleFunc("GET /bans", s.adminBansCtrl)
+	router.HandleFunc("DELETE /bans/{ip}", s.adminUnbanCtrl)
+}
+
+// POST /admin/tasks/{name}/disable, body {"reason": "incident"}, optional
+func (s *Rest) adminDisableCtrl(w http.ResponseWriter, r *http.Request) {
+	s.setTaskEnabled(w, r, false)
+}
+
+// POST /admin/tasks/{name}/enable
+func (s *Rest) adminEnableCtrl(w http.ResponseWriter, r *http.Request) {
+	s.setTaskEnabled(w, r, true)
+}
+
+func (s *Rest) setTaskEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	t, ok := s.Config.GetTask(r.PathValue("name"))
+	auditRecord(r).Task = r.PathValue("name")
+	if !ok {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	taskName := t.Name // state kept under the name from config, as task names are matched case-insensitively
+	req := struct {
+		Reason string `json:"reason"`
+	}{}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "failed to decode request", http.StatusBadRequest)
+			return
+		}
+	}
+	if err := s.getState().setEnabled(taskName, enabled, req.Reason); err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "can't save state", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] task %s enabled: %v, %s", taskName, enabled, req.Reason)
+	rest.RenderJSON(w, rest.JSON{"task": taskName, "enabled": enabled})
+}
+
+// GET /admin/freeze returns deploy freeze status
+func (s *Rest) adminFreezeStatusCtrl(w http.ResponseWriter, _ *http.Request) {
+	f, ok := s.getState().frozen()
+	if !ok {
+		rest.RenderJSON(w, rest.JSON{"frozen": false})
+		return
+	}
+	rest.RenderJSON(w, rest.JSON{"frozen": true, "freeze": f})
+}
+
+// POST /admin/freeze, body {"reason": "release", "duration": "2h"}, duration is optional
+func (s *Rest) adminFreezeCtrl(w http.ResponseWriter, r *http.Request) {
+	req := struct {
+		Reason   string `json:"reason"`
+		Duration string `json:"duration"`
+	}{}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "failed to decode request", http.StatusBadRequest)
+			return
+		}
+	}
+	var ttl time.Duration
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		ttl = d
+	}
+	f, err := s.getState().freeze(req.Reason, ttl)
+	if err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "can't save state", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] deploy freeze started, %s", req.Reason)
+	rest.RenderJSON(w, rest.JSON{"frozen": true, "freeze": f})
+}
+
+// DELETE /admin/freeze
+func (s *Rest) adminUnfreezeCtrl(w http.ResponseWriter, _ *http.Request) {
+	if err := s.getState().unfreeze(); err != nil {
+		log.Printf("[ERROR] %v", err)
+		http.Error(w, "can't save state", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("[INFO] deploy freeze stopped")
+	rest.RenderJSON(w, rest.JSON{"frozen": false})
+}
+
+// adminOnly middleware allows requests authorized with admin key by keyAuth
+func adminOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isAdmin(r) {
+			http.Error(w, "admin key required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_AdminDisableTask(t *testing.T) {
+	conf := &mocks.ConfigMock{
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true },
+		GetTaskFunc:        func(name string) (task.Task, bool) { return task.Task{Name: name}, name != "unknown" },
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", AdminKey: "admin",
+		StateFile: filepath.Join(t.TempDir(), "state.json")}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	code, _ := adminCall(t, ts.URL+"/admin/tasks/task1/disable", http.MethodPost, "12345", "")
+	assert.Equal(t, http.StatusForbidden, code, "secret key is not enough")
+	code, _ = adminCall(t, ts.URL+"/admin/tasks/unknown/disable", http.MethodPost, "admin", "")
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, body := adminCall(t, ts.URL+"/admin/tasks/task1/disable", http.MethodPost, "admin", `{"reason":"incident"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"enabled":false,"task":"task1"}`+"\n", body)
+
+	resp, err := http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	msg, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "task disabled, incident\n", string(msg))
+
+	resp, err = http.Get(ts.URL + "/update/task2/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	code, body = adminCall(t, ts.URL+"/tasks/task1", http.MethodGet, "12345", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, `"enabled":false`)
+	assert.EqualError(t, srv.Exec(context.Background(), "task1", nil, io.Discard, io.Discard), "task task1: task disabled, incident")
+
+	code, _ = adminCall(t, ts.URL+"/admin/tasks/task1/enable", http.MethodPost, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+	resp, err = http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 2, len(runner.RunCalls()))
+}
+
+func TestRest_AdminDisableTaskIgnoreCase(t *testing.T) {
+	conf := &mocks.ConfigMock{
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true },
+		GetTaskFunc: func(name string) (task.Task, bool) {
+			return task.Task{Name: "Deploy"}, strings.EqualFold(name, "deploy")
+		},
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", AdminKey: "admin"}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	code, body := adminCall(t, ts.URL+"/admin/tasks/deploy/disable", http.MethodPost, "admin", `{"reason":"incident"}`)
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"enabled":false,"task":"Deploy"}`+"\n", body)
+	assert.False(t, srv.getState().enabled("Deploy"), "state kept under the name from config")
+
+	for _, name := range []string{"deploy", "Deploy", "DEPLOY"} {
+		resp, err := http.Get(ts.URL + "/update/" + name + "/12345")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusLocked, resp.StatusCode, name)
+	}
+	assert.Empty(t, runner.RunCalls())
+
+	code, _ = adminCall(t, ts.URL+"/admin/tasks/DEPLOY/enable", http.MethodPost, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+	resp, err := http.Get(ts.URL + "/update/deploy/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRest_AdminFreeze(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", AdminKey: "admin"}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	code, body := adminCall(t, ts.URL+"/admin/freeze", http.MethodGet, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"frozen":false}`+"\n", body)
+
+	code, _ = adminCall(t, ts.URL+"/admin/freeze", http.MethodPost, "admin", `{"duration":"bad"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, body = adminCall(t, ts.URL+"/admin/freeze", http.MethodPost, "admin", `{"reason":"release","duration":"1h"}`)
+	require.Equal(t, http.StatusOK, code)
+	res := struct {
+		Frozen bool   `json:"frozen"`
+		Freeze Freeze `json:"freeze"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	assert.True(t, res.Frozen)
+	assert.Equal(t, "release", res.Freeze.Reason)
+	assert.InDelta(t, time.Hour, res.Freeze.Until.Sub(res.Freeze.Since), float64(time.Second))
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+
+	code, _ = adminCall(t, ts.URL+"/admin/freeze", http.MethodDelete, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+	resp, err = http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+}
+
+func TestRest_AdminFreezeQueue(t *testing.T) {
+	queueCheckInterval = 10 * time.Millisecond
+	defer func() { queueCheckInterval = time.Second }()
+
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", AdminKey: "admin", FreezeQueue: true, Timeout: time.Second}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	code, _ := adminCall(t, ts.URL+"/admin/freeze", http.MethodPost, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+
+	resp, err := http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct {
+		Queued string `json:"queued"`
+		Job    string `json:"job"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.Equal(t, "ok", res.Queued)
+
+	time.Sleep(50 * time.Millisecond)
+	job, ok := srv.getJobs().get(res.Job)
+	require.True(t, ok)
+	assert.Equal(t, JobQueued, job.Status)
+	assert.Equal(t, 0, len(runner.RunCalls()))
+
+	code, _ = adminCall(t, ts.URL+"/admin/freeze", http.MethodDelete, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Eventually(t, func() bool {
+		job, _ := srv.getJobs().get(res.Job)
+		return job.Status == JobSuccess
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+}
+
+func adminCall(t *testing.T, url, method, key, body string) (code int, respBody string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, string(data)
+}
+
+func TestRest_MaintenanceWindow(t *testing.T) {
+	now := time.Now().UTC()
+	// window opens in an hour and lasts an hour, so the trigger is always outside of it
+	start := now.Add(time.Hour).Truncate(time.Minute)
+	window := start.Format("15:04") + "-" + start.Add(time.Hour).Format("15:04")
+	tasks := map[string]task.Task{
+		"reject": {Name: "reject", Maintenance: &task.MaintenanceParams{Timezone: "UTC", Windows: []string{window}}},
+		"defer":  {Name: "defer", Maintenance: &task.MaintenanceParams{Timezone: "UTC", Windows: []string{window}, Defer: true}},
+		"anytime": {Name: "anytime", Maintenance: &task.MaintenanceParams{Timezone: "UTC",
+			Blackouts: []string{now.AddDate(0, 0, -1).Format("2006-01-02")}}},
+	}
+	conf := &mocks.ConfigMock{
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true },
+		GetTaskFunc:        func(name string) (task.Task, bool) { t, ok := tasks[name]; return t, ok },
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Second}
+	defer srv.getLifecycle().stopQueued(errShutdown) // deferred job waits for the window otherwise
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/update/reject/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusLocked, resp.StatusCode)
+	msg, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "outside of maintenance window, next window at "+start.Format(time.RFC3339)+"\n", string(msg))
+
+	resp, err = http.Get(ts.URL + "/update/defer/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct {
+		Queued string    `json:"queued"`
+		Job    string    `json:"job"`
+		RunAt  time.Time `json:"run_at"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	assert.Equal(t, "ok", res.Queued)
+	assert.True(t, start.Equal(res.RunAt))
+	job, ok := srv.getJobs().get(res.Job)
+	require.True(t, ok)
+	assert.Equal(t, JobQueued, job.Status)
+	require.NotNil(t, job.RunAt)
+	assert.True(t, start.Equal(*job.RunAt))
+
+	resp, err = http.Get(ts.URL + "/update/anytime/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+
+	err = srv.Exec(context.Background(), "defer", nil, io.Discard, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "task defer: outside of maintenance window")
+}
@@ -0,0 +1,285 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/umputun/updater/app/events"
+	"github.com/umputun/updater/app/keyhash"
+	"github.com/umputun/updater/app/task"
+)
+
+const defaultApprovalTimeout = time.Hour
+
+var errApprovalExpired = errors.New("approval expired")
+
+// approvals keeps decision channels of jobs waiting for approval
+type approvals struct {
+	mu      sync.Mutex
+	pending map[string]chan approvalDecision
+}
+
+type approvalDecision struct {
+	approved bool
+	by       string
+}
+
+func (a *approvals) add(id string) chan approvalDecision {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pending == nil {
+		a.pending = map[string]chan approvalDecision{}
+	}
+	ch := make(chan approvalDecision, 1)
+	a.pending[id] = ch
+	return ch
+}
+
+func (a *approvals) remove(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.pending, id)
+}
+
+// decide sends decision to the job waiting for approval, false returned if the job doesn't wait for it
+func (a *approvals) decide(id string, d approvalDecision) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ch, ok := a.pending[id]
+	if !ok {
+		return false
+	}
+	delete(a.pending, id)
+	ch <- d
+	return true
+}
+
+// requestApproval makes a pending job and runs it in background after approval
+func (s *Rest) requestApproval(t task.Task, src jobSource, runner Runner, command string,
+	params map[string]string) Job {
+	job := s.getJobs().pending(t.Name, src)
+	s.publishJob(trace.ContextWithSpanContext(context.Background(), src.Trace), events.JobQueued, job.ID, nil)
+	go func() {
+		ctx := trace.ContextWithSpanContext(s.queueContext(), src.Trace)
+		err := s.awaitApproval(ctx, t, job)
+		if err == nil {
+			err = s.awaitAdmission(ctx, t.Name)
+		}
+		if err != nil {
+			log.Printf("[WARN] job %s of task %s dropped, %v", job.ID, t.Name, err)
+			s.finishJob(ctx, job.ID, err)
+			return
+		}
+		log.Printf("[INFO] invoke approved task %s", t.Name)
+		s.getJobs().run(job.ID)
+		s.runBackground(src.Trace, job, runner, command, params)
+	}()
+	return job
+}
+
+// awaitApproval notifies approvers about the pending job and waits for the decision until approval expires
+func (s *Rest) awaitApproval(ctx context.Context, t task.Task, job Job) (err error) {
+	_, span := tracer.Start(ctx, "approval wait", trace.WithAttributes(attribute.String("job.id", job.ID),
+		attribute.String("task", t.Name)))
+	defer func() { endSpan(span, err) }()
+	timeout := t.ApprovalTimeout
+	if timeout <= 0 {
+		timeout = defaultApprovalTimeout
+	}
+	// links keep expiration in unix seconds, so it is rounded up to the next second
+	expires := time.Now().Add(timeout).Truncate(time.Second).Add(time.Second)
+	decision := s.approvals.add(job.ID)
+	defer s.approvals.remove(job.ID)
+
+	approveURL, rejectURL := s.approvalLink(job.ID, "approve", expires), s.approvalLink(job.ID, "reject", expires)
+	log.Printf("[INFO] job %s of task %s waits for approval until %s, approve: %s, reject: %s",
+		job.ID, t.Name, expires.Format(time.RFC3339), approveURL, rejectURL)
+	if s.ApprovalNotify != "" {
+		go s.notifyApproval(job, expires, approveURL, rejectURL)
+	}
+
+	timer := time.NewTimer(time.Until(expires))
+	defer timer.Stop()
+	select {
+	case d := <-decision:
+		if !d.approved {
+			return fmt.Errorf("rejected by %s", d.by)
+		}
+		s.getJobs().approve(job.ID, d.by)
+		return nil
+	case <-timer.C:
+		return errApprovalExpired
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
+
+// notifyApproval posts pending approval with approve and reject links to the notification url
+func (s *Rest) notifyApproval(job Job, expires time.Time, approveURL, rejectURL string) {
+	body, err := json.Marshal(struct {
+		Job        string    `json:"job"`
+		Task       string    `json:"task"`
+		Expires    time.Time `json:"expires"`
+		ApproveURL string    `json:"approve_url"`
+		RejectURL  string    `json:"reject_url"`
+	}{Job: job.ID, Task: job.Task, Expires: expires, ApproveURL: approveURL, RejectURL: rejectURL})
+	if err != nil {
+		log.Printf("[WARN] can't marshal approval notification, %v", err)
+		return
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(s.ApprovalNotify, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[WARN] can't send approval notification, %v", err)
+		return
+	}
+	defer resp.Body.Close() //nolint
+	if resp.StatusCode >= 300 {
+		log.Printf("[WARN] approval notification rejected, status %d", resp.StatusCode)
+	}
+}
+
+// GET /approvals/{id}/{action}?expires=ts&token=signature shows approval form asking for approver key
+func (s *Rest) approvalPageCtrl(w http.ResponseWriter, r *http.Request) {
+	job, err := s.approvalJob(r)
+	data := map[string]any{"Login": true, "Action": r.PathValue("action"), "Job": job}
+	if err != nil {
+		data["Error"] = err.Error()
+		s.renderPage(w, http.StatusForbidden, "approval.html", data)
+		return
+	}
+	s.renderPage(w, http.StatusOK, "approval.html", data)
+}
+
+// POST /approvals/{id}/{action}?expires=ts&token=signature approves or rejects pending job. Approver key
+// passed as bearer token, or as key field of the form. Response is json for bearer token and html page otherwise.
+func (s *Rest) approvalCtrl(w http.ResponseWriter, r *http.Request) {
+	token, isBearer := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !isBearer {
+		token = r.FormValue("key")
+	}
+	respond := func(status int, job Job, msg string) {
+		if isBearer {
+			if status != http.StatusOK {
+				http.Error(w, msg, status)
+				return
+			}
+			rest.RenderJSON(w, rest.JSON{"job": job.ID, "result": msg})
+			return
+		}
+		data := map[string]any{"Login": true, "Action": r.PathValue("action"), "Job": job}
+		if status != http.StatusOK {
+			data["Error"] = msg
+		} else {
+			data["Message"] = msg
+		}
+		s.renderPage(w, status, "approval.html", data)
+	}
+
+	job, err := s.approvalJob(r)
+	rec := auditRecord(r)
+	rec.Task, rec.Job = job.Task, job.ID
+	if err != nil {
+		rec.Reason = err.Error()
+		respond(http.StatusForbidden, job, err.Error())
+		return
+	}
+	approver, ok := s.approver(token)
+	if !ok {
+		s.authFailed(r, "invalid approver key for job "+job.ID)
+		respond(http.StatusForbidden, job, "invalid approver key")
+		return
+	}
+	rec.Identity = "approver:" + approver
+	approved := r.PathValue("action") == "approve"
+	if !s.approvals.decide(job.ID, approvalDecision{approved: approved, by: approver}) {
+		rec.Reason = "job is not waiting for approval"
+		respond(http.StatusGone, job, "job is not waiting for approval")
+		return
+	}
+	result := "rejected"
+	if approved {
+		result = "approved"
+	}
+	log.Printf("[INFO] job %s %s by %s", job.ID, result, approver)
+	rec.Reason = "job " + result
+	respond(http.StatusOK, job, "job "+result)
+}
+
+// approvalJob checks signed approval link and returns the job
+func (s *Rest) approvalJob(r *http.Request) (Job, error) {
+	id, action := r.PathValue("id"), r.PathValue("action")
+	if action != "approve" && action != "reject" {
+		return Job{}, errors.New("unknown action")
+	}
+	ts, err := strconv.ParseInt(r.URL.Query().Get("expires"), 10, 64)
+	if err != nil {
+		return Job{}, errors.New("invalid link")
+	}
+	expires := time.Unix(ts, 0)
+	if !hmac.Equal([]byte(r.URL.Query().Get("token")), []byte(s.approvalToken(id, action, expires))) {
+		return Job{}, errors.New("invalid link")
+	}
+	if time.Now().After(expires) {
+		return Job{}, errApprovalExpired
+	}
+	job, ok := s.getJobs().get(id)
+	if !ok {
+		return Job{}, errors.New("job not found")
+	}
+	return job, nil
+}
+
+// approver returns name of the approver for the key, admin key is an approver as well
+func (s *Rest) approver(key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	if keyhash.Match(s.AdminKey, key) {
+		return "admin", true
+	}
+	names := make([]string, 0, len(s.Approvers))
+	for name := range s.Approvers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if keyhash.Match(s.Approvers[name], key) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// approvalLink makes signed link to approve or reject the job, under external url of updater.
+// The link is never made from the request host, as the host is set by the client.
+func (s *Rest) approvalLink(id, action string, expires time.Time) string {
+	q := url.Values{}
+	q.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	q.Set("token", s.approvalToken(id, action, expires))
+	return strings.TrimSuffix(s.BaseURL, "/") + "/approvals/" + id + "/" + action + "?" + q.Encode()
+}
+
+func (s *Rest) approvalToken(id, action string, expires time.Time) string {
+	mac := hmac.New(sha256.New, []byte(s.SecretKey))
+	mac.Write([]byte(id + ":" + action + ":" + strconv.FormatInt(expires.Unix(), 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
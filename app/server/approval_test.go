@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_Approval(t *testing.T) {
+	notifications := make(chan map[string]any, 10)
+	notifySrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		req := map[string]any{}
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		notifications <- req
+	}))
+	defer notifySrv.Close()
+
+	srv, runner, ts := prepApprovalServer(5*time.Second, func(s *Rest) { s.ApprovalNotify = notifySrv.URL })
+	defer ts.Close()
+
+	// host of the request is not used in links
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/update", strings.NewReader(`{"task":"migrate","secret":"12345"}`))
+	require.NoError(t, err)
+	req.Host = "attacker.example.com"
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	res := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	job := res.Job
+	var n map[string]any
+	select {
+	case n = <-notifications:
+	case <-time.After(time.Second):
+		t.Fatal("no notification")
+	}
+	assert.Equal(t, job, n["job"])
+	assert.Equal(t, "migrate", n["task"])
+	approveURL := n["approve_url"].(string)
+	assert.True(t, strings.HasPrefix(approveURL, ts.URL+"/approvals/"+job+"/approve?"), approveURL)
+
+	// approval page shows the form
+	resp, err = http.Get(approveURL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `placeholder="approver key"`)
+	assert.Contains(t, string(body), "migrate")
+
+	// secret key is not an approver
+	code, _ := adminCall(t, approveURL, http.MethodPost, "12345", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, 0, len(runner.RunCalls()))
+
+	// tampered link rejected
+	code, _ = adminCall(t, strings.Replace(approveURL, "/approve?", "/reject?", 1), http.MethodPost, "approver-key", "")
+	assert.Equal(t, http.StatusForbidden, code)
+
+	code, respBody := adminCall(t, approveURL, http.MethodPost, "approver-key", "")
+	require.Equal(t, http.StatusOK, code, respBody)
+	require.Eventually(t, func() bool {
+		j, _ := srv.getJobs().get(job)
+		return j.Status == JobSuccess
+	}, time.Second, 10*time.Millisecond)
+	j, _ := srv.getJobs().get(job)
+	assert.Equal(t, "dba", j.Approver)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+
+	// decided already
+	code, _ = adminCall(t, approveURL, http.MethodPost, "approver-key", "")
+	assert.Equal(t, http.StatusGone, code)
+}
+
+func TestRest_ApprovalRejectWithForm(t *testing.T) {
+	srv, runner, ts := prepApprovalServer(5*time.Second, nil)
+	defer ts.Close()
+
+	job := triggerPending(t, ts.URL)
+	rejectURL := srv.approvalLink(job, "reject", time.Now().Add(time.Minute))
+	resp, err := http.PostForm(rejectURL, url.Values{"key": {"admin"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "job rejected")
+
+	require.Eventually(t, func() bool {
+		j, _ := srv.getJobs().get(job)
+		return j.Status == JobFailed
+	}, time.Second, 10*time.Millisecond)
+	j, _ := srv.getJobs().get(job)
+	assert.Equal(t, "rejected by admin", j.Error)
+	assert.Equal(t, 0, len(runner.RunCalls()))
+}
+
+func TestRest_ApprovalExpired(t *testing.T) {
+	srv, runner, ts := prepApprovalServer(100*time.Millisecond, nil)
+	defer ts.Close()
+
+	job := triggerPending(t, ts.URL)
+	require.Eventually(t, func() bool {
+		j, _ := srv.getJobs().get(job)
+		return j.Status == JobFailed
+	}, 3*time.Second, 10*time.Millisecond)
+	j, _ := srv.getJobs().get(job)
+	assert.Equal(t, "approval expired", j.Error)
+	assert.Equal(t, 0, len(runner.RunCalls()))
+
+	expiredURL := srv.approvalLink(job, "approve", time.Now().Add(-time.Minute))
+	code, body := adminCall(t, expiredURL, http.MethodPost, "approver-key", "")
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "approval expired\n", body)
+}
+
+func TestRest_ExecWithApproval(t *testing.T) {
+	srv, runner, ts := prepApprovalServer(5*time.Second, func(s *Rest) { s.BaseURL = "https://updater.example.com" })
+	defer ts.Close()
+
+	go func() {
+		for {
+			for _, j := range srv.getJobs().list() {
+				if j.Status == JobPending && srv.approvals.decide(j.ID, approvalDecision{approved: true, by: "dba"}) {
+					return
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	require.NoError(t, srv.Exec(context.Background(), "migrate", nil, io.Discard, io.Discard))
+	assert.Equal(t, 1, len(runner.RunCalls()))
+	assert.True(t, strings.HasPrefix(srv.approvalLink("id1", "approve", time.Now()),
+		"https://updater.example.com/approvals/id1/approve?expires="))
+}
+
+func prepApprovalServer(timeout time.Duration, opts func(s *Rest)) (*Rest, *mocks.RunnerMock, *httptest.Server) {
+	conf := &mocks.ConfigMock{
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true },
+		GetTaskFunc: func(name string) (task.Task, bool) {
+			return task.Task{Name: name, Approval: task.ApprovalRequired, ApprovalTimeout: timeout}, true
+		},
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := &Rest{Config: conf, Runner: runner, SecretKey: "12345", AdminKey: "admin", Timeout: time.Second,
+		Approvers: map[string]string{"dba": "approver-key"}}
+	ts := httptest.NewServer(srv.router())
+	srv.BaseURL = ts.URL
+	if opts != nil {
+		opts(srv)
+	}
+	return srv, runner, ts
+}
+
+func triggerPending(t *testing.T, url string) (jobID string) {
+	resp, err := http.Post(url+"/update", "application/json", strings.NewReader(`{"task":"migrate","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct {
+		Pending string `json:"pending"`
+		Job     string `json:"job"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	require.Equal(t, "ok", res.Pending)
+	return res.Job
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/updater/app/audit"
+)
+
+// maxAuditReason limits the size of error response kept as the reason of rejection
+const maxAuditReason = 256
+
+// auditRequests middleware adds audit record of every request, except static files of the dashboard.
+// Handlers fill authenticated identity, task and job of the record, see auditRecord. The decision made from
+// the response status, and the text of error response used as the reason of rejection if not set by handlers.
+// The record attached to the request even with audit disabled, the identity used as the trigger of the job.
+func (s *Rest) auditRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/web/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rec := &audit.Record{Time: time.Now(), ClientIP: s.clientIP(r), UserAgent: r.UserAgent(),
+			Method: r.Method, Path: auditPath(r.URL.Path)}
+		if s.Audit == nil {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), auditCtxKey{}, rec)))
+			return
+		}
+		aw := &auditWriter{ResponseWriter: w}
+		defer func() {
+			if p := recover(); p != nil {
+				aw.status, rec.Reason = http.StatusInternalServerError, "panic" // recorded and passed to recoverer
+				s.writeAudit(rec, aw)
+				panic(p)
+			}
+			s.writeAudit(rec, aw)
+		}()
+		next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), auditCtxKey{}, rec)))
+	})
+}
+
+// writeAudit completes the record with the result of the request and writes it to audit log
+func (s *Rest) writeAudit(rec *audit.Record, aw *auditWriter) {
+	rec.Status = aw.status
+	if rec.Status == 0 {
+		rec.Status = http.StatusOK
+	}
+	rec.Decision = audit.Accepted
+	if rec.Status >= http.StatusBadRequest && rec.Job == "" {
+		rec.Decision = audit.Rejected // failed job is accepted request
+	}
+	if rec.Reason == "" && rec.Status >= http.StatusBadRequest {
+		rec.Reason = strings.TrimSpace(aw.body.String())
+	}
+	if err := s.Audit.Record(*rec); err != nil {
+		log.Printf("[ERROR] %v", err)
+	}
+}
+
+type auditCtxKey struct{}
+
+// auditRecord returns audit record of the request, handlers set details of the request with it.
+// Detached record returned for requests not passed through auditRequests.
+func auditRecord(r *http.Request) *audit.Record {
+	if rec, ok := r.Context().Value(auditCtxKey{}).(*audit.Record); ok {
+		return rec
+	}
+	return &audit.Record{}
+}
+
+// auditPath returns request path with the key of GET /update/{task}/{key} masked
+func auditPath(path string) string {
+	if rest, ok := strings.CutPrefix(path, "/update/"); ok {
+		if taskName, _, found := strings.Cut(rest, "/"); found {
+			return "/update/" + taskName + "/****"
+		}
+	}
+	return path
+}
+
+// auditWriter keeps response status and the beginning of plain text response, i.e. error message
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if w.status >= http.StatusBadRequest && w.body.Len() < maxAuditReason &&
+		strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain") {
+		w.body.Write(b[:min(len(b), maxAuditReason-w.body.Len())])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (w *auditWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
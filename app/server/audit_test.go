@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/audit"
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_Audit(t *testing.T) {
+	conf := &mocks.ConfigMock{
+		GetTasksFunc:       func() []task.Task { return []task.Task{{Name: "task1"}} },
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, name != "unknown" },
+		GetTaskFunc: func(name string) (task.Task, bool) {
+			return task.Task{Name: name, Key: "task-key",
+				Params: []task.Param{{Name: "VERSION"}, {Name: "TOKEN", Secret: true}}}, name != "unknown"
+		},
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	auditLog := &memAudit{}
+	srv := Rest{Config: conf, SecretKey: "12345", AdminKey: "admin", Runner: runner, Audit: auditLog}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	do := func(method, path, body, token string) {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("User-Agent", "test-agent")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	do("POST", "/update", `{"task":"task1","secret":"12345","params":{"VERSION":"1.2","TOKEN":"abc"}}`, "")
+	do("GET", "/update/task1/bad", "", "")
+	do("GET", "/update/task2/task-key", "", "")
+	do("POST", "/update", `{"task":"unknown","secret":"12345"}`, "")
+	do("GET", "/tasks", "", "admin")
+	do("GET", "/ping", "", "")
+
+	recs := auditLog.list()
+	require.Len(t, recs, 5, "ping not audited")
+	for _, rec := range recs {
+		assert.Equal(t, "127.0.0.1", rec.ClientIP)
+		assert.Equal(t, "test-agent", rec.UserAgent)
+		assert.False(t, rec.Time.IsZero())
+	}
+
+	assert.Equal(t, "POST", recs[0].Method)
+	assert.Equal(t, "/update", recs[0].Path)
+	assert.Equal(t, "secret", recs[0].Identity)
+	assert.Equal(t, "task1", recs[0].Task)
+	assert.Equal(t, map[string]string{"VERSION": "1.2", "TOKEN": "****"}, recs[0].Params, "secret parameter masked")
+	assert.Equal(t, http.StatusOK, recs[0].Status)
+	assert.Equal(t, audit.Accepted, recs[0].Decision)
+	assert.NotEmpty(t, recs[0].Job)
+
+	assert.Equal(t, "/update/task1/****", recs[1].Path, "key masked")
+	assert.Equal(t, "", recs[1].Identity)
+	assert.Equal(t, http.StatusForbidden, recs[1].Status)
+	assert.Equal(t, audit.Rejected, recs[1].Decision)
+	assert.Equal(t, "invalid key", recs[1].Reason)
+	assert.Empty(t, recs[1].Job)
+
+	assert.Equal(t, "task:task2", recs[2].Identity)
+	assert.Equal(t, "task2", recs[2].Task)
+	assert.Equal(t, audit.Accepted, recs[2].Decision)
+
+	assert.Equal(t, "unknown", recs[3].Task)
+	assert.Equal(t, http.StatusBadRequest, recs[3].Status)
+	assert.Equal(t, audit.Rejected, recs[3].Decision)
+	assert.Equal(t, "unknown command", recs[3].Reason, "reason from error response")
+
+	assert.Equal(t, "/tasks", recs[4].Path)
+	assert.Equal(t, "admin", recs[4].Identity)
+	assert.Equal(t, audit.Accepted, recs[4].Decision)
+}
+
+func TestRest_AuditFile(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	path := t.TempDir() + "/audit.log"
+	auditLog, err := audit.New(path, nil)
+	require.NoError(t, err)
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner, Audit: auditLog}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	for _, key := range []string{"12345", "bad", "12345"} {
+		resp, err := http.Get(ts.URL + "/update/task1/" + key)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+	require.NoError(t, auditLog.Close())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "12345", "key not recorded")
+	n, err := audit.Verify(strings.NewReader(string(data)))
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+}
+
+func TestAuditPath(t *testing.T) {
+	assert.Equal(t, "/update/task1/****", auditPath("/update/task1/secret"))
+	assert.Equal(t, "/update", auditPath("/update"))
+	assert.Equal(t, "/update/task1", auditPath("/update/task1"))
+	assert.Equal(t, "/jobs/123", auditPath("/jobs/123"))
+}
+
+// memAudit keeps audit records in memory
+type memAudit struct {
+	mu   sync.Mutex
+	recs []audit.Record
+}
+
+func (m *memAudit) Record(rec audit.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recs = append(m.recs, rec)
+	return nil
+}
+
+func (m *memAudit) list() []audit.Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]audit.Record{}, m.recs...)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+)
+
+const (
+	defaultMaxAuthFailures = 10
+	defaultBanDuration     = 15 * time.Minute
+)
+
+// Ban describes client ip banned for failed authentications
+type Ban struct {
+	IP       string    `json:"ip"`
+	Failures int       `json:"failures"`
+	Until    time.Time `json:"until"`
+}
+
+// authGuard tracks failed authentications per client ip and bans ip after too many failures.
+// Failures forgotten after ban duration since the last one.
+type authGuard struct {
+	maxFailures int
+	banDuration time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*authFailures
+	bans    int // total number of bans
+}
+
+type authFailures struct {
+	count       int
+	last        time.Time
+	bannedUntil time.Time
+}
+
+func newAuthGuard(maxFailures int, banDuration time.Duration) *authGuard {
+	if maxFailures <= 0 {
+		maxFailures = defaultMaxAuthFailures
+	}
+	if banDuration <= 0 {
+		banDuration = defaultBanDuration
+	}
+	return &authGuard{maxFailures: maxFailures, banDuration: banDuration, clients: map[string]*authFailures{}}
+}
+
+// fail records failed authentication of ip, returns number of failures and true if ip banned by this failure
+func (g *authGuard) fail(ip string) (count int, banned bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	g.cleanup(now)
+	f, ok := g.clients[ip]
+	if !ok {
+		f = &authFailures{}
+		g.clients[ip] = f
+	}
+	f.count++
+	f.last = now
+	if f.count >= g.maxFailures && !f.bannedUntil.After(now) {
+		f.bannedUntil = now.Add(g.banDuration)
+		g.bans++
+		return f.count, true
+	}
+	return f.count, false
+}
+
+// banned returns the time ip banned until, if banned
+func (g *authGuard) banned(ip string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, ok := g.clients[ip]
+	if !ok || !f.bannedUntil.After(time.Now()) {
+		return time.Time{}, false
+	}
+	return f.bannedUntil, true
+}
+
+// unban removes ban and failures of ip, returns false if ip not banned
+func (g *authGuard) unban(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	f, ok := g.clients[ip]
+	if !ok || !f.bannedUntil.After(time.Now()) {
+		return false
+	}
+	delete(g.clients, ip)
+	return true
+}
+
+// list returns active bans, sorted by ip, and total number of bans
+func (g *authGuard) list() (res []Ban, total int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	res = []Ban{}
+	for ip, f := range g.clients {
+		if f.bannedUntil.After(now) {
+			res = append(res, Ban{IP: ip, Failures: f.count, Until: f.bannedUntil})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].IP < res[j].IP })
+	return res, g.bans
+}
+
+// cleanup removes clients with expired bans and old failures
+func (g *authGuard) cleanup(now time.Time) {
+	for ip, f := range g.clients {
+		if !f.bannedUntil.After(now) && now.Sub(f.last) > g.banDuration {
+			delete(g.clients, ip)
+		}
+	}
+}
+
+// authFailed records failed authentication of the request client. The response is not delayed,
+// so failed attempts don't hold connections, guessing is limited by the ban after too many failures.
+func (s *Rest) authFailed(r *http.Request, reason string) {
+	ip := s.clientIP(r)
+	auditRecord(r).Reason = reason
+	count, banned := s.getGuard().fail(ip)
+	log.Printf("[WARN] failed authentication from %s, %s, failures: %d", ip, reason, count)
+	if banned {
+		log.Printf("[WARN] ip %s banned for %v after %d failed authentications", ip, s.getGuard().banDuration, count)
+	}
+}
+
+// agentFailures middleware reports agent requests rejected by the hub as failed authentications,
+// so guessing of agent tokens banned the same way as guessing of keys
+func (s *Rest) agentFailures(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(&rejectWriter{ResponseWriter: w, rejected: func() { s.authFailed(r, "invalid agent token") }}, r)
+	})
+}
+
+// rejectWriter calls rejected func before writing unauthorized status
+type rejectWriter struct {
+	http.ResponseWriter
+	rejected func()
+}
+
+func (w *rejectWriter) WriteHeader(status int) {
+	if status == http.StatusUnauthorized && w.rejected != nil {
+		w.rejected()
+		w.rejected = nil
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap returns the original writer, used by http.ResponseController
+func (w *rejectWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// banGuard middleware rejects requests from banned clients
+func (s *Rest) banGuard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if until, ok := s.getGuard().banned(s.clientIP(r)); ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(time.Until(until).Seconds())+1))
+			http.Error(w, "banned", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// GET /admin/bans returns active bans and total number of bans
+func (s *Rest) adminBansCtrl(w http.ResponseWriter, _ *http.Request) {
+	bans, total := s.getGuard().list()
+	rest.RenderJSON(w, rest.JSON{"bans": bans, "total": total})
+}
+
+// DELETE /admin/bans/{ip} removes ban of ip
+func (s *Rest) adminUnbanCtrl(w http.ResponseWriter, r *http.Request) {
+	ip := r.PathValue("ip")
+	if !s.getGuard().unban(ip) {
+		http.Error(w, "ban not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("[INFO] ip %s unbanned", ip)
+	rest.RenderJSON(w, rest.JSON{"unbanned": ip})
+}
+
+func (s *Rest) getGuard() *authGuard {
+	s.guardOnce.Do(func() { s.guard = newAuthGuard(s.MaxAuthFailures, s.BanDuration) })
+	return s.guard
+}
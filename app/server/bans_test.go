@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/agent"
+	"github.com/umputun/updater/app/audit"
+	"github.com/umputun/updater/app/server/mocks"
+)
+
+func TestRest_BanAfterFailures(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", AdminKey: "admin", Timeout: time.Second,
+		MaxAuthFailures: 3, TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	call := func(method, path, ip, key string) int {
+		req, err := http.NewRequest(method, ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("X-Forwarded-For", ip)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	st := time.Now()
+	assert.Equal(t, http.StatusForbidden, call("GET", "/update/task1/bad", "10.0.0.1", ""))
+	assert.Equal(t, http.StatusUnauthorized, call("GET", "/tasks", "10.0.0.1", "bad"))
+	assert.Less(t, time.Since(st), 200*time.Millisecond, "failed authentications not delayed")
+	assert.Equal(t, http.StatusOK, call("GET", "/update/task1/12345", "10.0.0.1", ""), "not banned yet")
+	assert.Equal(t, http.StatusForbidden, call("GET", "/update/task1/bad", "10.0.0.1", ""))
+	assert.Equal(t, http.StatusTooManyRequests, call("GET", "/update/task1/12345", "10.0.0.1", ""), "banned")
+	assert.Equal(t, http.StatusOK, call("GET", "/update/task1/12345", "10.0.0.2", ""), "other ip not banned")
+
+	req, err := http.NewRequest("GET", ts.URL+"/admin/bans", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer admin")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	res := struct {
+		Bans  []Ban `json:"bans"`
+		Total int   `json:"total"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	resp.Body.Close()
+	require.Equal(t, 1, len(res.Bans))
+	assert.Equal(t, "10.0.0.1", res.Bans[0].IP)
+	assert.Equal(t, 3, res.Bans[0].Failures)
+	assert.WithinDuration(t, time.Now().Add(defaultBanDuration), res.Bans[0].Until, time.Minute)
+	assert.Equal(t, 1, res.Total)
+
+	assert.Equal(t, http.StatusNotFound, call("DELETE", "/admin/bans/10.0.0.2", "127.0.0.1", "admin"))
+	assert.Equal(t, http.StatusOK, call("DELETE", "/admin/bans/10.0.0.1", "127.0.0.1", "admin"))
+	assert.Equal(t, http.StatusOK, call("GET", "/update/task1/12345", "10.0.0.1", ""), "unbanned")
+	assert.Equal(t, 3, len(runner.RunCalls()))
+}
+
+func TestRest_BanAgentTokens(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "", false }}
+	auditLog := &memAudit{}
+	hub := &agent.Hub{Tokens: map[string]string{"agent1": "token1"}, PollWait: 10 * time.Millisecond}
+	srv := Rest{Config: conf, SecretKey: "12345", AgentHub: hub, MaxAuthFailures: 2, Audit: auditLog}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	register := func(token string) int {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/agent/register", strings.NewReader(`{"tasks":["t1"]}`))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, register("token1"))
+	assert.Equal(t, http.StatusUnauthorized, register("bad"))
+	assert.Equal(t, http.StatusUnauthorized, register("bad"))
+	assert.Equal(t, http.StatusTooManyRequests, register("token1"), "banned after failed agent tokens")
+
+	recs := auditLog.list()
+	require.Len(t, recs, 4)
+	assert.Equal(t, "/agent/register", recs[1].Path)
+	assert.Equal(t, audit.Rejected, recs[1].Decision)
+	assert.Equal(t, "invalid agent token", recs[1].Reason)
+}
+
+func TestAuthGuard(t *testing.T) {
+	g := newAuthGuard(2, 50*time.Millisecond)
+	count, banned := g.fail("10.0.0.1")
+	assert.Equal(t, 1, count)
+	assert.False(t, banned)
+	_, ok := g.banned("10.0.0.1")
+	assert.False(t, ok)
+
+	count, banned = g.fail("10.0.0.1")
+	assert.Equal(t, 2, count)
+	assert.True(t, banned)
+	until, ok := g.banned("10.0.0.1")
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(50*time.Millisecond), until, 20*time.Millisecond)
+
+	_, banned = g.fail("10.0.0.1")
+	assert.False(t, banned, "banned already")
+	bans, total := g.list()
+	assert.Equal(t, 1, len(bans))
+	assert.Equal(t, 1, total)
+
+	time.Sleep(60 * time.Millisecond)
+	_, ok = g.banned("10.0.0.1")
+	assert.False(t, ok, "ban expired")
+	time.Sleep(60 * time.Millisecond)
+	count, _ = g.fail("10.0.0.2")
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 1, len(g.clients), "expired failures removed")
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/updater/app/task"
+)
+
+// realIP middleware resolves ip of the client and replaces remote address of the request with it,
+// so rate limiting and logs use the real client ip as well
+func (s *Rest) realIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := s.clientIP(r)
+		ctx := context.WithValue(r.Context(), clientIPCtxKey{}, ip)
+		r = r.WithContext(ctx)
+		if _, port, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			r.RemoteAddr = net.JoinHostPort(ip, port)
+		} else {
+			r.RemoteAddr = ip // unix socket peer has no port
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type clientIPCtxKey struct{}
+
+// ipFilter middleware rejects requests from networks not allowed globally
+func (s *Rest) ipFilter(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := s.clientIP(r); !task.IPAllowed(parseAddr(ip), s.AllowCIDRs, s.DenyCIDRs) {
+			log.Printf("[WARN] request from %s not allowed", ip)
+			http.Error(w, "not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns ip of the request client. X-Forwarded-For and X-Real-IP headers
+// used only for requests from trusted proxies and from unix socket, access to the socket is limited by its permissions.
+func (s *Rest) clientIP(r *http.Request) string {
+	if ip, ok := r.Context().Value(clientIPCtxKey{}).(string); ok {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	unixSocket := host == "@" || host == "" // remote address of unix socket peer
+	if addr, err := netip.ParseAddr(host); !unixSocket && (err != nil || !s.trustedProxy(addr)) {
+		return host
+	}
+	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+		// the last address not belonging to trusted proxies is the client, the leftmost one can be spoofed
+		ips := strings.Split(xff, ",")
+		for i := len(ips) - 1; i >= 0; i-- {
+			ip, err := netip.ParseAddr(strings.TrimSpace(ips[i]))
+			if err != nil {
+				break
+			}
+			if !s.trustedProxy(ip) || i == 0 {
+				return ip.Unmap().String()
+			}
+		}
+	}
+	if ip, err := netip.ParseAddr(strings.TrimSpace(r.Header.Get("X-Real-IP"))); err == nil {
+		return ip.Unmap().String()
+	}
+	return host
+}
+
+// parseAddr parses ip, invalid address returned for non-ip values, i.e. for unix socket clients
+func parseAddr(ip string) netip.Addr {
+	res, _ := netip.ParseAddr(ip)
+	return res
+}
+
+func (s *Rest) trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range s.TrustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
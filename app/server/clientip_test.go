@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_clientIP(t *testing.T) {
+	srv := Rest{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}}
+	tbl := []struct {
+		remote, xff, realIP string
+		res                 string
+	}{
+		{"1.2.3.4:1234", "", "", "1.2.3.4"},
+		{"1.2.3.4:1234", "5.6.7.8", "", "1.2.3.4"},
+		{"10.0.0.1:1234", "5.6.7.8", "", "5.6.7.8"},
+		{"10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 10.0.0.2", "", "5.6.7.8"},
+		{"10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"10.0.0.1:1234", "", "5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:1234", "bad", "5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:1234", "", "", "10.0.0.1"},
+		{"[::1]:1234", "5.6.7.8", "", "5.6.7.8"},
+		{"[::ffff:10.0.0.1]:1234", "5.6.7.8", "", "5.6.7.8"},
+		{"@", "5.6.7.8", "", "5.6.7.8"},
+		{"@", "", "", "@"},
+	}
+	for _, tt := range tbl {
+		r := httptest.NewRequest("GET", "/", http.NoBody)
+		r.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			r.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-IP", tt.realIP)
+		}
+		assert.Equal(t, tt.res, srv.clientIP(r), "%+v", tt)
+	}
+}
+
+func TestRest_IPFilter(t *testing.T) {
+	tasks := map[string]task.Task{
+		"deploy":  {Name: "deploy", Command: "deploy.sh", AllowCIDRs: []string{"10.1.0.0/16"}},
+		"migrate": {Name: "migrate", Command: "migrate.sh", DenyCIDRs: []string{"10.2.0.1"}},
+	}
+	conf := &mocks.ConfigMock{
+		GetTaskFunc:        func(name string) (task.Task, bool) { t, ok := tasks[name]; return t, ok },
+		GetTaskCommandFunc: func(name string) (string, bool) { t, ok := tasks[name]; return t.Command, ok },
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Second,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		AllowCIDRs:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		DenyCIDRs:      []netip.Prefix{netip.MustParsePrefix("10.3.0.0/16")}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	call := func(path, ip string) (int, string) {
+		req, err := http.NewRequest("GET", ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("X-Real-IP", ip)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	tbl := []struct {
+		path, ip string
+		code     int
+	}{
+		{"/update/deploy/12345", "10.1.2.3", http.StatusOK},
+		{"/update/deploy/12345", "10.2.2.3", http.StatusForbidden},
+		{"/update/deploy/12345", "1.2.3.4", http.StatusForbidden},
+		{"/update/migrate/12345", "10.2.2.3", http.StatusOK},
+		{"/update/migrate/12345", "10.2.0.1", http.StatusForbidden},
+		{"/update/migrate/12345", "10.3.0.1", http.StatusForbidden},
+		{"/update/migrate/bad", "1.2.3.4", http.StatusForbidden},
+	}
+	for _, tt := range tbl {
+		code, body := call(tt.path, tt.ip)
+		assert.Equal(t, tt.code, code, "%+v, %s", tt, body)
+	}
+	assert.Equal(t, 2, len(runner.RunCalls()))
+	_, banned := srv.getGuard().banned("1.2.3.4")
+	assert.False(t, banned)
+	assert.Equal(t, 0, len(srv.getGuard().clients), "denied requests not counted as failed authentications")
+
+	code, body := call("/update/deploy/12345", "10.1.0.5")
+	require.Equal(t, http.StatusOK, code)
+	res := struct{ Job string }{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	job, ok := srv.getJobs().get(res.Job)
+	require.True(t, ok)
+	assert.Equal(t, "10.1.0.5", job.ClientIP, "real client ip kept in job history")
+}
+
+func TestRest_IPFilterDashboard(t *testing.T) {
+	tasks := map[string]task.Task{"deploy": {Name: "deploy", Command: "deploy.sh", AllowCIDRs: []string{"10.1.0.0/16"}}}
+	conf := &mocks.ConfigMock{
+		GetTaskFunc:        func(name string) (task.Task, bool) { t, ok := tasks[name]; return t, ok },
+		GetTaskCommandFunc: func(name string) (string, bool) { t, ok := tasks[name]; return t.Command, ok },
+		GetTasksFunc:       func() []task.Task { return []task.Task{tasks["deploy"]} },
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", AdminKey: "admin", Timeout: time.Second,
+		TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.1/32")},
+		AllowCIDRs:     []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		DenyCIDRs:      []netip.Prefix{netip.MustParsePrefix("10.3.0.0/16")}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	call := func(method, path, ip string) int {
+		req, err := http.NewRequest(method, ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("X-Real-IP", ip)
+		req.Header.Set("Authorization", "Bearer admin")
+		resp, err := noRedirectClient().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	tbl := []struct {
+		method, path, ip string
+		code             int
+	}{
+		{http.MethodGet, "/web/", "10.1.2.3", http.StatusOK},
+		{http.MethodGet, "/web/", "10.3.2.3", http.StatusForbidden},
+		{http.MethodGet, "/web/", "1.2.3.4", http.StatusForbidden},
+		{http.MethodGet, "/web/login", "1.2.3.4", http.StatusForbidden},
+		{http.MethodGet, "/admin/freeze", "10.1.2.3", http.StatusOK},
+		{http.MethodGet, "/admin/freeze", "10.3.2.3", http.StatusForbidden},
+		{http.MethodGet, "/admin/freeze", "1.2.3.4", http.StatusForbidden},
+		{http.MethodGet, "/tasks", "1.2.3.4", http.StatusForbidden},
+		{http.MethodGet, "/jobs/0123456789abcdef", "1.2.3.4", http.StatusForbidden},
+		{http.MethodPost, "/web/tasks/deploy/run", "1.2.3.4", http.StatusForbidden},
+		{http.MethodPost, "/web/tasks/deploy/run", "10.2.2.3", http.StatusForbidden}, // not allowed for the task
+		{http.MethodPost, "/web/tasks/deploy/run", "10.1.2.3", http.StatusSeeOther},
+		{http.MethodGet, "/ping", "1.2.3.4", http.StatusOK},
+	}
+	for _, tt := range tbl {
+		assert.Equal(t, tt.code, call(tt.method, tt.path, tt.ip), "%+v", tt)
+	}
+	assert.Eventually(t, func() bool { return len(runner.RunCalls()) == 1 }, time.Second, 10*time.Millisecond)
+}
+
+func TestRest_realIP(t *testing.T) {
+	srv := Rest{TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	var remote, ip string
+	h := srv.realIP(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		remote, ip = r.RemoteAddr, srv.clientIP(r)
+	}))
+	r := httptest.NewRequest("GET", "/", http.NoBody)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "5.6.7.8, 10.0.0.2")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, "5.6.7.8:1234", remote)
+	assert.Equal(t, "5.6.7.8", ip, "resolved once, not affected by replaced remote address")
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/umputun/updater/app/events"
+	"github.com/umputun/updater/app/task"
+)
+
+// EventPublisher publishes job lifecycle events
+type EventPublisher interface {
+	Publish(ev events.Event)
+}
+
+// jobEvent is the data of job event, the job with the completed step for step events
+type jobEvent struct {
+	Job
+	Step *task.Step `json:"step,omitempty"`
+}
+
+// publishJob publishes event with the current state of the job, trace of the context passed with the event
+func (s *Rest) publishJob(ctx context.Context, eventType, id string, step *task.Step) {
+	if s.Events == nil {
+		return
+	}
+	job, ok := s.getJobs().get(id)
+	if !ok {
+		return
+	}
+	traceCtx := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, traceCtx)
+	s.Events.Publish(events.Event{Type: eventType, Subject: job.ID, TraceParent: traceCtx.Get("traceparent"),
+		Data: jobEvent{Job: job, Step: step}})
+}
+
+// finishJob completes the job and publishes succeeded or failed event
+func (s *Rest) finishJob(ctx context.Context, id string, err error) {
+	s.getJobs().finish(id, err)
+	eventType := events.JobSucceeded
+	if err != nil {
+		eventType = events.JobFailed
+	}
+	s.publishJob(ctx, eventType, id, nil)
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/events"
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_Events(t *testing.T) {
+	commands := map[string]string{"ok": "echo 1\necho 2", "fail": "echo 1\nfalse"}
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		cmd, ok := commands[name]
+		return cmd, ok
+	}}
+	pub := &memEvents{}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: &task.ShellRunner{}, Events: pub}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"ok","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	evs := pub.list()
+	require.Len(t, evs, 4)
+	assert.Equal(t, []string{events.JobStarted, events.JobStep, events.JobStep, events.JobSucceeded}, eventTypes(evs))
+	data := evs[0].Data.(jobEvent)
+	assert.Equal(t, "ok", data.Task)
+	assert.Equal(t, JobRunning, data.Status)
+	assert.Equal(t, data.ID, evs[0].Subject)
+	assert.Equal(t, "echo 1", evs[1].Data.(jobEvent).Step.Name)
+	assert.Equal(t, "echo 2", evs[2].Data.(jobEvent).Step.Name)
+	assert.Empty(t, evs[2].Data.(jobEvent).Step.Error)
+	assert.Equal(t, JobSuccess, evs[3].Data.(jobEvent).Status)
+	assert.Nil(t, evs[3].Data.(jobEvent).Step)
+
+	pub.reset()
+	resp, err = http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"fail","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	evs = pub.list()
+	assert.Equal(t, []string{events.JobStarted, events.JobStep, events.JobStep, events.JobFailed}, eventTypes(evs))
+	assert.Equal(t, "failed to execute false: exit status 1", evs[2].Data.(jobEvent).Step.Error)
+	assert.Equal(t, JobFailed, evs[3].Data.(jobEvent).Status)
+	assert.Equal(t, 1, evs[3].Data.(jobEvent).ExitCode)
+}
+
+func TestRest_EventsQueued(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	pub := &memEvents{}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", FreezeQueue: true, Timeout: time.Second, Events: pub}
+	_, err := srv.getState().freeze("test", 0)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	evs := pub.list()
+	require.Len(t, evs, 1)
+	assert.Equal(t, events.JobQueued, evs[0].Type)
+	assert.Equal(t, JobQueued, evs[0].Data.(jobEvent).Status)
+
+	require.NoError(t, srv.getState().unfreeze())
+	require.Eventually(t, func() bool { return len(pub.list()) == 3 }, 3*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{events.JobQueued, events.JobStarted, events.JobSucceeded}, eventTypes(pub.list()))
+}
+
+// memEvents keeps published events in memory
+type memEvents struct {
+	mu  sync.Mutex
+	evs []events.Event
+}
+
+func (m *memEvents) Publish(ev events.Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evs = append(m.evs, ev)
+}
+
+func (m *memEvents) list() []events.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]events.Event{}, m.evs...)
+}
+
+func (m *memEvents) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.evs = nil
+}
+
+func eventTypes(evs []events.Event) []string {
+	res := make([]string, 0, len(evs))
+	for _, ev := range evs {
+		res = append(res, ev.Type)
+	}
+	return res
+}
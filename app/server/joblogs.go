@@ -0,0 +1,297 @@
+package server
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+)
+
+// JobLogsOpts defines per-job log files. Output of each job written to <dir>/<id>.log, and the job itself
+// with lines of the output to <dir>/<id>.json on completion, so all available through the api after the job
+// dropped from memory.
+type JobLogsOpts struct {
+	Dir      string        // directory of job log files, disabled if empty
+	MaxAge   time.Duration // files of jobs older than max age removed, kept forever if 0
+	MaxFiles int           // max number of jobs kept, the oldest removed, unlimited if 0
+	MaxSize  int64         // max size of a single log file, the rest of output dropped, unlimited if 0
+}
+
+// Enabled returns true if per-job log files enabled
+func (o JobLogsOpts) Enabled() bool {
+	return o.Dir != ""
+}
+
+// path returns file of the job with given extension, job id validated as it comes from requests
+func (o JobLogsOpts) path(id, ext string) (string, bool) {
+	if !o.Enabled() || len(id) != 16 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(id); err != nil {
+		return "", false
+	}
+	return filepath.Join(o.Dir, id+ext), true
+}
+
+// create makes log file of the job
+func (o JobLogsOpts) create(id string) (*jobLogFile, error) {
+	path, ok := o.path(id, ".log")
+	if !ok {
+		return nil, fmt.Errorf("invalid job id %q", id)
+	}
+	if err := os.MkdirAll(o.Dir, 0o750); err != nil {
+		return nil, fmt.Errorf("can't make job logs directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640) //nolint:gosec // path of validated id
+	if err != nil {
+		return nil, fmt.Errorf("can't create job log: %w", err)
+	}
+	return &jobLogFile{f: f, left: o.MaxSize, limited: o.MaxSize > 0}, nil
+}
+
+// storedJob is a completed job saved next to its log file, with the lines of output kept in memory
+type storedJob struct {
+	Job
+	Lines       []Line `json:"lines,omitempty"`
+	LinesOffset int    `json:"lines_offset,omitempty"` // number of lines dropped before the first kept line
+}
+
+// save writes completed job next to its log file
+func (o JobLogsOpts) save(job storedJob) error {
+	path, ok := o.path(job.ID, ".json")
+	if !ok {
+		return fmt.Errorf("invalid job id %q", job.ID)
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("can't marshal job %s: %w", job.ID, err)
+	}
+	if err = os.WriteFile(path, data, 0o640); err != nil {
+		return fmt.Errorf("can't save job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// load returns completed job saved with its log file
+func (o JobLogsOpts) load(id string) (storedJob, bool) {
+	path, ok := o.path(id, ".json")
+	if !ok {
+		return storedJob{}, false
+	}
+	data, err := os.ReadFile(path) //nolint:gosec // path of validated id
+	if err != nil {
+		return storedJob{}, false
+	}
+	var job storedJob
+	if err = json.Unmarshal(data, &job); err != nil {
+		log.Printf("[WARN] can't load job %s, %v", id, err)
+		return storedJob{}, false
+	}
+	return job, true
+}
+
+// open returns log file of the job
+func (o JobLogsOpts) open(id string) (*os.File, bool) {
+	path, ok := o.path(id, ".log")
+	if !ok {
+		return nil, false
+	}
+	f, err := os.Open(path) //nolint:gosec // path of validated id
+	if err != nil {
+		return nil, false
+	}
+	return f, true
+}
+
+// cleanup removes files of jobs older than max age and the oldest jobs above max files.
+// Files of jobs still running are kept, active returns true for them.
+func (o JobLogsOpts) cleanup(active func(id string) bool) {
+	if !o.Enabled() || (o.MaxAge <= 0 && o.MaxFiles <= 0) {
+		return
+	}
+	entries, err := os.ReadDir(o.Dir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			log.Printf("[WARN] can't read job logs, %v", err)
+		}
+		return
+	}
+	type logFile struct {
+		id    string
+		mtime time.Time
+	}
+	files := make([]logFile, 0, len(entries))
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".log")
+		if !ok || e.IsDir() || active(id) {
+			continue
+		}
+		if _, valid := o.path(id, ".log"); !valid {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // removed concurrently
+		}
+		files = append(files, logFile{id: id, mtime: fi.ModTime()})
+	}
+	sort.Slice(files, func(i, k int) bool { return files[i].mtime.After(files[k].mtime) }) // the most recent first
+	for i, f := range files {
+		expired := o.MaxAge > 0 && time.Since(f.mtime) > o.MaxAge
+		if !expired && (o.MaxFiles <= 0 || i < o.MaxFiles) {
+			continue
+		}
+		for _, ext := range []string{".log", ".json"} {
+			if err := os.Remove(filepath.Join(o.Dir, f.id+ext)); err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Printf("[WARN] can't remove job log, %v", err)
+			}
+		}
+	}
+}
+
+// jobLogFile writes job output to file up to the size limit, the rest of output dropped.
+// Write errors logged and stop writing to the file, they don't break the output of the job.
+type jobLogFile struct {
+	f       *os.File
+	left    int64
+	limited bool
+	stopped bool
+}
+
+func (l *jobLogFile) Write(p []byte) (int, error) {
+	if l.stopped {
+		return len(p), nil
+	}
+	data, truncated := p, false
+	if l.limited && int64(len(data)) > l.left {
+		data, truncated = data[:l.left], true
+	}
+	if truncated {
+		data = append(data[:len(data):len(data)], "\n... output truncated, max size of job log reached\n"...)
+	}
+	n, err := l.f.Write(data)
+	l.left -= int64(n)
+	if err != nil {
+		log.Printf("[WARN] can't write job log %s, %v", l.f.Name(), err)
+	}
+	l.stopped = truncated || err != nil
+	return len(p), nil
+}
+
+func (l *jobLogFile) Close() error {
+	if err := l.f.Close(); err != nil {
+		return fmt.Errorf("can't close job log: %w", err)
+	}
+	return nil
+}
+
+// openJobLog makes log file of the job if job logs enabled, failure to make it doesn't fail the job
+func (s *Rest) openJobLog(job Job) io.WriteCloser {
+	if !s.JobLogs.Enabled() {
+		return nil
+	}
+	f, err := s.JobLogs.create(job.ID)
+	if err != nil {
+		log.Printf("[WARN] job %s, %v", job.ID, err)
+		return nil
+	}
+	return f
+}
+
+// closeJobLog closes log file of the completed job, saves the job next to it and removes expired job logs
+func (s *Rest) closeJobLog(id string, f io.Closer) {
+	if f == nil {
+		return
+	}
+	if err := f.Close(); err != nil {
+		log.Printf("[WARN] job %s, %v", id, err)
+	}
+	if job, ok := s.getJobs().get(id); ok {
+		lines, next, _ := s.getJobs().lines(id, 0)
+		if err := s.JobLogs.save(storedJob{Job: job, Lines: lines, LinesOffset: next - len(lines)}); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}
+	s.cleanupJobLogs()
+}
+
+// cleanupJobLogs removes expired job logs, logs of active jobs are kept
+func (s *Rest) cleanupJobLogs() {
+	s.JobLogs.cleanup(func(id string) bool {
+		job, ok := s.getJobs().get(id)
+		return ok && job.Status != JobSuccess && job.Status != JobFailed
+	})
+}
+
+// findJob returns job by id, completed jobs dropped from memory loaded from job logs
+func (s *Rest) findJob(id string) (Job, bool) {
+	if job, ok := s.getJobs().get(id); ok {
+		return job, true
+	}
+	stored, ok := s.JobLogs.load(id)
+	return stored.Job, ok
+}
+
+// GET /jobs/{id}/output returns the whole output of the job as plain text, from the job log file if enabled
+func (s *Rest) jobOutputCtrl(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(r.PathValue("id"))
+	if !ok || !s.requestAllowed(r, job.Task) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Job-Status", job.Status)
+	if f, found := s.JobLogs.open(job.ID); found {
+		defer f.Close() //nolint:errcheck // read only
+		if _, err := io.Copy(w, f); err != nil {
+			log.Printf("[WARN] can't send output of job %s, %v", job.ID, err)
+		}
+		return
+	}
+	data, _, found := s.getJobs().logs(job.ID, 0)
+	if !found {
+		http.Error(w, "job output not found", http.StatusNotFound)
+		return
+	}
+	_, _ = w.Write(data)
+}
+
+// storedJobLines returns lines of the job dropped from memory from line offset and the offset of the next portion
+func (s *Rest) storedJobLines(id string, offset int) (lines []Line, next int) {
+	stored, ok := s.JobLogs.load(id)
+	if !ok {
+		return []Line{}, 0
+	}
+	start := min(max(offset-stored.LinesOffset, 0), len(stored.Lines))
+	return append(make([]Line, 0, len(stored.Lines)-start), stored.Lines[start:]...), stored.LinesOffset + len(stored.Lines)
+}
+
+// storedJobLogs returns output of the job dropped from memory from offset and the offset of the next portion
+func (s *Rest) storedJobLogs(w http.ResponseWriter, job Job, offset int) {
+	f, ok := s.JobLogs.open(job.ID)
+	if !ok {
+		http.Error(w, "job output not found", http.StatusNotFound)
+		return
+	}
+	defer f.Close() //nolint:errcheck // read only
+	if _, err := f.Seek(int64(max(offset, 0)), io.SeekStart); err != nil {
+		http.Error(w, "can't read job output", http.StatusInternalServerError)
+		return
+	}
+	data, err := io.ReadAll(io.LimitReader(f, maxJobLogSize))
+	if err != nil {
+		http.Error(w, "can't read job output", http.StatusInternalServerError)
+		return
+	}
+	rest.RenderJSON(w, rest.JSON{"data": string(data), "next": max(offset, 0) + len(data), "status": job.Status})
+}
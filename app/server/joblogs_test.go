@@ -0,0 +1,181 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/jsonlog"
+	"github.com/umputun/updater/app/server/mocks"
+)
+
+func TestRest_JobLogs(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "echo " + name, true
+	}}
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, _ string, w, _ io.Writer) error {
+		_, _ = w.Write([]byte("line1\nline2\n"))
+		return errors.New("failed")
+	}}
+	dir := t.TempDir()
+	jsonOut := bytes.Buffer{}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner, JobLogs: JobLogsOpts{Dir: filepath.Join(dir, "jobs")},
+		JSONLog: jsonlog.New(&jsonOut)}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	jobs := srv.getJobs().list()
+	require.Len(t, jobs, 1)
+	res := struct{ Job string }{Job: jobs[0].ID}
+
+	data, err := os.ReadFile(filepath.Join(dir, "jobs", res.Job+".log"))
+	require.NoError(t, err)
+	assert.Equal(t, "line1\nline2\n", string(data))
+	assert.Contains(t, jsonOut.String(), `"msg":"line2","job":"`+res.Job+`","task":"task1","trigger":"secret","stream":"stdout"}`)
+
+	srv.jobs = newJobs() // job dropped from memory, served from job logs
+	get := func(path string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer 12345")
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/jobs/" + res.Job)
+	require.Equal(t, http.StatusOK, code)
+	job := Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, "failed", job.Error)
+	assert.Equal(t, "secret", job.Trigger)
+	assert.Equal(t, "127.0.0.1", job.ClientIP)
+
+	code, body = get("/jobs/" + res.Job + "/logs?offset=6")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"data":"line2\n","next":12,"status":"failed"}`+"\n", body)
+
+	code, body = get("/jobs/" + res.Job + "/output")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "line1\nline2\n", body)
+
+	code, body = get("/jobs/" + res.Job + "/lines?offset=1")
+	require.Equal(t, http.StatusOK, code)
+	lines := struct {
+		Lines []Line
+		Next  int
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &lines))
+	assert.Equal(t, 2, lines.Next)
+	require.Len(t, lines.Lines, 1)
+	assert.Equal(t, "line2", lines.Lines[0].Text)
+	assert.Equal(t, StreamStdout, lines.Lines[0].Stream)
+
+	for _, path := range []string{"/jobs/0123456789abcdef", "/jobs/..%2F..%2Fetc%2Fpasswd/output", "/jobs/bad/logs"} {
+		code, _ = get(path)
+		assert.Equal(t, http.StatusNotFound, code, path)
+	}
+}
+
+func TestRest_JobOutputFromMemory(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "echo " + name, true
+	}}
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, _ string, w, _ io.Writer) error {
+		_, _ = w.Write([]byte("line1\n"))
+		return nil
+	}}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	res := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/jobs/"+res.Job+"/output", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer 12345")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "success", resp.Header.Get("X-Job-Status"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "line1\n", string(body))
+}
+
+func TestJobLogsOpts_MaxSize(t *testing.T) {
+	opts := JobLogsOpts{Dir: t.TempDir(), MaxSize: 10}
+	f, err := opts.create("0123456789abcdef")
+	require.NoError(t, err)
+	for _, s := range []string{"12345", "67890abc", "def"} {
+		n, err := f.Write([]byte(s))
+		require.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+	require.NoError(t, f.Close())
+
+	data, err := os.ReadFile(filepath.Join(opts.Dir, "0123456789abcdef.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "1234567890\n... output truncated, max size of job log reached\n", string(data))
+
+	_, err = opts.create("../bad")
+	require.EqualError(t, err, `invalid job id "../bad"`)
+}
+
+func TestJobLogsOpts_Cleanup(t *testing.T) {
+	opts := JobLogsOpts{Dir: t.TempDir(), MaxAge: time.Hour, MaxFiles: 2}
+	now := time.Now()
+	files := map[string]time.Duration{ // id -> age
+		"0000000000000001": 2 * time.Hour, // expired
+		"0000000000000002": 3 * time.Minute,
+		"0000000000000003": 2 * time.Minute,
+		"0000000000000004": time.Minute,
+		"0000000000000005": 4 * time.Minute, // above max files, but active
+	}
+	for id, age := range files {
+		for _, ext := range []string{".log", ".json"} {
+			path := filepath.Join(opts.Dir, id+ext)
+			require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+			require.NoError(t, os.Chtimes(path, now.Add(-age), now.Add(-age)))
+		}
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(opts.Dir, "other.log"), []byte("data"), 0o600))
+
+	opts.cleanup(func(id string) bool { return id == "0000000000000005" })
+
+	entries, err := os.ReadDir(opts.Dir)
+	require.NoError(t, err)
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"0000000000000003.json", "0000000000000003.log", "0000000000000004.json",
+		"0000000000000004.log", "0000000000000005.json", "0000000000000005.log", "other.log"}, names)
+
+	JobLogsOpts{Dir: filepath.Join(opts.Dir, "missing"), MaxFiles: 1}.cleanup(func(string) bool { return false })
+}
@@ -0,0 +1,370 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// job statuses
+const (
+	JobPending = "pending"
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobSuccess = "success"
+	JobFailed  = "failed"
+)
+
+// output streams of the job
+const (
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
+const (
+	maxJobsHistory = 100     // number of completed jobs kept in history
+	maxJobLogSize  = 1 << 20 // max size of job output kept in memory, older lines dropped
+	maxLineSize    = 64 << 10
+)
+
+// Job is a single execution of the task
+type Job struct {
+	ID         string        `json:"id"`
+	Task       string        `json:"task"`
+	Status     string        `json:"status"`
+	Error      string        `json:"error,omitempty"`
+	ExitCode   int           `json:"exit_code"`
+	RunAt      *time.Time    `json:"run_at,omitempty"` // expected start of the deferred job
+	Approver   string        `json:"approver,omitempty"`
+	ClientIP   string        `json:"client_ip,omitempty"` // ip of the client triggered the job
+	Trigger    string        `json:"trigger,omitempty"`   // who triggered the job, i.e. secret, token:subject, web or hub
+	TraceID    string        `json:"trace_id,omitempty"`  // trace of the request triggered the job, if traced
+	StartedAt  time.Time     `json:"started_at"`
+	FinishedAt time.Time     `json:"finished_at"`
+	Duration   time.Duration `json:"duration"`
+}
+
+// Line is a single line of job output, with the time the line started and the stream it came from
+type Line struct {
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream"`
+	Text   string    `json:"text"`
+}
+
+// jobSource describes who triggered the job
+type jobSource struct {
+	ClientIP string
+	Trigger  string
+	Trace    trace.SpanContext // span of the request, parent of the job span
+}
+
+// jobs keeps running and completed jobs with their output
+type jobs struct {
+	mu      sync.RWMutex
+	entries map[string]*jobEntry
+}
+
+// jobEntry keeps the job with its output as lines, the combined output made of these lines with newlines
+type jobEntry struct {
+	Job
+	lines        []Line
+	size         int              // size of combined output of kept lines
+	dropped      int              // size of combined output of lines dropped from the head
+	linesDropped int              // number of lines dropped from the head
+	partial      map[string]*Line // incomplete last line of each stream
+}
+
+func newJobs() *jobs {
+	return &jobs{entries: map[string]*jobEntry{}}
+}
+
+// start makes a new running job
+func (j *jobs) start(taskName string, src jobSource) Job {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	job := Job{ID: hex.EncodeToString(b), Task: taskName, Status: JobRunning, StartedAt: time.Now(),
+		ClientIP: src.ClientIP, Trigger: src.Trigger}
+	if src.Trace.IsValid() {
+		job.TraceID = src.Trace.TraceID().String()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries[job.ID] = &jobEntry{Job: job}
+	j.cleanup()
+	return job
+}
+
+// queue makes a new job waiting to be started with run, runAt is the expected start if known
+func (j *jobs) queue(taskName string, runAt *time.Time, src jobSource) Job {
+	job := j.start(taskName, src)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job.Status, job.RunAt = JobQueued, runAt
+	j.entries[job.ID].Job = job
+	return job
+}
+
+// pending makes a new job waiting for approval
+func (j *jobs) pending(taskName string, src jobSource) Job {
+	job := j.start(taskName, src)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job.Status = JobPending
+	j.entries[job.ID].Job = job
+	return job
+}
+
+// running returns number of running jobs
+func (j *jobs) running() int {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	res := 0
+	for _, e := range j.entries {
+		if e.Status == JobRunning {
+			res++
+		}
+	}
+	return res
+}
+
+// approve marks pending job approved, it is queued until started with run
+func (j *jobs) approve(id, approver string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e, ok := j.entries[id]; ok {
+		e.Status = JobQueued
+		e.Approver = approver
+	}
+}
+
+// run marks queued job running
+func (j *jobs) run(id string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if e, ok := j.entries[id]; ok {
+		e.Status = JobRunning
+		e.StartedAt = time.Now()
+	}
+}
+
+// finish marks job completed with error or success
+func (j *jobs) finish(id string, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[id]
+	if !ok {
+		return
+	}
+	e.flushLines()
+	e.FinishedAt = time.Now()
+	e.Duration = e.FinishedAt.Sub(e.StartedAt)
+	e.Status = JobSuccess
+	if err != nil {
+		e.Status = JobFailed
+		e.Error = err.Error()
+		e.ExitCode = exitCode(err)
+	}
+}
+
+// exitCode returns exit code of the failed command, or 1 if the error doesn't carry it
+func exitCode(err error) int {
+	var ec interface{ ExitCode() int }
+	if errors.As(err, &ec) && ec.ExitCode() > 0 {
+		return ec.ExitCode()
+	}
+	var es interface{ ExitStatus() int } // ssh exit error
+	if errors.As(err, &es) && es.ExitStatus() > 0 {
+		return es.ExitStatus()
+	}
+	return 1
+}
+
+// get returns job by id
+func (j *jobs) get(id string) (Job, bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	e, ok := j.entries[id]
+	if !ok {
+		return Job{}, false
+	}
+	return e.Job, true
+}
+
+// list returns all jobs, the most recent first
+func (j *jobs) list() []Job {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	res := make([]Job, 0, len(j.entries))
+	for _, e := range j.entries {
+		res = append(res, e.Job)
+	}
+	sort.Slice(res, func(i, k int) bool { return res[i].StartedAt.After(res[k].StartedAt) })
+	return res
+}
+
+// last returns the most recent job of the task
+func (j *jobs) last(taskName string) (Job, bool) {
+	for _, job := range j.list() {
+		if job.Task == taskName {
+			return job, true
+		}
+	}
+	return Job{}, false
+}
+
+// logs returns combined job output starting from offset and the offset of the next portion.
+// Output is made of lines, incomplete lines are not returned until completed or the job finished.
+func (j *jobs) logs(id string, offset int) (data []byte, next int, ok bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	e, ok := j.entries[id]
+	if !ok {
+		return nil, 0, false
+	}
+	start := max(offset-e.dropped, 0)
+	data = make([]byte, 0, max(e.size-start, 0))
+	pos := 0
+	for _, l := range e.lines {
+		if skip := start - pos; skip <= len(l.Text) {
+			data = append(data, l.Text[max(skip, 0):]...)
+			data = append(data, '\n')
+		}
+		pos += len(l.Text) + 1
+	}
+	return data, e.dropped + e.size, true
+}
+
+// lines returns lines of job output starting from line offset and the offset of the next portion.
+// Incomplete lines are not returned until completed or the job finished.
+func (j *jobs) lines(id string, offset int) (lines []Line, next int, ok bool) {
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+	e, ok := j.entries[id]
+	if !ok {
+		return nil, 0, false
+	}
+	start := min(max(offset-e.linesDropped, 0), len(e.lines))
+	return append(make([]Line, 0, len(e.lines)-start), e.lines[start:]...), e.linesDropped + len(e.lines), true
+}
+
+// writer returns writer appending to job output of the stream
+func (j *jobs) writer(id, stream string) *jobWriter {
+	return &jobWriter{jobs: j, id: id, stream: stream}
+}
+
+func (j *jobs) write(id, stream string, p []byte) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.entries[id]
+	if !ok {
+		return
+	}
+	e.writeLines(stream, p)
+}
+
+// writeLines splits output of the stream to lines, the time of line is the time its first part written.
+// Lines longer than maxLineSize split.
+func (e *jobEntry) writeLines(stream string, p []byte) {
+	if e.partial == nil {
+		e.partial = map[string]*Line{}
+	}
+	for len(p) > 0 {
+		pl, ok := e.partial[stream]
+		if !ok {
+			pl = &Line{Time: time.Now(), Stream: stream}
+			e.partial[stream] = pl
+		}
+		size := min(len(p), maxLineSize-len(pl.Text))
+		if i := bytes.IndexByte(p[:size], '\n'); i >= 0 {
+			pl.Text += string(p[:i])
+			e.addLine(stream)
+			p = p[i+1:]
+			continue
+		}
+		pl.Text += string(p[:size])
+		if len(pl.Text) >= maxLineSize {
+			e.addLine(stream)
+		}
+		p = p[size:]
+	}
+}
+
+// addLine moves incomplete line of the stream to lines, the oldest lines dropped above the size limit
+func (e *jobEntry) addLine(stream string) {
+	line := *e.partial[stream]
+	delete(e.partial, stream)
+	line.Text = strings.TrimSuffix(line.Text, "\r")
+	e.lines = append(e.lines, line)
+	e.size += len(line.Text) + 1
+	drop := 0
+	for e.size > maxJobLogSize && drop < len(e.lines)-1 {
+		e.size -= len(e.lines[drop].Text) + 1
+		e.dropped += len(e.lines[drop].Text) + 1
+		drop++
+	}
+	if drop > 0 {
+		e.lines = append([]Line(nil), e.lines[drop:]...)
+		e.linesDropped += drop
+	}
+}
+
+// flushLines adds incomplete lines of all streams, in order of their time
+func (e *jobEntry) flushLines() {
+	streams := make([]string, 0, len(e.partial))
+	for stream := range e.partial {
+		streams = append(streams, stream)
+	}
+	sort.Slice(streams, func(i, k int) bool { return e.partial[streams[i]].Time.Before(e.partial[streams[k]].Time) })
+	for _, stream := range streams {
+		e.addLine(stream)
+	}
+}
+
+// cleanup removes the oldest completed jobs above history limit, running and queued jobs are kept
+func (j *jobs) cleanup() {
+	completed := make([]*jobEntry, 0, len(j.entries))
+	for _, e := range j.entries {
+		if e.Status == JobSuccess || e.Status == JobFailed {
+			completed = append(completed, e)
+		}
+	}
+	if len(completed) <= maxJobsHistory {
+		return
+	}
+	sort.Slice(completed, func(i, k int) bool { return completed[i].StartedAt.Before(completed[k].StartedAt) })
+	for _, e := range completed[:len(completed)-maxJobsHistory] {
+		delete(j.entries, e.ID)
+	}
+}
+
+// jobWriter writes to job output of the stream
+type jobWriter struct {
+	jobs   *jobs
+	id     string
+	stream string
+}
+
+func (w *jobWriter) Write(p []byte) (int, error) {
+	w.jobs.write(w.id, w.stream, p)
+	return len(p), nil
+}
+
+// lockedWriter serializes writes of multiple streams to the same writer
+type lockedWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+func (l *lockedWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.w.Write(p)
+}
@@ -0,0 +1,176 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobs_Lifecycle(t *testing.T) {
+	j := newJobs()
+	job := j.start("task1", jobSource{})
+	assert.Equal(t, "task1", job.Task)
+	assert.Equal(t, JobRunning, job.Status)
+	assert.Len(t, job.ID, 16)
+
+	_, err := j.writer(job.ID, StreamStdout).Write([]byte("line1\n"))
+	require.NoError(t, err)
+	_, err = j.writer(job.ID, StreamStdout).Write([]byte("line2\n"))
+	require.NoError(t, err)
+
+	data, next, ok := j.logs(job.ID, 0)
+	require.True(t, ok)
+	assert.Equal(t, "line1\nline2\n", string(data))
+	assert.Equal(t, 12, next)
+	data, next, ok = j.logs(job.ID, 6)
+	require.True(t, ok)
+	assert.Equal(t, "line2\n", string(data))
+	assert.Equal(t, 12, next)
+
+	j.finish(job.ID, errors.New("failed"))
+	res, ok := j.get(job.ID)
+	require.True(t, ok)
+	assert.Equal(t, JobFailed, res.Status)
+	assert.Equal(t, "failed", res.Error)
+	assert.Equal(t, 1, res.ExitCode)
+	assert.False(t, res.FinishedAt.IsZero())
+
+	job2 := j.start("task1", jobSource{})
+	j.finish(job2.ID, nil)
+	last, ok := j.last("task1")
+	require.True(t, ok)
+	assert.Equal(t, job2.ID, last.ID)
+	assert.Equal(t, JobSuccess, last.Status)
+	_, ok = j.last("task2")
+	assert.False(t, ok)
+
+	_, ok = j.get("bad")
+	assert.False(t, ok)
+	_, _, ok = j.logs("bad", 0)
+	assert.False(t, ok)
+}
+
+func TestJobs_LogLimit(t *testing.T) {
+	j := newJobs()
+	job := j.start("task1", jobSource{})
+	w := j.writer(job.ID, StreamStdout)
+	line := strings.Repeat("a", 1023) + "\n"
+	for i := 0; i < maxJobLogSize/len(line); i++ {
+		_, _ = w.Write([]byte(line))
+	}
+	_, _ = w.Write([]byte("bbb\n"))
+
+	data, next, ok := j.logs(job.ID, 0)
+	require.True(t, ok)
+	assert.Len(t, data, maxJobLogSize-len(line)+4, "the oldest line dropped")
+	assert.Equal(t, maxJobLogSize+4, next)
+	assert.True(t, strings.HasSuffix(string(data), "a\nbbb\n"))
+
+	data, _, ok = j.logs(job.ID, maxJobLogSize+1)
+	require.True(t, ok)
+	assert.Equal(t, "bb\n", string(data))
+	data, _, ok = j.logs(job.ID, maxJobLogSize)
+	require.True(t, ok)
+	assert.Equal(t, "bbb\n", string(data))
+	data, _, ok = j.logs(job.ID, next)
+	require.True(t, ok)
+	assert.Empty(t, data)
+
+	lines, _, ok := j.lines(job.ID, 0)
+	require.True(t, ok)
+	assert.Len(t, lines, maxJobLogSize/len(line), "lines and output share the same limit")
+}
+
+func TestJobs_Lines(t *testing.T) {
+	j := newJobs()
+	job := j.start("task1", jobSource{})
+	out, errOut := j.writer(job.ID, StreamStdout), j.writer(job.ID, StreamStderr)
+	_, _ = out.Write([]byte("step 1\r\nstep "))
+	_, _ = errOut.Write([]byte("warn"))
+	_, _ = out.Write([]byte("2\n"))
+	_, _ = errOut.Write([]byte("ing\nerror\n"))
+	_, _ = out.Write([]byte("no newline"))
+
+	lines, next, ok := j.lines(job.ID, 0)
+	require.True(t, ok)
+	require.Len(t, lines, 4, "incomplete line is not returned")
+	assert.Equal(t, 4, next)
+	for i, exp := range []Line{{Stream: StreamStdout, Text: "step 1"}, {Stream: StreamStdout, Text: "step 2"},
+		{Stream: StreamStderr, Text: "warning"}, {Stream: StreamStderr, Text: "error"}} {
+		assert.Equal(t, exp.Stream, lines[i].Stream)
+		assert.Equal(t, exp.Text, lines[i].Text)
+		assert.False(t, lines[i].Time.IsZero())
+	}
+	assert.False(t, lines[1].Time.After(lines[2].Time), "time of the line is the time of its first part")
+
+	j.finish(job.ID, nil)
+	lines, next, ok = j.lines(job.ID, 3)
+	require.True(t, ok)
+	assert.Equal(t, 5, next)
+	require.Len(t, lines, 2)
+	assert.Equal(t, "error", lines[0].Text)
+	assert.Equal(t, Line{Time: lines[1].Time, Stream: StreamStdout, Text: "no newline"}, lines[1], "flushed on finish")
+
+	data, _, ok := j.logs(job.ID, 0)
+	require.True(t, ok)
+	assert.Equal(t, "step 1\nstep 2\nwarning\nerror\nno newline\n", string(data), "combined output made of lines")
+
+	_, _, ok = j.lines("bad", 0)
+	assert.False(t, ok)
+}
+
+func TestJobs_LinesLimit(t *testing.T) {
+	j := newJobs()
+	job := j.start("task1", jobSource{})
+	w := j.writer(job.ID, StreamStdout)
+	_, _ = w.Write([]byte(strings.Repeat("a", maxLineSize+10) + "\n"))
+	lines, _, ok := j.lines(job.ID, 0)
+	require.True(t, ok)
+	require.Len(t, lines, 2, "long line split")
+	assert.Len(t, lines[0].Text, maxLineSize)
+	assert.Len(t, lines[1].Text, 10)
+
+	line := strings.Repeat("b", 1023) + "\n"
+	for i := 0; i < maxJobLogSize/1023+10; i++ {
+		_, _ = w.Write([]byte(line))
+	}
+	lines, next, ok := j.lines(job.ID, 0)
+	require.True(t, ok)
+	assert.Equal(t, maxJobLogSize/1023+12, next)
+	assert.Len(t, lines, maxJobLogSize/len(line), "size of lines counted with newlines")
+	lines, _, ok = j.lines(job.ID, next-1)
+	require.True(t, ok)
+	assert.Len(t, lines, 1)
+}
+
+func TestJobs_History(t *testing.T) {
+	j := newJobs()
+	running := j.start("running", jobSource{})
+	for i := 0; i < maxJobsHistory+10; i++ {
+		job := j.start(fmt.Sprintf("task%d", i), jobSource{})
+		j.finish(job.ID, nil)
+		time.Sleep(time.Microsecond) // keep start time ordered
+	}
+	j.start("last", jobSource{})
+
+	list := j.list()
+	assert.Len(t, list, maxJobsHistory+2, "history limit plus running jobs")
+	assert.Equal(t, "last", list[0].Task)
+	_, ok := j.get(running.ID)
+	assert.True(t, ok, "running job kept")
+	_, ok = j.last("task0")
+	assert.False(t, ok, "the oldest job removed")
+}
+
+func TestJobs_exitCode(t *testing.T) {
+	err := exec.Command("sh", "-c", "exit 3").Run()
+	require.Error(t, err)
+	assert.Equal(t, 3, exitCode(fmt.Errorf("failed to execute: %w", err)))
+	assert.Equal(t, 1, exitCode(errors.New("some error")))
+}
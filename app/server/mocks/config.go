@@ -5,36 +5,92 @@ package mocks
 
 import (
 	"sync"
+
+	"github.com/umputun/updater/app/task"
 )
 
 // ConfigMock is a mock implementation of server.Config.
 //
-// 	func TestSomethingThatUsesConfig(t *testing.T) {
+//	func TestSomethingThatUsesConfig(t *testing.T) {
 //
-// 		// make and configure a mocked server.Config
-// 		mockedConfig := &ConfigMock{
-// 			GetTaskCommandFunc: func(name string) (string, bool) {
-// 				panic("mock out the GetTaskCommand method")
-// 			},
-// 		}
+//		// make and configure a mocked server.Config
+//		mockedConfig := &ConfigMock{
+//			GetTaskFunc: func(name string) (task.Task, bool) {
+//				panic("mock out the GetTask method")
+//			},
+//			GetTaskCommandFunc: func(name string) (string, bool) {
+//				panic("mock out the GetTaskCommand method")
+//			},
+//			GetTasksFunc: func() []task.Task {
+//				panic("mock out the GetTasks method")
+//			},
+//		}
 //
-// 		// use mockedConfig in code that requires server.Config
-// 		// and then make assertions.
+//		// use mockedConfig in code that requires server.Config
+//		// and then make assertions.
 //
-// 	}
+//	}
 type ConfigMock struct {
+	// GetTaskFunc mocks the GetTask method.
+	GetTaskFunc func(name string) (task.Task, bool)
+
 	// GetTaskCommandFunc mocks the GetTaskCommand method.
 	GetTaskCommandFunc func(name string) (string, bool)
 
+	// GetTasksFunc mocks the GetTasks method.
+	GetTasksFunc func() []task.Task
+
 	// calls tracks calls to the methods.
 	calls struct {
+		// GetTask holds details about calls to the GetTask method.
+		GetTask []struct {
+			// Name is the name argument value.
+			Name string
+		}
 		// GetTaskCommand holds details about calls to the GetTaskCommand method.
 		GetTaskCommand []struct {
 			// Name is the name argument value.
 			Name string
 		}
+		// GetTasks holds details about calls to the GetTasks method.
+		GetTasks []struct {
+		}
 	}
+	lockGetTask        sync.RWMutex
 	lockGetTaskCommand sync.RWMutex
+	lockGetTasks       sync.RWMutex
+}
+
+// GetTask calls GetTaskFunc.
+func (mock *ConfigMock) GetTask(name string) (task.Task, bool) {
+	if mock.GetTaskFunc == nil {
+		panic("ConfigMock.GetTaskFunc: method is nil but Config.GetTask was just called")
+	}
+	callInfo := struct {
+		Name string
+	}{
+		Name: name,
+	}
+	mock.lockGetTask.Lock()
+	mock.calls.GetTask = append(mock.calls.GetTask, callInfo)
+	mock.lockGetTask.Unlock()
+	return mock.GetTaskFunc(name)
+}
+
+// GetTaskCalls gets all the calls that were made to GetTask.
+// Check the length with:
+//
+//	len(mockedConfig.GetTaskCalls())
+func (mock *ConfigMock) GetTaskCalls() []struct {
+	Name string
+} {
+	var calls []struct {
+		Name string
+	}
+	mock.lockGetTask.RLock()
+	calls = mock.calls.GetTask
+	mock.lockGetTask.RUnlock()
+	return calls
 }
 
 // GetTaskCommand calls GetTaskCommandFunc.
@@ -55,7 +111,8 @@ func (mock *ConfigMock) GetTaskCommand(name string) (string, bool) {
 
 // GetTaskCommandCalls gets all the calls that were made to GetTaskCommand.
 // Check the length with:
-//     len(mockedConfig.GetTaskCommandCalls())
+//
+//	len(mockedConfig.GetTaskCommandCalls())
 func (mock *ConfigMock) GetTaskCommandCalls() []struct {
 	Name string
 } {
@@ -67,3 +124,30 @@ func (mock *ConfigMock) GetTaskCommandCalls() []struct {
 	mock.lockGetTaskCommand.RUnlock()
 	return calls
 }
+
+// GetTasks calls GetTasksFunc.
+func (mock *ConfigMock) GetTasks() []task.Task {
+	if mock.GetTasksFunc == nil {
+		panic("ConfigMock.GetTasksFunc: method is nil but Config.GetTasks was just called")
+	}
+	callInfo := struct {
+	}{}
+	mock.lockGetTasks.Lock()
+	mock.calls.GetTasks = append(mock.calls.GetTasks, callInfo)
+	mock.lockGetTasks.Unlock()
+	return mock.GetTasksFunc()
+}
+
+// GetTasksCalls gets all the calls that were made to GetTasks.
+// Check the length with:
+//
+//	len(mockedConfig.GetTasksCalls())
+func (mock *ConfigMock) GetTasksCalls() []struct {
+} {
+	var calls []struct {
+	}
+	mock.lockGetTasks.RLock()
+	calls = mock.calls.GetTasks
+	mock.lockGetTasks.RUnlock()
+	return calls
+}
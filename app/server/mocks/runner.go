@@ -11,22 +11,22 @@ import (
 
 // RunnerMock is a mock implementation of server.Runner.
 //
-// 	func TestSomethingThatUsesRunner(t *testing.T) {
+//	func TestSomethingThatUsesRunner(t *testing.T) {
 //
-// 		// make and configure a mocked server.Runner
-// 		mockedRunner := &RunnerMock{
-// 			RunFunc: func(ctx context.Context, command string, logWriter io.Writer) error {
-// 				panic("mock out the Run method")
-// 			},
-// 		}
+//		// make and configure a mocked server.Runner
+//		mockedRunner := &RunnerMock{
+//			RunFunc: func(ctx context.Context, command string, stdout io.Writer, stderr io.Writer) error {
+//				panic("mock out the Run method")
+//			},
+//		}
 //
-// 		// use mockedRunner in code that requires server.Runner
-// 		// and then make assertions.
+//		// use mockedRunner in code that requires server.Runner
+//		// and then make assertions.
 //
-// 	}
+//	}
 type RunnerMock struct {
 	// RunFunc mocks the Run method.
-	RunFunc func(ctx context.Context, command string, logWriter io.Writer) error
+	RunFunc func(ctx context.Context, command string, stdout io.Writer, stderr io.Writer) error
 
 	// calls tracks calls to the methods.
 	calls struct {
@@ -36,45 +36,52 @@ type RunnerMock struct {
 			Ctx context.Context
 			// Command is the command argument value.
 			Command string
-			// LogWriter is the logWriter argument value.
-			LogWriter io.Writer
+			// Stdout is the stdout argument value.
+			Stdout io.Writer
+			// Stderr is the stderr argument value.
+			Stderr io.Writer
 		}
 	}
 	lockRun sync.RWMutex
 }
 
 // Run calls RunFunc.
-func (mock *RunnerMock) Run(ctx context.Context, command string, logWriter io.Writer) error {
+func (mock *RunnerMock) Run(ctx context.Context, command string, stdout io.Writer, stderr io.Writer) error {
 	if mock.RunFunc == nil {
 		panic("RunnerMock.RunFunc: method is nil but Runner.Run was just called")
 	}
 	callInfo := struct {
-		Ctx       context.Context
-		Command   string
-		LogWriter io.Writer
+		Ctx     context.Context
+		Command string
+		Stdout  io.Writer
+		Stderr  io.Writer
 	}{
-		Ctx:       ctx,
-		Command:   command,
-		LogWriter: logWriter,
+		Ctx:     ctx,
+		Command: command,
+		Stdout:  stdout,
+		Stderr:  stderr,
 	}
 	mock.lockRun.Lock()
 	mock.calls.Run = append(mock.calls.Run, callInfo)
 	mock.lockRun.Unlock()
-	return mock.RunFunc(ctx, command, logWriter)
+	return mock.RunFunc(ctx, command, stdout, stderr)
 }
 
 // RunCalls gets all the calls that were made to Run.
 // Check the length with:
-//     len(mockedRunner.RunCalls())
+//
+//	len(mockedRunner.RunCalls())
 func (mock *RunnerMock) RunCalls() []struct {
-	Ctx       context.Context
-	Command   string
-	LogWriter io.Writer
+	Ctx     context.Context
+	Command string
+	Stdout  io.Writer
+	Stderr  io.Writer
 } {
 	var calls []struct {
-		Ctx       context.Context
-		Command   string
-		LogWriter io.Writer
+		Ctx     context.Context
+		Command string
+		Stdout  io.Writer
+		Stderr  io.Writer
 	}
 	mock.lockRun.RLock()
 	calls = mock.calls.Run
@@ -2,16 +2,36 @@ package server
 
 import (
 	"context"
-	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/didip/tollbooth/v8"
 	log "github.com/go-pkgz/lgr"
 	"github.com/go-pkgz/rest"
 	"github.com/go-pkgz/routegroup"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/umputun/updater/app/audit"
+	"github.com/umputun/updater/app/events"
+	"github.com/umputun/updater/app/jsonlog"
+	"github.com/umputun/updater/app/keyhash"
+	"github.com/umputun/updater/app/mask"
+	"github.com/umputun/updater/app/oidc"
+	"github.com/umputun/updater/app/systemd"
+	"github.com/umputun/updater/app/task"
 )
 
 //go:generate moq -out mocks/config.go -pkg mocks -skip-ensure -fmt goimports . Config
@@ -19,28 +39,110 @@ import (
 
 // Rest implement http api invoking remote execution for requested tasks
 type Rest struct {
-	Listen      string
-	Version     string
-	SecretKey   string
-	Config      Config
-	Runner      Runner
-	UpdateDelay time.Duration
-	Timeout     time.Duration
+	Listen     string       // host:port or unix:///path/to.sock
+	Listener   net.Listener // optional listener, i.e. from systemd socket activation, Listen ignored if set
+	SocketMode os.FileMode  // permissions of unix socket, 0660 if not set
+	TLS        TLSOpts      // optional tls, plain http if not enabled
+
+	ShutdownGrace    time.Duration // how long running jobs are waited for on shutdown before cancellation
+	ShutdownDeadline time.Duration // hard deadline of shutdown, grace time + 10s if less than grace time
+	Version          string
+	SecretKey        string // plain key or bcrypt/argon2id hash of the key
+	Config           Config
+	Runner           Runner
+	UpdateDelay      time.Duration
+	Timeout          time.Duration
+	Limit            int // max concurrent updates shared by all tasks, reported by tasks api
+
+	// RunnerFor returns a dedicated runner for the task, i.e. for compose tasks. Optional, if not set
+	// or returns false the default Runner is used
+	RunnerFor func(taskName string) (Runner, bool)
+
+	AgentHub http.Handler // optional hub handling /agent/ requests from remote agents
+	AdminKey string       // admin key for web dashboard and admin api, both disabled if empty
+
+	StateFile   string // file keeping disabled tasks and deploy freeze, in memory only if empty
+	FreezeQueue bool   // queue triggers during deploy freeze instead of rejecting them
+
+	Approvers      map[string]string // approver name -> key, allowed to approve tasks requiring approval
+	ApprovalNotify string            // optional url receiving pending approvals with approve/reject links
+	BaseURL        string            // external url of updater used in approval links, required for approvals
+
+	RequireSignature bool          // reject requests with plain secret key, signed requests only
+	MaxSkew          time.Duration // max difference between signature timestamp and server time, default 5m
+	NonceFile        string        // file keeping nonces of signed requests, in memory only if empty
+
+	TokenVerifier TokenVerifier // optional verifier of JWT bearer tokens, tokens allowed for tasks with matching oidc claims
+
+	TrustedProxies  []netip.Prefix // proxies allowed to pass client ip with X-Forwarded-For and X-Real-IP headers
+	AllowCIDRs      []netip.Prefix // networks allowed to trigger tasks, any if empty
+	DenyCIDRs       []netip.Prefix // networks not allowed to trigger tasks
+	MaxAuthFailures int            // failed authentications from ip before ban, default 10
+	BanDuration     time.Duration  // how long ip banned for, default 15m
+
+	Audit   AuditLog     // optional audit log of requests
+	Masker  *mask.Masker // optional masker of secrets in the output, values of secret parameters masked anyway
+	Secrets task.Secrets // values of secrets passed to tasks declaring them, masked in the output
+
+	Events EventPublisher // optional publisher of job lifecycle events
+
+	JSONLog *jsonlog.Writer // optional json log, output of jobs logged with job id, task and trigger
+	JobLogs JobLogsOpts     // optional per-job log files, kept after jobs dropped from memory
+
+	jobsOnce  sync.Once
+	jobs      *jobs
+	stateOnce sync.Once
+	state     *state
+	approvals approvals
+	nonceOnce sync.Once
+	nonces    *nonceCache
+	guardOnce sync.Once
+	guard     *authGuard
+
+	lifecycleOnce sync.Once
+	lifecycle     *lifecycle
 }
 
 // Config declares command loader from config for given tasks
 type Config interface {
 	GetTaskCommand(name string) (command string, ok bool)
+	GetTask(name string) (task.Task, bool)
+	GetTasks() []task.Task
+}
+
+// TokenVerifier verifies bearer token and returns its claims
+type TokenVerifier interface {
+	Verify(token string) (oidc.Claims, error)
+}
+
+// AuditLog keeps audit records of requests
+type AuditLog interface {
+	Record(rec audit.Record) error
 }
 
 // Runner executes commands
 type Runner interface {
-	Run(ctx context.Context, command string, logWriter io.Writer) error
+	Run(ctx context.Context, command string, stdout, stderr io.Writer) error
 }
 
 // Run starts http server and closes on context cancellation
 func (s *Rest) Run(ctx context.Context) error {
-	log.Printf("[INFO] start http server on %s", s.Listen)
+	var tlsConfig *tls.Config
+	if s.TLS.Enabled() {
+		var err error
+		if tlsConfig, err = s.TLS.config(); err != nil {
+			return fmt.Errorf("can't make tls config: %w", err)
+		}
+	}
+	ln, err := s.listen()
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] start http server on %s, tls: %v", ln.Addr(), tlsConfig != nil)
+	if s.JobLogs.Enabled() {
+		log.Printf("[INFO] job logs in %s, max age: %v, max files: %d", s.JobLogs.Dir, s.JobLogs.MaxAge, s.JobLogs.MaxFiles)
+		s.cleanupJobLogs()
+	}
 
 	httpServer := &http.Server{
 		Addr:              s.Listen,
@@ -49,28 +151,107 @@ func (s *Rest) Run(ctx context.Context) error {
 		WriteTimeout:      s.Timeout + 10*time.Second, // Give enough time to finish the task and respond
 		IdleTimeout:       time.Second,
 		ErrorLog:          log.ToStdLogger(log.Default(), "WARN"),
+		TLSConfig:         tlsConfig,
 	}
 
+	shutdownDone := make(chan struct{})
 	go func() {
+		defer close(shutdownDone)
 		<-ctx.Done()
-		if httpServer != nil {
-			if err := httpServer.Close(); err != nil {
-				log.Printf("[ERROR] failed to close http server, %v", err)
-			}
+		state := systemd.Stopping
+		if deadline := s.shutdownDeadline(); deadline > 0 {
+			state += "\n" + systemd.ExtendTimeout(deadline)
 		}
-
+		if _, err := systemd.Notify(state); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+		s.shutdown(httpServer)
 	}()
 
-	return httpServer.ListenAndServe()
+	if ok, err := systemd.Notify(systemd.Ready); err != nil {
+		log.Printf("[WARN] %v", err)
+	} else if ok {
+		log.Printf("[INFO] systemd notified")
+	}
+	// watchdog keeps running till the shutdown completed, as draining jobs can take longer than watchdog timeout
+	watchdogCtx, stopWatchdog := context.WithCancel(context.Background())
+	defer stopWatchdog()
+	go systemd.RunWatchdog(watchdogCtx)
+
+	if tlsConfig != nil {
+		err = httpServer.ServeTLS(ln, "", "") // certificates set in tls config
+	} else {
+		err = httpServer.Serve(ln)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		<-shutdownDone // serve returns as soon as shutdown started, wait for its completion
+	}
+	return err
+}
+
+// listen makes listener for tcp address or unix socket, unless listener is set already
+func (s *Rest) listen() (net.Listener, error) {
+	if s.Listener != nil {
+		return s.Listener, nil
+	}
+	path, ok := strings.CutPrefix(s.Listen, "unix://")
+	if !ok {
+		ln, err := net.Listen("tcp", s.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("can't listen on %s: %w", s.Listen, err)
+		}
+		return ln, nil
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		_ = os.Remove(path) // stale socket of the previous run
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("can't listen on %s: %w", path, err)
+	}
+	mode := s.SocketMode
+	if mode == 0 {
+		mode = 0o660
+	}
+	if err = os.Chmod(path, mode); err != nil {
+		_ = ln.Close()
+		return nil, fmt.Errorf("can't set permissions of %s: %w", path, err)
+	}
+	return ln, nil
 }
 
 func (s *Rest) router() http.Handler {
 	router := routegroup.New(http.NewServeMux())
 	router.Use(rest.Recoverer(log.Default()))
+	router.Use(s.realIP)           // all middlewares and handlers below see the real client ip
+	router.Use(s.traceRequests)    // spans of requests, including health checks
 	router.Use(rest.Throttle(100)) // limit the total number of the running requests
 	router.Use(rest.AppInfo("updater", "umputun", s.Version))
 	router.Use(rest.Ping)
+	router.Use(s.auditRequests)
+	router.Use(s.ipFilter) // before any route, routes take middlewares set at the time of registration
 	router.Use(tollbooth.HTTPMiddleware(tollbooth.NewLimiter(10, nil)))
+	router.Use(s.banGuard)
+	if s.AgentHub != nil {
+		// agents poll and stream logs, so hub routes are not delayed
+		router.Handle("/agent/", s.agentFailures(s.AgentHub))
+	}
+	if s.AdminKey != "" {
+		s.webRoutes(router.Mount("/web"))
+		s.adminRoutes(router.Mount("/admin"))
+	}
+	// job status and logs are polled by clients, not delayed
+	api := router.With(s.keyAuth)
+	api.HandleFunc("GET /jobs/{id}", s.jobCtrl)
+	api.HandleFunc("GET /jobs/{id}/logs", s.jobLogsCtrl)
+	api.HandleFunc("GET /jobs/{id}/output", s.jobOutputCtrl)
+	api.HandleFunc("GET /jobs/{id}/lines", s.jobLinesCtrl)
+	api.HandleFunc("GET /tasks", s.tasksCtrl)
+	api.HandleFunc("GET /tasks/{name}", s.taskInfoCtrl)
+
+	router.HandleFunc("GET /approvals/{id}/{action}", s.approvalPageCtrl)
+	router.HandleFunc("POST /approvals/{id}/{action}", s.approvalCtrl)
 	if s.UpdateDelay > 0 {
 		router.Use(s.slowMiddleware)
 	}
@@ -85,61 +266,576 @@ func (s *Rest) taskCtrl(w http.ResponseWriter, r *http.Request) {
 	taskName := r.PathValue("task")
 	key := r.PathValue("key")
 	isAsync := r.URL.Query().Get("async") == "1" || r.URL.Query().Get("async") == "yes"
-	s.execTask(w, r, key, taskName, isAsync)
+	if s.RequireSignature {
+		http.Error(w, "signature required", http.StatusForbidden)
+		return
+	}
+	_, span := tracer.Start(r.Context(), "auth")
+	name, ok := s.validKey(taskName, key)
+	if !ok {
+		s.authFailed(r, "invalid key")
+		traceAuth(span, r, false)
+		http.Error(w, "rejected", http.StatusForbidden)
+		return
+	}
+	auditRecord(r).Identity = name
+	traceAuth(span, r, true)
+	s.execTask(w, r, taskName, isAsync, nil)
+}
+
+// taskRequest is the body of POST /update
+type taskRequest struct {
+	Task   string            `json:"task"`
+	Secret string            `json:"secret"`
+	Async  bool              `json:"async"`
+	Params map[string]string `json:"params"`
 }
 
-// POST /update
+// POST /update, authorized with secret in the body, with signature headers, with JWT bearer token
+// or with client certificate
 func (s *Rest) taskPostCtrl(w http.ResponseWriter, r *http.Request) {
-	req := struct {
-		Task   string `json:"task"`
-		Secret string `json:"secret"`
-		Async  bool   `json:"async"`
-	}{}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBody))
+	if err != nil {
+		http.Error(w, "failed to read request", http.StatusBadRequest)
+		return
+	}
+	_, span := tracer.Start(r.Context(), "auth")
+	req, ok := s.authTaskRequest(w, r, body)
+	traceAuth(span, r, ok)
+	if !ok {
+		return
+	}
+	s.execTask(w, r, req.Task, req.Async, req.Params)
+}
 
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+// authTaskRequest decodes and authorizes the body of POST /update. Error response written if not authorized.
+func (s *Rest) authTaskRequest(w http.ResponseWriter, r *http.Request, body []byte) (taskRequest, bool) {
+	var req taskRequest
+	var err error
+	signed := hasSignature(r)
+	if signed {
+		if err = s.verifySignature(r, body); err != nil {
+			http.Error(w, "rejected, "+err.Error(), s.signatureFailed(r, err, http.StatusForbidden))
+			return req, false
+		}
+	}
+	var claims oidc.Claims
+	if token, ok := jwtToken(r); ok && !signed && s.TokenVerifier != nil {
+		if claims, err = s.TokenVerifier.Verify(token); err != nil {
+			s.authFailed(r, err.Error())
+			http.Error(w, "rejected, "+err.Error(), http.StatusUnauthorized)
+			return req, false
+		}
+	}
+	cert, hasCert := clientCert(r)
+	certAuth := hasCert && !signed && claims == nil
+	authorized := signed || claims != nil || certAuth
+	if !authorized && s.RequireSignature {
+		http.Error(w, "signature required", http.StatusForbidden)
+		return req, false
+	}
+
+	if err = json.Unmarshal(body, &req); err != nil {
 		http.Error(w, "failed to decode request", http.StatusBadRequest)
-		return
+		return req, false
 	}
-	if req.Task == "" || req.Secret == "" {
+	if req.Task == "" || (!authorized && req.Secret == "") {
 		http.Error(w, "task and secret required", http.StatusBadRequest)
-		return
+		return req, false
+	}
+	rec := auditRecord(r)
+	rec.Task = req.Task
+	if signed {
+		rec.Identity = "signature"
+	}
+	if !authorized {
+		name, ok := s.validKey(req.Task, req.Secret)
+		if !ok {
+			s.authFailed(r, "invalid secret")
+			http.Error(w, "rejected", http.StatusForbidden)
+			return req, false
+		}
+		rec.Identity = name
+	}
+	if claims != nil {
+		rec.Identity = "token:" + claims.String("sub")
+		if !s.tokenAllowed(claims, req.Task) {
+			log.Printf("[WARN] token of %s not allowed for task %s", claims.String("sub"), req.Task)
+			http.Error(w, "task not allowed for token", http.StatusForbidden)
+			return req, false
+		}
+		log.Printf("[INFO] task %s triggered by token of %s", req.Task, claims.String("sub"))
 	}
-	s.execTask(w, r, req.Secret, req.Task, req.Async)
+	if certAuth {
+		rec.Identity = "cert:" + cert.Subject.CommonName
+		if t, ok := s.Config.GetTask(req.Task); !ok || !t.AllowsCert(certNames(cert)) {
+			log.Printf("[WARN] certificate %s not allowed for task %s", cert.Subject.CommonName, req.Task)
+			http.Error(w, "task not allowed for certificate", http.StatusForbidden)
+			return req, false
+		}
+		log.Printf("[INFO] task %s triggered by certificate %s", req.Task, cert.Subject.CommonName)
+	}
+	return req, true
 }
 
-func (s *Rest) execTask(w http.ResponseWriter, r *http.Request, secret, taskName string, isAsync bool) {
-	if subtle.ConstantTimeCompare([]byte(secret), []byte(s.SecretKey)) != 1 {
-		http.Error(w, "rejected", http.StatusForbidden)
+func (s *Rest) execTask(w http.ResponseWriter, r *http.Request, taskName string, isAsync bool, params map[string]string) {
+	job, state, ok := s.submitTask(w, r, taskName, auditRecord(r).Identity, isAsync, params)
+	if !ok {
 		return
 	}
+	res := rest.JSON{state: "ok", "task": taskName, "job": job.ID}
+	if state == "queued" {
+		res["run_at"] = job.RunAt
+	}
+	rest.RenderJSON(w, res)
+}
 
-	command, ok := s.Config.GetTaskCommand(taskName)
+// submitTask admits the trigger of the task and returns its job with the state: "pending" if waits for approval,
+// "queued" if blocked by deploy freeze or maintenance window, "submitted" if started in background, or "updated"
+// if completed. Task runs synchronously unless isAsync set. Error response written if the trigger is rejected
+// or the task failed.
+func (s *Rest) submitTask(w http.ResponseWriter, r *http.Request, taskName, trigger string, isAsync bool,
+	params map[string]string) (job Job, state string, ok bool) {
+	if s.draining() {
+		http.Error(w, errShutdown.Error(), http.StatusServiceUnavailable)
+		return Job{}, "", false
+	}
+	runner, command, ok := s.taskRunner(taskName)
 	if !ok {
 		http.Error(w, "unknown command", http.StatusBadRequest)
-		return
+		return Job{}, "", false
+	}
+	rec := auditRecord(r)
+	rec.Task = taskName
+	ip := s.clientIP(r)
+	src := jobSource{ClientIP: ip, Trigger: trigger, Trace: trace.SpanContextFromContext(r.Context())}
+	if t, found := s.Config.GetTask(taskName); found && !t.AllowsIP(parseAddr(ip)) {
+		log.Printf("[WARN] task %s not allowed from %s", taskName, ip)
+		http.Error(w, "not allowed", http.StatusForbidden)
+		return Job{}, "", false
+	}
+	params, err := s.taskParams(taskName, params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return Job{}, "", false
+	}
+	if t, found := s.Config.GetTask(taskName); found {
+		rec.Params = t.MaskParams(params)
+	} else {
+		rec.Params = params
+	}
+	admErr := s.admission(taskName)
+	if admErr != nil && !s.canQueue(taskName, admErr) {
+		log.Printf("[WARN] task %s rejected, %v", taskName, admErr)
+		http.Error(w, admErr.Error(), http.StatusLocked)
+		return Job{}, "", false
+	}
+	if t, found := s.Config.GetTask(taskName); found && t.ApprovalRequired() {
+		log.Printf("[INFO] task %s from %s waits for approval", taskName, ip)
+		job = s.requestApproval(t, src, runner, command, params)
+		rec.Job, rec.Reason = job.ID, "waits for approval"
+		return job, "pending", true
+	}
+	if admErr != nil {
+		log.Printf("[INFO] task %s from %s queued, %v", taskName, ip, admErr)
+		job = s.queueJob(taskName, src, runner, command, params, admErr)
+		rec.Job, rec.Reason = job.ID, "queued, "+admErr.Error()
+		return job, "queued", true
 	}
 
-	log.Printf("[INFO] invoke task %s", taskName)
+	log.Printf("[INFO] invoke task %s from %s, %s", taskName, trigger, ip)
 
 	if isAsync {
-		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
-			defer cancel()
-			if err := s.Runner.Run(ctx, command, log.ToWriter(log.Default(), ">")); err != nil {
-				log.Printf("[WARN] failed command")
-				return
-			}
-		}()
-		rest.RenderJSON(w, rest.JSON{"submitted": "ok", "task": taskName})
-		return
+		job = s.startJob(taskName, src, runner, command, params)
+		rec.Job = job.ID
+		return job, "submitted", true
 	}
 
-	if err := s.Runner.Run(r.Context(), command, log.ToWriter(log.Default(), ">")); err != nil {
+	job = s.getJobs().start(taskName, src)
+	rec.Job = job.ID
+	if err := s.runJob(task.WithParams(r.Context(), params), job, runner, command, nil, nil); err != nil {
 		http.Error(w, "failed command", http.StatusInternalServerError)
+		return job, "", false
+	}
+	return job, "updated", true
+}
+
+// startJob runs the task in background, with the timeout
+func (s *Rest) startJob(taskName string, src jobSource, runner Runner, command string, params map[string]string) Job {
+	job := s.getJobs().start(taskName, src)
+	go s.runBackground(src.Trace, job, runner, command, params)
+	return job
+}
+
+// queueJob keeps the job queued until the deploy freeze is over and the maintenance window is open,
+// and runs it in background after that. The job fails if the task was disabled while queued.
+func (s *Rest) queueJob(taskName string, src jobSource, runner Runner, command string, params map[string]string,
+	reason error) Job {
+	var runAt *time.Time
+	if we := (*windowError)(nil); errors.As(reason, &we) {
+		runAt = &we.next
+	}
+	job := s.getJobs().queue(taskName, runAt, src)
+	s.publishJob(trace.ContextWithSpanContext(context.Background(), src.Trace), events.JobQueued, job.ID, nil)
+	go func() {
+		ctx := trace.ContextWithSpanContext(s.queueContext(), src.Trace)
+		if err := s.awaitAdmission(ctx, taskName); err != nil {
+			log.Printf("[WARN] queued job %s dropped, %v", job.ID, err)
+			s.finishJob(ctx, job.ID, err)
+			return
+		}
+		log.Printf("[INFO] invoke queued task %s", taskName)
+		s.getJobs().run(job.ID)
+		s.runBackground(src.Trace, job, runner, command, params)
+	}()
+	return job
+}
+
+// awaitAdmission waits until the task can run, as long as the reason of rejection allows queueing
+func (s *Rest) awaitAdmission(ctx context.Context, taskName string) (err error) {
+	_, span := tracer.Start(ctx, "queue wait", trace.WithAttributes(attribute.String("task", taskName)))
+	defer func() { endSpan(span, err) }()
+	for {
+		err := s.admission(taskName)
+		if err == nil || !s.canQueue(taskName, err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		case <-time.After(queueCheckInterval):
+		}
+	}
+}
+
+// runBackground runs the job with the timeout, the job span made a child of the parent span, i.e. of the request
+func (s *Rest) runBackground(parent trace.SpanContext, job Job, runner Runner, command string, params map[string]string) {
+	ctx := trace.ContextWithSpanContext(task.WithParams(context.Background(), params), parent)
+	ctx, cancel := context.WithTimeout(ctx, s.Timeout)
+	defer cancel()
+	if err := s.runJob(ctx, job, runner, command, nil, nil); err != nil {
+		log.Printf("[WARN] failed command")
+	}
+}
+
+// runJob executes the task command and keeps its output and result in the job.
+// Optional stdoutWriter and stderrWriter get the output streams as well, secrets are masked in all outputs.
+// The job is canceled on shutdown after the grace time.
+func (s *Rest) runJob(ctx context.Context, job Job, runner Runner, command string, stdoutWriter, stderrWriter io.Writer) error {
+	ctx, span := tracer.Start(ctx, "job", trace.WithAttributes(attribute.String("job.id", job.ID),
+		attribute.String("task", job.Task), attribute.String("trigger", job.Trigger)))
+	s.publishJob(ctx, events.JobStarted, job.ID, nil)
+	if s.Events != nil {
+		jobCtx := ctx
+		ctx = task.WithSteps(ctx, func(step task.Step) { s.publishJob(jobCtx, events.JobStep, job.ID, &step) })
+	}
+	jobLog := s.openJobLog(job)
+	var mu sync.Mutex // runners write stdout and stderr concurrently, destinations below are shared by streams
+	output := func(stream string, extra io.Writer) io.Writer {
+		writers := []io.Writer{s.getJobs().writer(job.ID, stream), s.consoleWriter(job, stream)}
+		if extra != nil {
+			writers = append(writers, extra)
+		}
+		if jobLog != nil {
+			writers = append(writers, jobLog)
+		}
+		return &lockedWriter{mu: &mu, w: io.MultiWriter(writers...)}
+	}
+	var secrets []string
+	if t, ok := s.Config.GetTask(job.Task); ok {
+		secrets = t.SecretValues(task.ParamsFromContext(ctx))
+		taskSecrets := s.Secrets.For(t)
+		for _, v := range taskSecrets {
+			secrets = append(secrets, v)
+		}
+		ctx = task.WithSecrets(ctx, taskSecrets)
+	}
+	// each stream masked separately, as masker buffers incomplete lines
+	stdout := s.Masker.Writer(output(StreamStdout, stdoutWriter), secrets...)
+	stderr := s.Masker.Writer(output(StreamStderr, stderrWriter), secrets...)
+	ctx, cancel := s.jobContext(ctx)
+	defer cancel()
+	err := runner.Run(ctx, command, stdout, stderr)
+	for _, out := range []io.Closer{stdout, stderr} {
+		if cerr := out.Close(); cerr != nil {
+			log.Printf("[WARN] job %s, %v", job.ID, cerr)
+		}
+	}
+	if err != nil && errors.Is(context.Cause(ctx), errShutdown) {
+		err = fmt.Errorf("%w: %w", errShutdown, err)
+	}
+	endSpan(span, err)
+	s.finishJob(ctx, job.ID, err)
+	s.closeJobLog(job.ID, jobLog)
+	return err
+}
+
+// consoleWriter returns writer of job output stream to the log, stderr lines marked with "2>".
+// In json log lines of the output have job id, task, trigger and stream.
+func (s *Rest) consoleWriter(job Job, stream string) io.Writer {
+	if s.JSONLog != nil {
+		return s.JSONLog.Job(job.ID, job.Task, job.Trigger, stream)
+	}
+	if stream == StreamStderr {
+		return log.ToWriter(log.Default(), "2>")
+	}
+	return log.ToWriter(log.Default(), ">")
+}
+
+// Exec runs the task by name, it is used to execute tasks received by agent from the hub
+func (s *Rest) Exec(ctx context.Context, taskName string, params map[string]string, stdout, stderr io.Writer) error {
+	if s.draining() {
+		return errShutdown
+	}
+	runner, command, ok := s.taskRunner(taskName)
+	if !ok {
+		return fmt.Errorf("unknown task %s", taskName)
+	}
+	params, err := s.taskParams(taskName, params)
+	if err != nil {
+		return fmt.Errorf("task %s: %w", taskName, err)
+	}
+	if t, found := s.Config.GetTask(taskName); found && t.ApprovalRequired() {
+		job := s.getJobs().pending(taskName, jobSource{Trigger: "hub", Trace: trace.SpanContextFromContext(ctx)})
+		s.publishJob(ctx, events.JobQueued, job.ID, nil)
+		if err = s.awaitApproval(ctx, t, job); err == nil {
+			err = s.awaitAdmission(ctx, taskName)
+		}
+		if err != nil {
+			s.finishJob(ctx, job.ID, err)
+			return fmt.Errorf("task %s: %w", taskName, err)
+		}
+		log.Printf("[INFO] invoke approved task %s", taskName)
+		s.getJobs().run(job.ID)
+		return s.runJob(task.WithParams(ctx, params), job, runner, command, stdout, stderr)
+	}
+	if err = s.admission(taskName); err != nil {
+		return fmt.Errorf("task %s: %w", taskName, err)
+	}
+	log.Printf("[INFO] invoke task %s", taskName)
+	job := s.getJobs().start(taskName, jobSource{Trigger: "hub", Trace: trace.SpanContextFromContext(ctx)})
+	return s.runJob(task.WithParams(ctx, params), job, runner, command, stdout, stderr)
+}
+
+// GET /jobs/{id} returns job status
+func (s *Rest) jobCtrl(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(r.PathValue("id"))
+	if !ok || !s.requestAllowed(r, job.Task) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	rest.RenderJSON(w, job)
+}
+
+// GET /jobs/{id}/logs?offset=N returns job output from offset and the offset of the next portion
+func (s *Rest) jobLogsCtrl(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(r.PathValue("id"))
+	if !ok || !s.requestAllowed(r, job.Task) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	data, next, inMemory := s.getJobs().logs(job.ID, offset)
+	if !inMemory {
+		s.storedJobLogs(w, job, offset)
 		return
 	}
+	rest.RenderJSON(w, rest.JSON{"data": string(data), "next": next, "status": job.Status})
+}
+
+// GET /jobs/{id}/lines?offset=N returns lines of job output with their time and stream from line offset,
+// and the offset of the next portion
+func (s *Rest) jobLinesCtrl(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.findJob(r.PathValue("id"))
+	if !ok || !s.requestAllowed(r, job.Task) {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	lines, next, inMemory := s.getJobs().lines(job.ID, offset)
+	if !inMemory {
+		lines, next = s.storedJobLines(job.ID, offset)
+	}
+	rest.RenderJSON(w, rest.JSON{"lines": lines, "next": next, "status": job.Status})
+}
+
+// keyAuth middleware allows requests with secret key, admin key or JWT passed as bearer token, or signed requests.
+// Requests without bearer token authorized with client certificate, if verified.
+// Requests with admin key marked in context, see isAdmin. Claims of JWT and client certificate kept in context,
+// see requestAllowed.
+func (s *Rest) keyAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, span := tracer.Start(r.Context(), "auth")
+		r, ok := s.authRequest(w, r)
+		traceAuth(span, r, ok)
+		if ok {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authRequest authorizes the request for keyAuth, returns the request with authorization details in context.
+// Error response written if not authorized.
+func (s *Rest) authRequest(w http.ResponseWriter, r *http.Request) (*http.Request, bool) {
+	if hasSignature(r) {
+		if err := s.verifySignature(r, nil); err != nil {
+			http.Error(w, "rejected, "+err.Error(), s.signatureFailed(r, err, http.StatusUnauthorized))
+			return r, false
+		}
+		auditRecord(r).Identity = "signature"
+		return r, true
+	}
+	token, hasToken := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if cert, ok := clientCert(r); ok && !hasToken {
+		auditRecord(r).Identity = "cert:" + cert.Subject.CommonName
+		return r.WithContext(context.WithValue(r.Context(), certCtxKey{}, cert)), true
+	}
+	if keyhash.Match(s.AdminKey, token) {
+		auditRecord(r).Identity = "admin"
+		return r.WithContext(context.WithValue(r.Context(), adminCtxKey{}, true)), true
+	}
+	if jwt, ok := jwtToken(r); ok && s.TokenVerifier != nil {
+		claims, err := s.TokenVerifier.Verify(jwt)
+		if err != nil {
+			s.authFailed(r, err.Error())
+			http.Error(w, "rejected, "+err.Error(), http.StatusUnauthorized)
+			return r, false
+		}
+		auditRecord(r).Identity = "token:" + claims.String("sub")
+		return r.WithContext(context.WithValue(r.Context(), claimsCtxKey{}, claims)), true
+	}
+	if s.RequireSignature || !keyhash.Match(s.SecretKey, token) {
+		s.authFailed(r, "invalid key")
+		http.Error(w, "rejected", http.StatusUnauthorized)
+		return r, false
+	}
+	auditRecord(r).Identity = "secret"
+	return r, true
+}
+
+type adminCtxKey struct{}
+
+// validKey checks the key against the secret key and the key of the task, both can be plain or hashed.
+// Returns name of the matched key, "secret" or "task:name".
+func (s *Rest) validKey(taskName, key string) (string, bool) {
+	if keyhash.Match(s.SecretKey, key) {
+		return "secret", true
+	}
+	if t, ok := s.Config.GetTask(taskName); ok && keyhash.Match(t.Key, key) {
+		return "task:" + taskName, true
+	}
+	return "", false
+}
+
+// isAdmin returns true if request authorized with admin key
+func isAdmin(r *http.Request) bool {
+	res, _ := r.Context().Value(adminCtxKey{}).(bool)
+	return res
+}
+
+type claimsCtxKey struct{}
+
+type certCtxKey struct{}
+
+// requestAllowed returns true if the request can access the task. Requests authorized with JWT or client
+// certificate allowed for tasks matching token claims or certificate names only, other authorized requests
+// allowed for all tasks.
+func (s *Rest) requestAllowed(r *http.Request, taskName string) bool {
+	if claims, ok := r.Context().Value(claimsCtxKey{}).(oidc.Claims); ok {
+		return s.tokenAllowed(claims, taskName)
+	}
+	if cert, ok := r.Context().Value(certCtxKey{}).(*x509.Certificate); ok {
+		t, found := s.Config.GetTask(taskName)
+		return found && t.AllowsCert(certNames(cert))
+	}
+	return true
+}
+
+// tokenAllowed checks token claims against oidc claims of the task
+func (s *Rest) tokenAllowed(claims oidc.Claims, taskName string) bool {
+	t, ok := s.Config.GetTask(taskName)
+	return ok && t.AllowsClaims(claims.String)
+}
+
+// jwtToken returns bearer token if it looks like JWT, i.e. has three dot-separated parts
+func jwtToken(r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token, ok && strings.Count(token, ".") == 2
+}
 
-	rest.RenderJSON(w, rest.JSON{"updated": "ok", "task": taskName})
+// admission checks if the task can run now. The task can't run if disabled, during deploy freeze
+// or outside of its maintenance windows.
+func (s *Rest) admission(taskName string) error {
+	t, ok := s.Config.GetTask(taskName)
+	if ok {
+		taskName = t.Name // state kept under the name from config
+	}
+	if err := s.getState().check(taskName); err != nil {
+		return err
+	}
+	if !ok || t.Maintenance == nil {
+		return nil
+	}
+	now := time.Now()
+	if t.Maintenance.Allowed(now) {
+		return nil
+	}
+	next, ok := t.Maintenance.Next(now)
+	if !ok {
+		return errNoWindow
+	}
+	return &windowError{next: next}
+}
+
+// canQueue checks if the trigger rejected by admission can be queued instead
+func (s *Rest) canQueue(taskName string, err error) bool {
+	if errors.Is(err, errFrozen) {
+		return s.FreezeQueue
+	}
+	if we := (*windowError)(nil); errors.As(err, &we) {
+		t, _ := s.Config.GetTask(taskName)
+		return t.Maintenance != nil && t.Maintenance.Defer
+	}
+	return false
+}
+
+// taskParams checks given parameters against the task declaration and applies defaults.
+// Parameters of tasks not defined in config, i.e. registered by agents, are checked by the agent.
+func (s *Rest) taskParams(taskName string, params map[string]string) (map[string]string, error) {
+	t, ok := s.Config.GetTask(taskName)
+	if !ok {
+		return params, nil
+	}
+	return t.ResolveParams(params)
+}
+
+// taskRunner resolves the command and the runner for the task. The task's dedicated runner is used, if any,
+// or the default runner otherwise. Tasks not defined in config are known only if they have dedicated runner,
+// i.e. tasks registered by agents.
+func (s *Rest) taskRunner(taskName string) (runner Runner, command string, ok bool) {
+	command, ok = s.Config.GetTaskCommand(taskName)
+	if s.RunnerFor != nil {
+		if r, found := s.RunnerFor(taskName); found {
+			return r, command, true
+		}
+	}
+	return s.Runner, command, ok
+}
+
+func (s *Rest) getJobs() *jobs {
+	s.jobsOnce.Do(func() { s.jobs = newJobs() })
+	return s.jobs
+}
+
+func (s *Rest) getState() *state {
+	s.stateOnce.Do(func() {
+		st, err := loadState(s.StateFile)
+		if err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+		s.state = st
+	})
+	return s.state
 }
 
 // middleware for slowing requests downs
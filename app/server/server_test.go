@@ -1,11 +1,18 @@
 package server
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
@@ -13,7 +20,11 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/umputun/updater/app/keyhash"
+	"github.com/umputun/updater/app/mask"
+	"github.com/umputun/updater/app/oidc"
 	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
 )
 
 func TestRest_Run(t *testing.T) {
@@ -25,12 +36,65 @@ func TestRest_Run(t *testing.T) {
 	assert.Equal(t, "http: Server closed", err.Error())
 }
 
+func TestRest_RunUnixSocket(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "updater.sock")
+	notify, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: filepath.Join(t.TempDir(), "notify.sock"), Net: "unixgram"})
+	require.NoError(t, err)
+	defer notify.Close()
+	t.Setenv("NOTIFY_SOCKET", notify.LocalAddr().String())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	srv := Rest{Listen: "unix://" + sock, SocketMode: 0o600, Version: "v1"}
+	done := make(chan error)
+	go func() { done <- srv.Run(ctx) }()
+
+	readNotify := func() string {
+		require.NoError(t, notify.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 64)
+		n, err := notify.Read(buf)
+		require.NoError(t, err)
+		return string(buf[:n])
+	}
+	assert.Equal(t, "READY=1", readNotify())
+
+	fi, err := os.Stat(sock)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm())
+
+	client := http.Client{Transport: &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", sock)
+	}}}
+	resp, err := client.Get("http://updater/ping")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, "pong", string(body))
+
+	cancel()
+	assert.Equal(t, "STOPPING=1", readNotify())
+	assert.EqualError(t, <-done, "http: Server closed")
+	_, err = os.Stat(sock)
+	assert.True(t, os.IsNotExist(err), "socket removed")
+
+	// stale socket replaced on start
+	stale, err := net.Listen("unix", sock)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() { done <- srv.Run(ctx) }()
+	assert.Equal(t, "READY=1", readNotify())
+	cancel()
+	<-done
+}
+
 func TestRest_taskCtrl(t *testing.T) {
-	conf := &mocks.ConfigMock{GetTaskCommandFunc: func(name string) (string, bool) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
 		return "echo " + name, true
 	}}
 
-	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer) error {
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error {
 		return nil
 	}}
 
@@ -63,11 +127,11 @@ func TestRest_taskCtrl(t *testing.T) {
 }
 
 func TestRest_taskCtrlAsync(t *testing.T) {
-	conf := &mocks.ConfigMock{GetTaskCommandFunc: func(name string) (string, bool) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
 		return "echo " + name, true
 	}}
 
-	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer) error {
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error {
 		time.Sleep(100 * time.Millisecond)
 		return nil
 	}}
@@ -86,11 +150,11 @@ func TestRest_taskCtrlAsync(t *testing.T) {
 }
 
 func TestRest_taskPostCtrl(t *testing.T) {
-	conf := &mocks.ConfigMock{GetTaskCommandFunc: func(name string) (string, bool) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
 		return "echo " + name, true
 	}}
 
-	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer) error {
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error {
 		return nil
 	}}
 
@@ -160,7 +224,7 @@ func TestRest_taskPostCtrl_BadRequests(t *testing.T) {
 }
 
 func TestRest_taskCtrl_ConfigError(t *testing.T) {
-	conf := &mocks.ConfigMock{GetTaskCommandFunc: func(name string) (string, bool) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
 		return "", false
 	}}
 
@@ -174,11 +238,11 @@ func TestRest_taskCtrl_ConfigError(t *testing.T) {
 }
 
 func TestRest_taskCtrl_RunnerError(t *testing.T) {
-	conf := &mocks.ConfigMock{GetTaskCommandFunc: func(name string) (string, bool) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
 		return "echo " + name, true
 	}}
 
-	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer) error {
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error {
 		return io.EOF
 	}}
 
@@ -204,10 +268,10 @@ func TestRest_SlowMiddleware_SkipsOnZeroDelay(t *testing.T) {
 }
 
 func TestRest_taskCtrlAsync_ValidatesResponse(t *testing.T) {
-	conf := &mocks.ConfigMock{GetTaskCommandFunc: func(name string) (string, bool) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
 		return "echo " + name, true
 	}}
-	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer) error {
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error {
 		return nil
 	}}
 
@@ -227,3 +291,415 @@ func TestRest_taskCtrlAsync_ValidatesResponse(t *testing.T) {
 	assert.Equal(t, "ok", result.Submitted)
 	assert.Equal(t, "task1", result.Task)
 }
+
+func TestRest_taskCtrl_RunnerFor(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		if name == "compose1" {
+			return "", true
+		}
+		return "echo " + name, true
+	}}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	composeRunner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", RunnerFor: func(name string) (Runner, bool) {
+		if name == "compose1" {
+			return composeRunner, true
+		}
+		return nil, false
+	}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/update/compose1/12345")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp, err = http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	require.Equal(t, 1, len(composeRunner.RunCalls()))
+	require.Equal(t, 1, len(runner.RunCalls()))
+	assert.Equal(t, "echo task1", runner.RunCalls()[0].Command)
+}
+
+func TestRest_Exec(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "echo " + name, name != "unknown"
+	}}
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, cmd string, w, errW io.Writer) error {
+		_, _ = errW.Write([]byte("warning\n"))
+		_, err := w.Write([]byte(cmd))
+		return err
+	}}
+	srv := Rest{Config: conf, Runner: runner}
+
+	buf, errBuf := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	require.NoError(t, srv.Exec(context.Background(), "task1", nil, buf, errBuf))
+	assert.Equal(t, "echo task1", buf.String())
+	assert.Equal(t, "warning\n", errBuf.String())
+
+	err := srv.Exec(context.Background(), "unknown", nil, buf, errBuf)
+	require.Error(t, err)
+	assert.Equal(t, "unknown task unknown", err.Error())
+}
+
+func TestRest_AgentTasks(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "", false
+	}}
+	agentRunner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	hub := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hub " + r.URL.Path))
+	})
+
+	srv := Rest{Config: conf, SecretKey: "12345", UpdateDelay: time.Second, AgentHub: hub,
+		RunnerFor: func(name string) (Runner, bool) { return agentRunner, name == "remote1" }}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	st := time.Now()
+	resp, err := http.Get(ts.URL + "/agent/poll")
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "hub /agent/poll", string(body))
+	assert.Less(t, time.Since(st), time.Second, "hub requests not delayed")
+
+	resp, err = http.Get(ts.URL + "/update/remote1/12345")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 1, len(agentRunner.RunCalls()))
+
+	resp, err = http.Get(ts.URL + "/update/remote2/12345")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// noTask is GetTask of config without declared task params
+func noTask(string) (task.Task, bool) { return task.Task{}, false }
+
+func TestRest_TaskParams(t *testing.T) {
+	conf := &mocks.ConfigMock{
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true },
+		GetTaskFunc: func(name string) (task.Task, bool) {
+			return task.Task{Name: name, Params: []task.Param{{Name: "VERSION", Required: true}, {Name: "ENV", Default: "prod"}}}, true
+		},
+	}
+	var params map[string]string
+	runner := &mocks.RunnerMock{RunFunc: func(ctx context.Context, _ string, w, _ io.Writer) error {
+		params = task.ParamsFromContext(ctx)
+		_, _ = w.Write([]byte("done\n"))
+		return nil
+	}}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json",
+		strings.NewReader(`{"task":"task1","secret":"12345","params":{"VERSION":"1.2"}}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, map[string]string{"VERSION": "1.2", "ENV": "prod"}, params)
+
+	resp, err = http.Post(ts.URL+"/update", "application/json",
+		strings.NewReader(`{"task":"task1","secret":"12345","params":{"VERSION":"1.2","BAD":"x"}}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "unknown parameter BAD\n", string(body))
+
+	resp, err = http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+}
+
+func TestRest_MaskOutput(t *testing.T) {
+	conf := &mocks.ConfigMock{
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true },
+		GetTaskFunc: func(name string) (task.Task, bool) {
+			return task.Task{Name: name, Params: []task.Param{{Name: "TOKEN", Secret: true}}}, true
+		},
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(ctx context.Context, _ string, w, _ io.Writer) error {
+		// secrets split between writes
+		for _, s := range []string{"login -p regi", "stry-pass\n", "token ", task.ParamsFromContext(ctx)["TOKEN"][:3],
+			task.ParamsFromContext(ctx)["TOKEN"][3:], " key=abc\ndone"} {
+			_, _ = w.Write([]byte(s))
+		}
+		return nil
+	}}
+	masker, err := mask.New(`key=(\w+)`)
+	require.NoError(t, err)
+	masker.Add("registry-pass")
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner, Masker: masker}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json",
+		strings.NewReader(`{"task":"task1","secret":"12345","params":{"TOKEN":"job-token"}}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	data, _, ok := srv.getJobs().logs(res.Job, 0)
+	require.True(t, ok)
+	assert.Equal(t, "login -p ****\ntoken **** key=****\ndone\n", string(data))
+}
+
+func TestRest_TaskSecrets(t *testing.T) {
+	conf := &mocks.ConfigMock{
+		GetTaskCommandFunc: func(name string) (string, bool) { return `echo "token $API_TOKEN, other $OTHER"`, true },
+		GetTaskFunc: func(name string) (task.Task, bool) {
+			return task.Task{Name: name, Secrets: []string{"API_TOKEN"}}, true
+		},
+	}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: &task.ShellRunner{}, Timeout: time.Second,
+		Secrets: task.Secrets{"API_TOKEN": "api-token-value", "OTHER": "other-value"}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	data, _, ok := srv.getJobs().logs(res.Job, 0)
+	require.True(t, ok)
+	assert.Equal(t, "token ****, other \n", string(data), "only secrets of the task passed, masked in the output")
+}
+
+func TestRest_JobAPI(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "echo " + name, true
+	}}
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, _ string, w, _ io.Writer) error {
+		_, _ = w.Write([]byte("line1\n"))
+		return errors.New("failed")
+	}}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	jobs := srv.getJobs().list()
+	require.Len(t, jobs, 1)
+
+	get := func(path, key string) (int, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	code, body := get("/jobs/"+jobs[0].ID, "12345")
+	require.Equal(t, http.StatusOK, code)
+	job := Job{}
+	require.NoError(t, json.Unmarshal([]byte(body), &job))
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, 1, job.ExitCode)
+
+	code, body = get("/jobs/"+jobs[0].ID+"/logs?offset=2", "12345")
+	require.Equal(t, http.StatusOK, code)
+	assert.Equal(t, `{"data":"ne1\n","next":6,"status":"failed"}`+"\n", body)
+
+	code, _ = get("/jobs/"+jobs[0].ID, "bad")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = get("/jobs/unknown", "12345")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestRest_JobLines(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "echo " + name, true
+	}}
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, _ string, stdout, stderr io.Writer) error {
+		_, _ = stdout.Write([]byte("pulling\n"))
+		_, _ = stderr.Write([]byte("warning: token s3cret\n"))
+		_, _ = stdout.Write([]byte("done"))
+		return nil
+	}}
+	masker, err := mask.New()
+	require.NoError(t, err)
+	masker.Add("s3cret")
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner, Masker: masker}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/jobs/"+res.Job+"/lines", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer 12345")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	lines := struct {
+		Lines  []Line
+		Next   int
+		Status string
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&lines))
+	assert.Equal(t, 3, lines.Next)
+	assert.Equal(t, JobSuccess, lines.Status)
+	require.Len(t, lines.Lines, 3)
+	assert.Equal(t, Line{Time: lines.Lines[0].Time, Stream: StreamStdout, Text: "pulling"}, lines.Lines[0])
+	assert.Equal(t, Line{Time: lines.Lines[1].Time, Stream: StreamStderr, Text: "warning: token ****"}, lines.Lines[1])
+	assert.Equal(t, Line{Time: lines.Lines[2].Time, Stream: StreamStdout, Text: "done"}, lines.Lines[2])
+
+	data, _, ok := srv.getJobs().logs(res.Job, 0)
+	require.True(t, ok)
+	assert.Equal(t, "pulling\nwarning: token ****\ndone\n", string(data))
+}
+
+func TestRest_TokenAuth(t *testing.T) {
+	tasks := map[string]task.Task{
+		"deploy":  {Name: "deploy", Command: "deploy.sh", OIDC: map[string]string{"repository": "umputun/updater", "ref": "refs/tags/*"}},
+		"migrate": {Name: "migrate", Command: "migrate.sh"},
+	}
+	conf := &mocks.ConfigMock{
+		GetTaskFunc:        func(name string) (task.Task, bool) { t, ok := tasks[name]; return t, ok },
+		GetTaskCommandFunc: func(name string) (string, bool) { t, ok := tasks[name]; return t.Command, ok },
+		GetTasksFunc:       func() []task.Task { return []task.Task{tasks["deploy"], tasks["migrate"]} },
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	verifier := fakeVerifier{
+		"a.tag.token":    {"repository": "umputun/updater", "ref": "refs/tags/v1.0.0", "sub": "repo:umputun/updater"},
+		"a.branch.token": {"repository": "umputun/updater", "ref": "refs/heads/master"},
+	}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Second, TokenVerifier: verifier}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	call := func(method, path, token, body string) (int, string) {
+		req, err := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	code, body := call("POST", "/update", "a.tag.token", `{"task":"deploy"}`)
+	require.Equal(t, http.StatusOK, code, body)
+	assert.Equal(t, "deploy.sh", runner.RunCalls()[0].Command)
+	res := struct{ Job string }{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+
+	code, body = call("POST", "/update", "a.branch.token", `{"task":"deploy"}`)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "task not allowed for token\n", body)
+
+	code, _ = call("POST", "/update", "a.tag.token", `{"task":"migrate"}`)
+	assert.Equal(t, http.StatusForbidden, code, "task without oidc claims not allowed")
+
+	code, body = call("POST", "/update", "a.bad.token", `{"task":"deploy"}`)
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "rejected, invalid token\n", body)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+
+	// jobs and tasks api limited to allowed tasks
+	code, _ = call("GET", "/jobs/"+res.Job, "a.tag.token", "")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = call("GET", "/jobs/"+res.Job+"/logs", "a.branch.token", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, body = call("GET", "/tasks", "a.tag.token", "")
+	assert.Equal(t, http.StatusOK, code)
+	infos := []TaskInfo{}
+	require.NoError(t, json.Unmarshal([]byte(body), &infos))
+	require.Equal(t, 1, len(infos))
+	assert.Equal(t, "deploy", infos[0].Name)
+	assert.Equal(t, "refs/tags/*", infos[0].OIDC["ref"])
+	code, _ = call("GET", "/tasks/migrate", "a.tag.token", "")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = call("GET", "/tasks/migrate", "12345", "")
+	assert.Equal(t, http.StatusOK, code, "secret key allowed for all tasks")
+}
+
+type fakeVerifier map[string]oidc.Claims
+
+func (f fakeVerifier) Verify(token string) (oidc.Claims, error) {
+	if claims, ok := f[token]; ok {
+		return claims, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func TestRest_HashedKeys(t *testing.T) {
+	secretHash, err := keyhash.Hash("12345", keyhash.Bcrypt)
+	require.NoError(t, err)
+	taskHash, err := keyhash.Hash("task-key", keyhash.Argon2id)
+	require.NoError(t, err)
+	tasks := map[string]task.Task{"deploy": {Name: "deploy", Command: "deploy.sh", Key: taskHash}, "other": {Name: "other", Command: "other.sh"}}
+	conf := &mocks.ConfigMock{
+		GetTaskFunc:        func(name string) (task.Task, bool) { t, ok := tasks[name]; return t, ok },
+		GetTaskCommandFunc: func(name string) (string, bool) { t, ok := tasks[name]; return t.Command, ok },
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: secretHash, Timeout: time.Second}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	tbl := []struct {
+		path string
+		code int
+	}{
+		{"/update/deploy/12345", http.StatusOK},
+		{"/update/deploy/task-key", http.StatusOK},
+		{"/update/other/12345", http.StatusOK},
+		{"/update/other/task-key", http.StatusForbidden},
+		{"/update/deploy/bad", http.StatusForbidden},
+		{"/update/deploy/" + url.PathEscape(secretHash), http.StatusForbidden},
+	}
+	for _, tt := range tbl {
+		resp, err := http.Get(ts.URL + tt.path)
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, tt.code, resp.StatusCode, tt.path)
+	}
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"deploy","secret":"task-key"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 4, len(runner.RunCalls()))
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/update", strings.NewReader(`{"task":"deploy"}`))
+	require.NoError(t, err)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+	req.Header.Set(HeaderNonce, "nonce1")
+	req.Header.Set(HeaderSignature, "sig")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, "rejected, signed requests require plain secret key\n", string(body))
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"sync/atomic"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+var errShutdown = errors.New("updater shutting down")
+
+const (
+	defaultShutdownDeadline = 10 * time.Second // hard deadline after the grace time, if not set
+	shutdownCheckInterval   = 100 * time.Millisecond
+)
+
+// lifecycle keeps state of the server shutdown. Queued and pending jobs are dropped as soon as shutdown starts,
+// running jobs are canceled after the grace time.
+type lifecycle struct {
+	draining   atomic.Bool
+	queueCtx   context.Context
+	stopQueued context.CancelCauseFunc
+	jobsCtx    context.Context
+	killJobs   context.CancelCauseFunc
+}
+
+// shutdown drains the server: rejects new triggers, drops queued and pending jobs, waits for running jobs
+// up to ShutdownGrace, cancels the rest and stops http server. Everything is done within ShutdownDeadline.
+func (s *Rest) shutdown(httpServer *http.Server) {
+	l := s.getLifecycle()
+	l.draining.Store(true)
+	l.stopQueued(errShutdown)
+
+	hardCtx, cancel := context.WithTimeout(context.Background(), s.shutdownDeadline())
+	defer cancel()
+
+	if n := s.getJobs().running(); n > 0 {
+		log.Printf("[INFO] shutdown, waiting up to %v for %d running jobs", s.ShutdownGrace, n)
+		graceCtx, graceCancel := context.WithTimeout(hardCtx, s.ShutdownGrace)
+		if !s.waitJobs(graceCtx) {
+			log.Printf("[WARN] shutdown, grace time is over, cancel %d running jobs", s.getJobs().running())
+			l.killJobs(errShutdown)
+			if !s.waitJobs(hardCtx) {
+				log.Printf("[WARN] shutdown, %d jobs still running at the deadline", s.getJobs().running())
+			}
+		}
+		graceCancel()
+	}
+	l.killJobs(errShutdown)
+
+	if err := httpServer.Shutdown(hardCtx); err != nil {
+		log.Printf("[WARN] http server shutdown, %v", err)
+		if err = httpServer.Close(); err != nil {
+			log.Printf("[ERROR] failed to close http server, %v", err)
+		}
+	}
+	log.Printf("[INFO] shutdown completed")
+}
+
+// shutdownDeadline returns max duration of the whole shutdown, at least the grace time with default deadline
+func (s *Rest) shutdownDeadline() time.Duration {
+	if s.ShutdownDeadline < s.ShutdownGrace {
+		return s.ShutdownGrace + defaultShutdownDeadline
+	}
+	return s.ShutdownDeadline
+}
+
+// waitJobs waits until no jobs running, returns false if context canceled before that
+func (s *Rest) waitJobs(ctx context.Context) bool {
+	ticker := time.NewTicker(shutdownCheckInterval)
+	defer ticker.Stop()
+	for s.getJobs().running() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// draining returns true if the server is shutting down and doesn't accept new triggers
+func (s *Rest) draining() bool {
+	return s.getLifecycle().draining.Load()
+}
+
+// jobContext makes context of the job, canceled on shutdown after the grace time
+func (s *Rest) jobContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	jobsCtx := s.getLifecycle().jobsCtx
+	ctx, cancel := context.WithCancelCause(ctx)
+	stop := context.AfterFunc(jobsCtx, func() { cancel(context.Cause(jobsCtx)) })
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// queueContext returns context of queued and pending jobs, canceled as soon as shutdown starts
+func (s *Rest) queueContext() context.Context {
+	return s.getLifecycle().queueCtx
+}
+
+func (s *Rest) getLifecycle() *lifecycle {
+	s.lifecycleOnce.Do(func() {
+		l := &lifecycle{}
+		l.queueCtx, l.stopQueued = context.WithCancelCause(context.Background())
+		l.jobsCtx, l.killJobs = context.WithCancelCause(context.Background())
+		s.lifecycle = l
+	})
+	return s.lifecycle
+}
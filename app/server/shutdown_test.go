@@ -0,0 +1,186 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/server/mocks"
+)
+
+func TestRest_ShutdownWaitsForJobs(t *testing.T) {
+	release := make(chan struct{})
+	runner := &mocks.RunnerMock{RunFunc: func(ctx context.Context, _ string, _, _ io.Writer) error {
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+	srv, url := startShutdownServer(t, runner, time.Minute)
+	jobID := triggerAsync(t, url, "task1")
+
+	done := make(chan error)
+	go func() { done <- srv.run() }()
+	require.Eventually(t, srv.draining, time.Second, 10*time.Millisecond)
+
+	resp, err := http.Post(url+"/update", "application/json", strings.NewReader(`{"task":"task2","secret":"12345"}`))
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "new triggers rejected")
+	assert.Equal(t, "updater shutting down\n", string(body))
+
+	req, err := http.NewRequest(http.MethodGet, url+"/jobs/"+jobID, http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer 12345")
+	resp, err = http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "job status served while draining")
+
+	select {
+	case <-done:
+		t.Fatal("shutdown completed with running job")
+	case <-time.After(200 * time.Millisecond):
+	}
+	close(release)
+	assert.EqualError(t, <-done, "http: Server closed")
+	job, ok := srv.getJobs().get(jobID)
+	require.True(t, ok)
+	assert.Equal(t, JobSuccess, job.Status)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+}
+
+func TestRest_ShutdownCancelsJobsAfterGrace(t *testing.T) {
+	runner := &mocks.RunnerMock{RunFunc: func(ctx context.Context, _ string, _, _ io.Writer) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	srv, url := startShutdownServer(t, runner, 100*time.Millisecond)
+	srv.FreezeQueue = true
+	runningID := triggerAsync(t, url, "task1")
+	_, err := srv.getState().freeze("release", 0)
+	require.NoError(t, err)
+	queuedID := triggerAsync(t, url, "task2")
+	queued, ok := srv.getJobs().get(queuedID)
+	require.True(t, ok)
+	require.Equal(t, JobQueued, queued.Status)
+
+	st := time.Now()
+	assert.EqualError(t, srv.run(), "http: Server closed")
+	assert.Less(t, time.Since(st), time.Second)
+
+	job, ok := srv.getJobs().get(runningID)
+	require.True(t, ok)
+	assert.Equal(t, JobFailed, job.Status)
+	assert.Equal(t, "updater shutting down: context canceled", job.Error)
+
+	require.Eventually(t, func() bool {
+		job, _ := srv.getJobs().get(queuedID)
+		return job.Status == JobFailed
+	}, time.Second, 10*time.Millisecond)
+	job, _ = srv.getJobs().get(queuedID)
+	assert.Equal(t, "updater shutting down", job.Error, "queued job dropped")
+	assert.Equal(t, 1, len(runner.RunCalls()))
+}
+
+func TestRest_ShutdownKeepsWatchdog(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sock)
+	t.Setenv("WATCHDOG_USEC", "100000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	runner := &mocks.RunnerMock{RunFunc: func(ctx context.Context, _ string, _, _ io.Writer) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	srv, url := startShutdownServer(t, runner, 500*time.Millisecond)
+	triggerAsync(t, url, "task1")
+
+	messages := make(chan string, 100)
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return
+			}
+			messages <- string(buf[:n])
+		}
+	}()
+	assert.EqualError(t, srv.run(), "http: Server closed")
+
+	var stopping bool
+	pings := 0
+	for len(messages) > 0 {
+		switch m := <-messages; {
+		case m == "STOPPING=1\nEXTEND_TIMEOUT_USEC=5000000":
+			stopping = true
+		case m == "WATCHDOG=1" && stopping:
+			pings++
+		}
+	}
+	assert.True(t, stopping)
+	assert.Greater(t, pings, 3, "watchdog pings sent while running jobs drained")
+}
+
+type shutdownServer struct {
+	*Rest
+	cancel context.CancelFunc
+	done   chan error
+}
+
+// run cancels server context and waits for Run completion. Idle client connections closed first,
+// otherwise http server shutdown waits for them till read header timeout.
+func (s shutdownServer) run() error {
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	s.cancel()
+	return <-s.done
+}
+
+func startShutdownServer(t *testing.T, runner Runner, grace time.Duration) (shutdownServer, string) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	listen := freeAddr(t)
+	srv := &Rest{Listen: listen, Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Minute,
+		ShutdownGrace: grace, ShutdownDeadline: 5 * time.Second}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- srv.Run(ctx) }()
+	url := "http://" + listen
+	require.Eventually(t, func() bool {
+		resp, err := http.Get(url + "/ping")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return true
+	}, time.Second, 10*time.Millisecond)
+	return shutdownServer{Rest: srv, cancel: cancel, done: done}, url
+}
+
+func triggerAsync(t *testing.T, url, taskName string) string {
+	resp, err := http.Post(url+"/update", "application/json",
+		strings.NewReader(`{"task":"`+taskName+`","secret":"12345","async":true}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	return res.Job
+}
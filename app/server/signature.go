@@ -0,0 +1,187 @@
+package server
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+
+	"github.com/umputun/updater/app/keyhash"
+)
+
+// headers of signed requests
+const (
+	HeaderTimestamp = "X-Updater-Timestamp"
+	HeaderNonce     = "X-Updater-Nonce"
+	HeaderSignature = "X-Updater-Signature"
+)
+
+const (
+	defaultMaxSkew = 5 * time.Minute
+	maxRequestBody = 1 << 20
+	maxNonceLen    = 128
+)
+
+// Signature makes hex-encoded HMAC-SHA256 signature of the request with the secret key. Signed payload is
+// method, path with query, timestamp, nonce and body, separated by new lines.
+func Signature(secret, method, path, timestamp, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n" + nonce + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hasSignature checks if request has signature header
+func hasSignature(r *http.Request) bool {
+	return r.Header.Get(HeaderSignature) != ""
+}
+
+// errTimestampSkew is the error of signed request with timestamp out of allowed skew, i.e. because of client clock drift
+var errTimestampSkew = errors.New("timestamp out of range")
+
+// verifySignature checks signature, timestamp skew and nonce of the signed request
+func (s *Rest) verifySignature(r *http.Request, body []byte) error {
+	ts, nonce := r.Header.Get(HeaderTimestamp), r.Header.Get(HeaderNonce)
+	if ts == "" || nonce == "" {
+		return errors.New("timestamp and nonce required")
+	}
+	if len(nonce) > maxNonceLen {
+		return errors.New("nonce too long")
+	}
+	if keyhash.IsHash(s.SecretKey) {
+		return errors.New("signed requests require plain secret key")
+	}
+	unix, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("invalid timestamp")
+	}
+	skew := s.MaxSkew
+	if skew <= 0 {
+		skew = defaultMaxSkew
+	}
+	if d := time.Since(time.Unix(unix, 0)); d > skew || d < -skew {
+		return errTimestampSkew
+	}
+	expected := Signature(s.SecretKey, r.Method, r.URL.RequestURI(), ts, nonce, body)
+	if !hmac.Equal([]byte(r.Header.Get(HeaderSignature)), []byte(expected)) {
+		return errors.New("invalid signature")
+	}
+	// nonce checked after the signature, so unsigned requests can't fill the cache
+	if !s.getNonces().add(nonce, time.Unix(unix, 0).Add(skew)) {
+		return errors.New("nonce already used")
+	}
+	return nil
+}
+
+// signatureFailed records rejection of the signed request and returns status of the response. Timestamp skew
+// is not a credential error, so it is rejected with 401 and not counted as failed authentication for the ban.
+func (s *Rest) signatureFailed(r *http.Request, err error, status int) int {
+	if errors.Is(err, errTimestampSkew) {
+		auditRecord(r).Reason = err.Error()
+		log.Printf("[WARN] rejected signed request from %s, %v", s.clientIP(r), err)
+		return http.StatusUnauthorized
+	}
+	s.authFailed(r, err.Error())
+	return status
+}
+
+func (s *Rest) getNonces() *nonceCache {
+	s.nonceOnce.Do(func() {
+		s.nonces = &nonceCache{file: s.NonceFile, seen: map[string]time.Time{}}
+		if err := s.nonces.load(); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	})
+	return s.nonces
+}
+
+// nonceCache keeps nonces of signed requests until the request timestamp is out of allowed skew.
+// Nonces appended to the file, if set, to reject replays after restart.
+type nonceCache struct {
+	mu   sync.Mutex
+	file string
+	seen map[string]time.Time // nonce -> expiration
+}
+
+// add remembers nonce till expiration, false returned if the nonce seen already
+func (c *nonceCache) add(nonce string, expires time.Time) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if exp, ok := c.seen[nonce]; ok && now.Before(exp) {
+		return false
+	}
+	for k, exp := range c.seen {
+		if now.After(exp) {
+			delete(c.seen, k)
+		}
+	}
+	c.seen[nonce] = expires
+	if c.file != "" {
+		if err := c.appendFile(nonce, expires); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+	}
+	return true
+}
+
+func (c *nonceCache) appendFile(nonce string, expires time.Time) error {
+	fh, err := os.OpenFile(c.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600) // nolint
+	if err != nil {
+		return fmt.Errorf("can't open nonce file: %w", err)
+	}
+	defer fh.Close() //nolint
+	if _, err = fmt.Fprintf(fh, "%d %s\n", expires.Unix(), nonce); err != nil {
+		return fmt.Errorf("can't write nonce file: %w", err)
+	}
+	return nil
+}
+
+// load reads not expired nonces from the file and rewrites it without expired ones
+func (c *nonceCache) load() error {
+	if c.file == "" {
+		return nil
+	}
+	fh, err := os.Open(c.file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("can't open nonce file: %w", err)
+	}
+	now := time.Now()
+	scanner := bufio.NewScanner(fh)
+	for scanner.Scan() {
+		ts, nonce, ok := strings.Cut(scanner.Text(), " ")
+		unix, err := strconv.ParseInt(ts, 10, 64)
+		if !ok || err != nil || nonce == "" {
+			continue
+		}
+		if exp := time.Unix(unix, 0); now.Before(exp) {
+			c.seen[nonce] = exp
+		}
+	}
+	_ = fh.Close()
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("can't read nonce file: %w", err)
+	}
+
+	var sb strings.Builder
+	for nonce, exp := range c.seen {
+		sb.WriteString(strconv.FormatInt(exp.Unix(), 10) + " " + nonce + "\n")
+	}
+	if err := os.WriteFile(c.file, []byte(sb.String()), 0o600); err != nil {
+		return fmt.Errorf("can't write nonce file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/client"
+	"github.com/umputun/updater/app/server/mocks"
+)
+
+func TestRest_SignedRequests(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Second}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	body := []byte(`{"task":"task1"}`)
+	signed := func(at time.Time, nonce, signature string) *http.Request {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/update", bytes.NewReader(body))
+		require.NoError(t, err)
+		unix := strconv.FormatInt(at.Unix(), 10)
+		if signature == "" {
+			signature = Signature("12345", http.MethodPost, "/update", unix, nonce, body)
+		}
+		req.Header.Set(HeaderTimestamp, unix)
+		req.Header.Set(HeaderNonce, nonce)
+		req.Header.Set(HeaderSignature, signature)
+		return req
+	}
+	call := func(req *http.Request) (int, string) {
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	code, _ := call(signed(time.Now(), "nonce1", ""))
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+
+	code, msg := call(signed(time.Now(), "nonce1", ""))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "rejected, nonce already used\n", msg)
+
+	code, msg = call(signed(time.Now(), "nonce2", "bad"))
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "rejected, invalid signature\n", msg)
+
+	code, msg = call(signed(time.Now().Add(-10*time.Minute), "nonce3", ""))
+	assert.Equal(t, http.StatusUnauthorized, code)
+	assert.Equal(t, "rejected, timestamp out of range\n", msg)
+	failures := srv.getGuard().clients["127.0.0.1"].count
+	for i := 0; i < 3; i++ {
+		code, _ = call(signed(time.Now().Add(10*time.Minute), "skew"+strconv.Itoa(i), ""))
+		assert.Equal(t, http.StatusUnauthorized, code)
+	}
+	assert.Equal(t, failures, srv.getGuard().clients["127.0.0.1"].count, "clock skew not counted as failed authentication")
+
+	req := signed(time.Now(), "", "")
+	code, msg = call(req)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "rejected, timestamp and nonce required\n", msg)
+
+	// body changed after signing
+	req = signed(time.Now(), "nonce4", "")
+	req.Body = io.NopCloser(strings.NewReader(`{"task":"task2"}`))
+	req.ContentLength = int64(len(`{"task":"task2"}`))
+	code, msg = call(req)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "rejected, invalid signature\n", msg)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+
+	// plain secret still accepted
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRest_SignedOnly(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, _ string, w, _ io.Writer) error {
+		_, _ = w.Write([]byte("done\n"))
+		return nil
+	}}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Second, RequireSignature: true,
+		NonceFile: filepath.Join(t.TempDir(), "nonces")}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Post(ts.URL+"/update", "application/json", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	resp, err = http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	code, _ := adminCall(t, ts.URL+"/tasks", http.MethodGet, "12345", "")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// client signs trigger and job requests
+	cl := &client.Client{URL: ts.URL, Key: "12345", Sign: true, PollInterval: 10 * time.Millisecond}
+	id, err := cl.Trigger(context.Background(), "task1", nil)
+	require.NoError(t, err)
+	lw := bytes.NewBuffer(nil)
+	job, err := cl.Wait(context.Background(), id, lw)
+	require.NoError(t, err)
+	assert.Equal(t, JobSuccess, job.Status)
+	assert.Equal(t, "done\n", lw.String())
+
+	// nonces restored from the file
+	nonces := &nonceCache{file: srv.NonceFile, seen: map[string]time.Time{}}
+	require.NoError(t, nonces.load())
+	assert.GreaterOrEqual(t, len(nonces.seen), 3)
+	for nonce := range nonces.seen {
+		assert.False(t, nonces.add(nonce, time.Now().Add(time.Minute)))
+	}
+	assert.True(t, nonces.add("new-nonce", time.Now().Add(time.Minute)))
+}
+
+func TestNonceCache_Expiration(t *testing.T) {
+	c := &nonceCache{seen: map[string]time.Time{}}
+	assert.True(t, c.add("n1", time.Now().Add(-time.Second)))
+	assert.True(t, c.add("n1", time.Now().Add(time.Minute)), "expired nonce can be used again")
+	assert.False(t, c.add("n1", time.Now().Add(time.Minute)))
+	assert.True(t, c.add("n2", time.Now().Add(time.Minute)))
+	assert.Len(t, c.seen, 2)
+}
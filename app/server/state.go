@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+var (
+	errTaskDisabled = errors.New("task disabled")
+	errFrozen       = errors.New("deploy freeze")
+	errNoWindow     = errors.New("no maintenance window")
+)
+
+// queueCheckInterval is the interval of checking if queued jobs can run
+var queueCheckInterval = time.Second
+
+// windowError rejects the task outside of its maintenance windows
+type windowError struct {
+	next time.Time
+}
+
+func (e *windowError) Error() string {
+	return "outside of maintenance window, next window at " + e.next.Format(time.RFC3339)
+}
+
+// Freeze is a deploy freeze of the whole instance, no tasks run during the freeze
+type Freeze struct {
+	Reason string    `json:"reason,omitempty"`
+	Since  time.Time `json:"since"`
+	Until  time.Time `json:"until"` // zero means no expiration
+}
+
+// state keeps runtime state of tasks changed by admin, i.e. disabled tasks and deploy freeze.
+// The state persisted to the file if set.
+type state struct {
+	mu       sync.Mutex
+	file     string
+	Disabled map[string]string `json:"disabled"` // task name -> reason
+	Freeze   *Freeze           `json:"freeze,omitempty"`
+}
+
+// loadState reads state from the file, missing file means empty state
+func loadState(file string) (*state, error) {
+	res := &state{file: file, Disabled: map[string]string{}}
+	if file == "" {
+		return res, nil
+	}
+	data, err := os.ReadFile(file) // nolint
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return res, nil
+		}
+		return res, fmt.Errorf("can't read state: %w", err)
+	}
+	if err := json.Unmarshal(data, res); err != nil {
+		return res, fmt.Errorf("can't parse state %s: %w", file, err)
+	}
+	if res.Disabled == nil {
+		res.Disabled = map[string]string{}
+	}
+	return res, nil
+}
+
+// check returns error if the task can't run now, because it is disabled or the instance is frozen
+func (st *state) check(taskName string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if reason, ok := st.Disabled[taskName]; ok {
+		return withReason(errTaskDisabled, reason)
+	}
+	if f := st.activeFreeze(); f != nil {
+		return withReason(errFrozen, f.Reason)
+	}
+	return nil
+}
+
+// enabled returns true if the task is not disabled
+func (st *state) enabled(taskName string) bool {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, ok := st.Disabled[taskName]
+	return !ok
+}
+
+// setEnabled enables or disables the task
+func (st *state) setEnabled(taskName string, enabled bool, reason string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if enabled {
+		delete(st.Disabled, taskName)
+	} else {
+		st.Disabled[taskName] = reason
+	}
+	return st.save()
+}
+
+// freeze starts deploy freeze, zero ttl means no expiration
+func (st *state) freeze(reason string, ttl time.Duration) (Freeze, error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	f := Freeze{Reason: reason, Since: time.Now()}
+	if ttl > 0 {
+		f.Until = f.Since.Add(ttl)
+	}
+	st.Freeze = &f
+	return f, st.save()
+}
+
+// unfreeze stops deploy freeze
+func (st *state) unfreeze() error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.Freeze = nil
+	return st.save()
+}
+
+// frozen returns active deploy freeze, if any
+func (st *state) frozen() (Freeze, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if f := st.activeFreeze(); f != nil {
+		return *f, true
+	}
+	return Freeze{}, false
+}
+
+// activeFreeze returns the freeze if not expired, expired freeze removed. Must be called under lock.
+func (st *state) activeFreeze() *Freeze {
+	if st.Freeze == nil {
+		return nil
+	}
+	if !st.Freeze.Until.IsZero() && time.Now().After(st.Freeze.Until) {
+		log.Printf("[INFO] deploy freeze expired")
+		st.Freeze = nil
+		if err := st.save(); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+		return nil
+	}
+	return st.Freeze
+}
+
+// save writes state to the file, must be called under lock
+func (st *state) save() error {
+	if st.file == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return fmt.Errorf("can't marshal state: %w", err)
+	}
+	tmp := st.file + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("can't write state: %w", err)
+	}
+	if err := os.Rename(tmp, st.file); err != nil {
+		return fmt.Errorf("can't save state: %w", err)
+	}
+	return nil
+}
+
+func withReason(err error, reason string) error {
+	if reason == "" {
+		return err
+	}
+	return fmt.Errorf("%w, %s", err, reason)
+}
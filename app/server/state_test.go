@@ -0,0 +1,63 @@
+package server
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestState_Persistence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+	st, err := loadState(file)
+	require.NoError(t, err)
+	require.NoError(t, st.check("task1"))
+
+	require.NoError(t, st.setEnabled("task1", false, "incident 123"))
+	assert.EqualError(t, st.check("task1"), "task disabled, incident 123")
+	assert.False(t, st.enabled("task1"))
+	assert.True(t, st.enabled("task2"))
+	_, err = st.freeze("release", 0)
+	require.NoError(t, err)
+	assert.EqualError(t, st.check("task2"), "deploy freeze, release")
+
+	st, err = loadState(file)
+	require.NoError(t, err)
+	assert.EqualError(t, st.check("task1"), "task disabled, incident 123")
+	f, ok := st.frozen()
+	require.True(t, ok)
+	assert.Equal(t, "release", f.Reason)
+	assert.True(t, f.Until.IsZero())
+
+	require.NoError(t, st.setEnabled("task1", true, ""))
+	require.NoError(t, st.unfreeze())
+	st, err = loadState(file)
+	require.NoError(t, err)
+	require.NoError(t, st.check("task1"))
+	_, ok = st.frozen()
+	assert.False(t, ok)
+}
+
+func TestState_FreezeExpiration(t *testing.T) {
+	st, err := loadState("")
+	require.NoError(t, err)
+	f, err := st.freeze("", 50*time.Millisecond)
+	require.NoError(t, err)
+	assert.False(t, f.Until.IsZero())
+	assert.ErrorIs(t, st.check("task1"), errFrozen)
+	assert.EqualError(t, st.check("task1"), "deploy freeze")
+	time.Sleep(60 * time.Millisecond)
+	require.NoError(t, st.check("task1"))
+}
+
+func TestState_BadFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "state.json")
+	require.NoError(t, os.WriteFile(file, []byte("bad json"), 0o600))
+	st, err := loadState(file)
+	require.Error(t, err)
+	require.NotNil(t, st)
+	require.NoError(t, st.check("task1"))
+}
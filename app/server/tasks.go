@@ -0,0 +1,106 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/go-pkgz/rest"
+
+	"github.com/umputun/updater/app/task"
+)
+
+// TaskInfo describes configured task, returned by tasks api
+type TaskInfo struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Type        string            `json:"type"`
+	Command     string            `json:"command,omitempty"` // shown to admin only
+	Hosts       string            `json:"hosts,omitempty"`
+	Strategy    string            `json:"strategy,omitempty"`
+	Params      []task.Param      `json:"params,omitempty"`
+	Enabled     bool              `json:"enabled"`
+	Approval    string            `json:"approval,omitempty"`
+	OIDC        map[string]string `json:"oidc,omitempty"` // claims required from JWT bearer token
+	Concurrency TaskConcurrency   `json:"concurrency"`
+	LastRun     *Job              `json:"last_run,omitempty"`
+
+	Maintenance *task.MaintenanceParams `json:"maintenance,omitempty"`
+	NextWindow  *time.Time              `json:"next_window,omitempty"` // next allowed time if outside of windows now
+}
+
+// TaskConcurrency describes how runs of the task are limited. Tasks have no schedule of their own, they run on
+// triggers only, and maintenance windows limit the time they can run.
+type TaskConcurrency struct {
+	Limit         int           `json:"limit,omitempty"`      // max concurrent updates, shared by all tasks
+	HostBatch     int           `json:"host_batch,omitempty"` // hosts of fan-out task updated at once, all if not set
+	Timeout       time.Duration `json:"timeout,omitempty"`    // max duration of background run
+	QueueOnFreeze bool          `json:"queue_on_freeze"`      // triggers queued during deploy freeze, rejected otherwise
+}
+
+// GET /tasks returns all configured tasks
+func (s *Rest) tasksCtrl(w http.ResponseWriter, r *http.Request) {
+	res := []TaskInfo{}
+	for _, t := range s.Config.GetTasks() {
+		if !s.requestAllowed(r, t.Name) {
+			continue
+		}
+		res = append(res, s.taskInfo(t, isAdmin(r)))
+	}
+	rest.RenderJSON(w, res)
+}
+
+// GET /tasks/{name} returns task by name
+func (s *Rest) taskInfoCtrl(w http.ResponseWriter, r *http.Request) {
+	t, ok := s.Config.GetTask(r.PathValue("name"))
+	if !ok || !s.requestAllowed(r, t.Name) {
+		http.Error(w, "task not found", http.StatusNotFound)
+		return
+	}
+	rest.RenderJSON(w, s.taskInfo(t, isAdmin(r)))
+}
+
+// taskInfo makes task description, command is redacted unless requested by admin
+func (s *Rest) taskInfo(t task.Task, admin bool) TaskInfo {
+	res := TaskInfo{Name: t.Name, Description: t.Description, Type: t.Type, Hosts: t.FanOut.Hosts,
+		Strategy: t.FanOut.Strategy, Params: publicParams(t.Params), Enabled: s.getState().enabled(t.Name), Approval: t.Approval,
+		OIDC: t.OIDC, Concurrency: TaskConcurrency{Limit: s.Limit, Timeout: s.Timeout, QueueOnFreeze: s.FreezeQueue}}
+	switch t.FanOut.Strategy {
+	case task.StrategyRolling:
+		res.Concurrency.HostBatch = max(t.FanOut.Batch, 1)
+	case task.StrategyCanaryFirst:
+		res.Concurrency.HostBatch = t.FanOut.Batch
+	}
+	if res.Type == "" {
+		res.Type = task.TypeShell
+	}
+	if admin {
+		res.Command = t.Command
+	}
+	if t.Maintenance != nil {
+		res.Maintenance = t.Maintenance
+		if now := time.Now(); !t.Maintenance.Allowed(now) {
+			if next, ok := t.Maintenance.Next(now); ok {
+				res.NextWindow = &next
+			}
+		}
+	}
+	if job, ok := s.getJobs().last(t.Name); ok {
+		res.LastRun = &job
+	}
+	return res
+}
+
+// publicParams returns copy of task params with defaults of secret params blanked
+func publicParams(params []task.Param) []task.Param {
+	if params == nil {
+		return nil
+	}
+	res := make([]task.Param, len(params))
+	for i, p := range params {
+		if p.Secret {
+			p.Default = ""
+		}
+		res[i] = p
+	}
+	return res
+}
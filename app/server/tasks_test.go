@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_Tasks(t *testing.T) {
+	tasks := []task.Task{
+		{Name: "task1", Description: "first task", Command: "echo secret", Params: []task.Param{{Name: "VERSION", Required: true},
+			{Name: "TOKEN", Default: "default-token", Secret: true}, {Name: "ENV", Default: "prod"}}},
+		{Name: "compose1", Type: task.TypeCompose},
+		{Name: "web", Command: "restart", FanOut: task.FanOutParams{Hosts: "web", Strategy: task.StrategyRolling}},
+	}
+	conf := &mocks.ConfigMock{
+		GetTasksFunc: func() []task.Task { return tasks },
+		GetTaskFunc: func(name string) (task.Task, bool) {
+			for _, t := range tasks {
+				if t.Name == name {
+					return t, true
+				}
+			}
+			return task.Task{}, false
+		},
+	}
+	srv := Rest{Config: conf, SecretKey: "12345", AdminKey: "admin", Limit: 5, Timeout: time.Minute, FreezeQueue: true}
+	job := srv.getJobs().start("task1", jobSource{})
+	srv.getJobs().finish(job.ID, nil)
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	get := func(path, key string) (int, []byte) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+key)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, body
+	}
+
+	code, body := get("/tasks", "12345")
+	require.Equal(t, http.StatusOK, code)
+	res := []TaskInfo{}
+	require.NoError(t, json.Unmarshal(body, &res))
+	require.Len(t, res, 3)
+	assert.Equal(t, "task1", res[0].Name)
+	assert.Equal(t, "first task", res[0].Description)
+	assert.Equal(t, "shell", res[0].Type)
+	assert.Equal(t, "", res[0].Command, "command redacted")
+	assert.Equal(t, []task.Param{{Name: "VERSION", Required: true}, {Name: "TOKEN", Secret: true},
+		{Name: "ENV", Default: "prod"}}, res[0].Params, "default of secret param not exposed")
+	assert.Equal(t, "default-token", tasks[0].Params[1].Default, "config not changed")
+	assert.True(t, res[0].Enabled)
+	require.NotNil(t, res[0].LastRun)
+	assert.Equal(t, job.ID, res[0].LastRun.ID)
+	assert.Equal(t, JobSuccess, res[0].LastRun.Status)
+	assert.Equal(t, "compose", res[1].Type)
+	assert.Nil(t, res[1].LastRun)
+	assert.Equal(t, "web", res[2].Hosts)
+	assert.Equal(t, "rolling", res[2].Strategy)
+	assert.Equal(t, TaskConcurrency{Limit: 5, Timeout: time.Minute, QueueOnFreeze: true}, res[0].Concurrency)
+	assert.Equal(t, TaskConcurrency{Limit: 5, HostBatch: 1, Timeout: time.Minute, QueueOnFreeze: true}, res[2].Concurrency)
+	assert.NotContains(t, string(body), "echo secret")
+
+	code, body = get("/tasks", "admin")
+	require.Equal(t, http.StatusOK, code)
+	assert.Contains(t, string(body), `"command":"echo secret"`)
+
+	code, body = get("/tasks/task1", "12345")
+	require.Equal(t, http.StatusOK, code)
+	info := TaskInfo{}
+	require.NoError(t, json.Unmarshal(body, &info))
+	assert.Equal(t, "task1", info.Name)
+	assert.Equal(t, "", info.Command)
+
+	code, body = get("/tasks/task1", "admin")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal(body, &info))
+	assert.Equal(t, "echo secret", info.Command)
+
+	code, _ = get("/tasks/unknown", "12345")
+	assert.Equal(t, http.StatusNotFound, code)
+	code, _ = get("/tasks", "bad")
+	assert.Equal(t, http.StatusUnauthorized, code)
+}
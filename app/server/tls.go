@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/go-pkgz/lgr"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSOpts defines tls of the server, with certificate files or with certificates issued by ACME
+type TLSOpts struct {
+	CertFile string // certificate file, PEM
+	KeyFile  string // private key file, PEM
+
+	ACMEDomains   []string // domains to issue certificates for with ACME, i.e. Let's Encrypt
+	ACMECacheDir  string   // directory keeping issued certificates and account key
+	ACMEEmail     string   // contact email of ACME account, optional
+	ACMEDirectory string   // url of ACME directory, Let's Encrypt if empty
+
+	ClientCA string // CA bundle verifying client certificates, enables mutual tls
+}
+
+// Enabled returns true if tls is configured
+func (o TLSOpts) Enabled() bool {
+	return o.CertFile != "" || len(o.ACMEDomains) > 0
+}
+
+// config makes tls config of the server. Client certificates are verified if sent, but not required,
+// as requests can be authorized with keys as well.
+func (o TLSOpts) config() (*tls.Config, error) {
+	if o.CertFile != "" && len(o.ACMEDomains) > 0 {
+		return nil, errors.New("certificate files and acme can't be used together")
+	}
+	res := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch {
+	case o.CertFile != "":
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("can't load certificate: %w", err)
+		}
+		res.Certificates = []tls.Certificate{cert}
+	case len(o.ACMEDomains) > 0:
+		if o.ACMECacheDir == "" {
+			return nil, errors.New("acme cache directory required")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(o.ACMECacheDir),
+			HostPolicy: autocert.HostWhitelist(o.ACMEDomains...),
+			Email:      o.ACMEEmail,
+		}
+		if o.ACMEDirectory != "" {
+			m.Client = &acme.Client{DirectoryURL: o.ACMEDirectory}
+		}
+		// tls-alpn-01 challenge is answered on the same port, no plain http listener needed
+		res.GetCertificate = m.GetCertificate
+		res.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		log.Printf("[INFO] acme enabled for %v, cache %s", o.ACMEDomains, o.ACMECacheDir)
+	default:
+		return nil, errors.New("no certificate")
+	}
+
+	if o.ClientCA != "" {
+		data, err := os.ReadFile(o.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("can't read client ca: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates in client ca %s", o.ClientCA)
+		}
+		res.ClientCAs = pool
+		res.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return res, nil
+}
+
+// clientCert returns verified client certificate of the request, if any
+func clientCert(r *http.Request) (*x509.Certificate, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	return r.TLS.VerifiedChains[0][0], true
+}
+
+// certNames returns common name and all SANs of the certificate
+func certNames(cert *x509.Certificate) []string {
+	res := []string{}
+	if cert.Subject.CommonName != "" {
+		res = append(res, cert.Subject.CommonName)
+	}
+	res = append(res, cert.DNSNames...)
+	res = append(res, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		res = append(res, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		res = append(res, ip.String())
+	}
+	return res
+}
@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_RunTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "localhost", []string{"localhost"}, true)
+	writePEM(t, filepath.Join(dir, "cert.pem"), "CERTIFICATE", serverCert.Certificate[0])
+	writeKey(t, filepath.Join(dir, "key.pem"), serverCert.PrivateKey.(*ecdsa.PrivateKey))
+	writePEM(t, filepath.Join(dir, "ca.pem"), "CERTIFICATE", ca.cert.Raw)
+
+	tasks := map[string]task.Task{
+		"deploy":  {Name: "deploy", Command: "deploy.sh", ClientCerts: []string{"ci.example.com"}},
+		"migrate": {Name: "migrate", Command: "migrate.sh"},
+	}
+	conf := &mocks.ConfigMock{
+		GetTaskFunc:        func(name string) (task.Task, bool) { t, ok := tasks[name]; return t, ok },
+		GetTaskCommandFunc: func(name string) (string, bool) { t, ok := tasks[name]; return t.Command, ok },
+	}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	listen := freeAddr(t)
+	srv := Rest{Listen: listen, Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Second,
+		TLS: TLSOpts{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"),
+			ClientCA: filepath.Join(dir, "ca.pem")}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() { _ = srv.Run(ctx) }()
+
+	client := func(cert *tls.Certificate) *http.Client {
+		cfg := &tls.Config{RootCAs: ca.pool(), ServerName: "localhost", MinVersion: tls.VersionTLS12}
+		if cert != nil {
+			cfg.Certificates = []tls.Certificate{*cert}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}, Timeout: 5 * time.Second}
+	}
+	post := func(cl *http.Client, body string) (int, string) {
+		resp, err := cl.Post("https://"+listen+"/update", "application/json", strings.NewReader(body))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(b)
+	}
+
+	require.Eventually(t, func() bool {
+		resp, err := client(nil).Get("https://" + listen + "/ping")
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	}, 2*time.Second, 10*time.Millisecond)
+
+	code, body := post(client(nil), `{"task":"deploy","secret":"12345"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	code, _ = post(client(nil), `{"task":"deploy"}`)
+	assert.Equal(t, http.StatusBadRequest, code, "no secret and no certificate")
+
+	ciCert := ca.issue(t, "ci.example.com", nil, false)
+	code, body = post(client(&ciCert), `{"task":"deploy"}`)
+	assert.Equal(t, http.StatusOK, code, body)
+	code, body = post(client(&ciCert), `{"task":"migrate"}`)
+	assert.Equal(t, http.StatusForbidden, code)
+	assert.Equal(t, "task not allowed for certificate\n", body)
+
+	otherCert := ca.issue(t, "dev.example.com", []string{"dev.example.com"}, false)
+	code, _ = post(client(&otherCert), `{"task":"deploy"}`)
+	assert.Equal(t, http.StatusForbidden, code)
+
+	untrusted := newTestCA(t).issue(t, "ci.example.com", nil, false)
+	_, err := client(&untrusted).Post("https://"+listen+"/update", "application/json", strings.NewReader(`{"task":"deploy"}`))
+	require.Error(t, err, "certificate of unknown ca rejected")
+
+	assert.Equal(t, 2, len(runner.RunCalls()))
+	jobs := srv.getJobs().list()
+	require.Equal(t, 2, len(jobs))
+	resp, err := client(&ciCert).Get("https://" + listen + "/jobs/" + jobs[0].ID)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "jobs api authorized with certificate")
+}
+
+func TestTLSOpts_config(t *testing.T) {
+	dir := t.TempDir()
+	assert.False(t, TLSOpts{}.Enabled())
+	assert.True(t, TLSOpts{ACMEDomains: []string{"example.com"}}.Enabled())
+
+	cfg, err := TLSOpts{ACMEDomains: []string{"example.com"}, ACMECacheDir: dir,
+		ACMEDirectory: "https://localhost:14000/dir"}.config()
+	require.NoError(t, err)
+	assert.NotNil(t, cfg.GetCertificate)
+	assert.Contains(t, cfg.NextProtos, "acme-tls/1")
+	assert.Equal(t, tls.NoClientCert, cfg.ClientAuth)
+
+	_, err = TLSOpts{ACMEDomains: []string{"example.com"}}.config()
+	assert.EqualError(t, err, "acme cache directory required")
+	_, err = TLSOpts{CertFile: "cert.pem", ACMEDomains: []string{"example.com"}}.config()
+	assert.EqualError(t, err, "certificate files and acme can't be used together")
+	_, err = TLSOpts{CertFile: filepath.Join(dir, "no-such.pem"), KeyFile: filepath.Join(dir, "no-such.pem")}.config()
+	assert.ErrorContains(t, err, "can't load certificate")
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), []byte("not a cert"), 0o600))
+	_, err = TLSOpts{ACMEDomains: []string{"example.com"}, ACMECacheDir: dir, ClientCA: filepath.Join(dir, "ca.pem")}.config()
+	assert.ErrorContains(t, err, "no certificates in client ca")
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "test ca"},
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour), IsCA: true,
+		KeyUsage: x509.KeyUsageCertSign, BasicConstraintsValid: true}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testCA{cert: cert, key: key}
+}
+
+func (ca testCA) issue(t *testing.T, cn string, dnsNames []string, server bool) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	usage := x509.ExtKeyUsageClientAuth
+	if server {
+		usage = x509.ExtKeyUsageServerAuth
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{SerialNumber: serial, Subject: pkix.Name{CommonName: cn}, DNSNames: dnsNames,
+		NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour),
+		KeyUsage: x509.KeyUsageDigitalSignature, ExtKeyUsage: []x509.ExtKeyUsage{usage}}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func (ca testCA) pool() *x509.CertPool {
+	res := x509.NewCertPool()
+	res.AddCert(ca.cert)
+	return res
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	require.NoError(t, os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600))
+}
+
+func writeKey(t *testing.T, file string, key *ecdsa.PrivateKey) {
+	der, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	writePEM(t, file, "EC PRIVATE KEY", der)
+}
+
+func freeAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer makes spans of requests and jobs, it is no-op unless tracing set up
+var tracer = otel.Tracer("github.com/umputun/updater/app/server")
+
+// traceRequests middleware makes span of every request, except static files of the dashboard. The trace of
+// traceparent header continued if passed by the client. Requests to /ping made health check spans.
+func (s *Rest) traceRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/web/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+		path := auditPath(r.URL.Path) // key of GET /update/{task}/{key} not exposed in spans
+		name := r.Method + " " + path
+		if strings.EqualFold(r.URL.Path, "/ping") {
+			name = "health check"
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method), attribute.String("url.path", path),
+			attribute.String("client.address", s.clientIP(r))))
+		defer span.End()
+
+		sw := &auditWriter{ResponseWriter: w} // keeps status and error message
+		next.ServeHTTP(sw, r.WithContext(ctx))
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", sw.status))
+		if sw.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, strings.TrimSpace(sw.body.String()))
+		}
+	})
+}
+
+// traceAuth ends auth span with the identity of authorized request, or with error status if not authorized
+func traceAuth(span trace.Span, r *http.Request, ok bool) {
+	if ok {
+		span.SetAttributes(attribute.String("identity", auditRecord(r).Identity))
+	} else {
+		reason := auditRecord(r).Reason
+		if reason == "" {
+			reason = "rejected"
+		}
+		span.SetStatus(codes.Error, reason)
+	}
+	span.End()
+}
+
+// endSpan ends the span with error status if err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
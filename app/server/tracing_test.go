@@ -0,0 +1,174 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/umputun/updater/app/server/mocks"
+)
+
+func TestRest_Tracing(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "echo " + name, true
+	}}
+	var runSpan trace.SpanContext
+	runner := &mocks.RunnerMock{RunFunc: func(ctx context.Context, _ string, _, _ io.Writer) error {
+		runSpan = trace.SpanContextFromContext(ctx)
+		return nil
+	}}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: runner}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	traceparent, spans := tracedRequest(t)
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/update", strings.NewReader(`{"task":"task1","secret":"12345"}`))
+	require.NoError(t, err)
+	req.Header.Set("traceparent", traceparent)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	res := struct{ Job string }{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+
+	ended := spans()
+	require.Len(t, ended, 3)
+	auth, job, httpSpan := ended[0], ended[1], ended[2]
+	assert.Equal(t, "POST /update", httpSpan.Name())
+	assert.Equal(t, trace.SpanKindServer, httpSpan.SpanKind())
+	assert.Contains(t, traceparent, httpSpan.Parent().SpanID().String(), "trace of the client continued")
+	assert.Contains(t, httpSpan.Attributes(), attribute.Int("http.response.status_code", http.StatusOK))
+
+	assert.Equal(t, "auth", auth.Name())
+	assert.Equal(t, httpSpan.SpanContext().SpanID(), auth.Parent().SpanID())
+	assert.Contains(t, auth.Attributes(), attribute.String("identity", "secret"))
+
+	assert.Equal(t, "job", job.Name())
+	assert.Equal(t, httpSpan.SpanContext().SpanID(), job.Parent().SpanID())
+	assert.Contains(t, job.Attributes(), attribute.String("job.id", res.Job))
+	assert.Contains(t, job.Attributes(), attribute.String("task", "task1"))
+	assert.Equal(t, job.SpanContext(), runSpan, "runner gets context of the job span")
+
+	j, ok := srv.getJobs().get(res.Job)
+	require.True(t, ok)
+	assert.Equal(t, httpSpan.SpanContext().TraceID().String(), j.TraceID)
+}
+
+func TestRest_TracingRejected(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) {
+		return "echo " + name, true
+	}}
+	srv := Rest{Config: conf, SecretKey: "12345", Runner: &mocks.RunnerMock{}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	get := func(path string) []sdktrace.ReadOnlySpan {
+		traceparent, spans := tracedRequest(t)
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, http.NoBody)
+		require.NoError(t, err)
+		req.Header.Set("traceparent", traceparent)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return spans()
+	}
+
+	ended := get("/update/task1/bad")
+	require.Len(t, ended, 2)
+	assert.Equal(t, "auth", ended[0].Name())
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+	assert.Equal(t, "invalid key", ended[0].Status().Description)
+	assert.Equal(t, "GET /update/task1/****", ended[1].Name(), "key not exposed")
+	assert.Contains(t, ended[1].Attributes(), attribute.Int("http.response.status_code", http.StatusForbidden))
+
+	ended = get("/jobs/0123456789abcdef")
+	require.Len(t, ended, 2)
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+	assert.Equal(t, "GET /jobs/0123456789abcdef", ended[1].Name())
+
+	ended = get("/ping")
+	require.Len(t, ended, 1)
+	assert.Equal(t, "health check", ended[0].Name())
+}
+
+func TestRest_TracingQueued(t *testing.T) {
+	queueCheckInterval = 10 * time.Millisecond
+	defer func() { queueCheckInterval = time.Second }()
+
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345", FreezeQueue: true, Timeout: time.Second}
+	_, err := srv.getState().freeze("test", 0)
+	require.NoError(t, err)
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	traceparent, spans := tracedRequest(t)
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/update/task1/12345", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", traceparent)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, srv.getState().unfreeze())
+	require.Eventually(t, func() bool { return len(spans()) == 4 }, time.Second, 10*time.Millisecond)
+
+	ended := spans()
+	httpSpan := ended[1]
+	assert.Equal(t, "GET /update/task1/****", httpSpan.Name())
+	assert.Equal(t, "queue wait", ended[2].Name())
+	assert.Greater(t, ended[2].EndTime().Sub(ended[2].StartTime()), 40*time.Millisecond)
+	assert.Equal(t, "job", ended[3].Name())
+	for _, s := range ended[2:] {
+		assert.Equal(t, httpSpan.SpanContext().SpanID(), s.Parent().SpanID(), "background spans in the trace of request")
+	}
+}
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// tracedRequest makes the root span of the test, returns its traceparent header and func returning ended spans
+// of its trace. Tracer provider set once, as tracers made before it are bound to the first one.
+func tracedRequest(t *testing.T) (traceparent string, spans func() []sdktrace.ReadOnlySpan) {
+	t.Helper()
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		otel.SetTextMapPropagator(propagation.TraceContext{})
+	})
+	ctx, root := otel.Tracer("test").Start(context.Background(), t.Name())
+	t.Cleanup(func() { root.End() })
+	carrier := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent"), func() []sdktrace.ReadOnlySpan {
+		var res []sdktrace.ReadOnlySpan
+		for _, s := range recorder.Ended() {
+			if s.SpanContext().TraceID() == root.SpanContext().TraceID() {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/go-pkgz/rest"
+	"github.com/go-pkgz/routegroup"
+
+	"github.com/umputun/updater/app/keyhash"
+	"github.com/umputun/updater/app/task"
+)
+
+//go:embed web
+var webFS embed.FS
+
+var webTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"since": func(t time.Time) string { return time.Since(t).Truncate(time.Second).String() },
+	"ts":    func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	"dur":   func(d time.Duration) string { return d.Truncate(time.Millisecond).String() },
+}).ParseFS(webFS, "web/*.html"))
+
+const (
+	webSessionCookie = "updater-session"
+	webSessionTTL    = 24 * time.Hour
+)
+
+// webRoutes sets routes of the web dashboard. All pages require login with admin key,
+// or admin key passed as bearer token.
+func (s *Rest) webRoutes(router *routegroup.Bundle) {
+	static, err := fs.Sub(webFS, "web/static")
+	if err != nil {
+		log.Printf("[ERROR] can't load static files, %v", err)
+		return
+	}
+	router.HandleFiles("/static", http.FS(static))
+	router.HandleFunc("GET /login", s.webLoginPageCtrl)
+	router.HandleFunc("POST /login", s.webLoginCtrl)
+	router.HandleFunc("POST /logout", s.webLogoutCtrl)
+
+	auth := router.With(s.webAuth)
+	auth.HandleFunc("GET /{$}", s.webIndexCtrl)
+	auth.HandleFunc("GET /jobs/{id}", s.webJobCtrl)
+	auth.HandleFunc("GET /jobs/{id}/log", s.webJobLogCtrl)
+	auth.HandleFunc("GET /jobs/{id}/lines", s.webJobLinesCtrl)
+	auth.HandleFunc("POST /tasks/{task}/run", s.webRunCtrl)
+}
+
+// GET /web/ shows tasks with the last run, running jobs and history
+func (s *Rest) webIndexCtrl(w http.ResponseWriter, _ *http.Request) {
+	type taskInfo struct {
+		Name    string
+		Type    string
+		Enabled bool
+		Params  []task.Param // declared parameters, asked by the run form
+		LastJob *Job
+	}
+	tasks := []taskInfo{}
+	for _, t := range s.Config.GetTasks() {
+		ti := taskInfo{Name: t.Name, Type: t.Type, Enabled: s.getState().enabled(t.Name), Params: t.Params}
+		if ti.Type == "" {
+			ti.Type = "shell"
+		}
+		if job, ok := s.getJobs().last(t.Name); ok {
+			ti.LastJob = &job
+		}
+		tasks = append(tasks, ti)
+	}
+
+	running, history := []Job{}, []Job{}
+	for _, job := range s.getJobs().list() {
+		if job.Status == JobRunning || job.Status == JobQueued || job.Status == JobPending {
+			running = append(running, job)
+			continue
+		}
+		history = append(history, job)
+	}
+
+	data := map[string]any{"Tasks": tasks, "Running": running, "History": history}
+	if f, ok := s.getState().frozen(); ok {
+		data["Freeze"] = f
+	}
+	s.renderPage(w, http.StatusOK, "index.html", data)
+}
+
+// GET /web/jobs/{id} shows job details with live log
+func (s *Rest) webJobCtrl(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.getJobs().get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	s.renderPage(w, http.StatusOK, "job.html", map[string]any{"Job": job})
+}
+
+// GET /web/jobs/{id}/log?offset=N returns job output from offset, used by job page to follow the log
+func (s *Rest) webJobLogCtrl(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	data, next, ok := s.getJobs().logs(r.PathValue("id"), offset)
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	job, _ := s.getJobs().get(r.PathValue("id"))
+	rest.RenderJSON(w, rest.JSON{"data": string(data), "next": next, "status": job.Status, "error": job.Error})
+}
+
+// GET /web/jobs/{id}/lines?offset=N returns lines of job output from line offset, used by job page to follow the log
+func (s *Rest) webJobLinesCtrl(w http.ResponseWriter, r *http.Request) {
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	lines, next, ok := s.getJobs().lines(r.PathValue("id"), offset)
+	if !ok {
+		http.Error(w, "job not found", http.StatusNotFound)
+		return
+	}
+	job, _ := s.getJobs().get(r.PathValue("id"))
+	rest.RenderJSON(w, rest.JSON{"lines": lines, "next": next, "status": job.Status, "error": job.Error})
+}
+
+// POST /web/tasks/{task}/run triggers the task in background and redirects to the job page.
+// Trigger admitted the same way as triggers of api, so it can be queued or wait for approval.
+func (s *Rest) webRunCtrl(w http.ResponseWriter, r *http.Request) {
+	taskName := r.PathValue("task")
+	job, _, ok := s.submitTask(w, r, taskName, "web", true, s.webParams(r, taskName))
+	if !ok {
+		return
+	}
+	http.Redirect(w, r, "/web/jobs/"+job.ID, http.StatusSeeOther)
+}
+
+// webParams returns values of declared task params from the run form, empty values are skipped,
+// so defaults of params applied
+func (s *Rest) webParams(r *http.Request, taskName string) map[string]string {
+	t, ok := s.Config.GetTask(taskName)
+	if !ok {
+		return nil
+	}
+	res := map[string]string{}
+	for _, p := range t.Params {
+		if v := r.FormValue(p.Name); v != "" {
+			res[p.Name] = v
+		}
+	}
+	return res
+}
+
+// GET /web/login shows login form
+func (s *Rest) webLoginPageCtrl(w http.ResponseWriter, _ *http.Request) {
+	s.renderPage(w, http.StatusOK, "login.html", map[string]any{"Login": true})
+}
+
+// POST /web/login checks admin key and sets session cookie
+func (s *Rest) webLoginCtrl(w http.ResponseWriter, r *http.Request) {
+	if !keyhash.Match(s.AdminKey, r.FormValue("key")) {
+		s.authFailed(r, "invalid web login")
+		s.renderPage(w, http.StatusForbidden, "login.html", map[string]any{"Login": true, "Error": "invalid key"})
+		return
+	}
+	auditRecord(r).Identity = "admin"
+	expires := time.Now().Add(webSessionTTL)
+	http.SetCookie(w, &http.Cookie{Name: webSessionCookie, Value: s.sessionToken(expires), Path: "/web",
+		Expires: expires, HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+	http.Redirect(w, r, "/web/", http.StatusSeeOther)
+}
+
+// POST /web/logout removes session cookie
+func (s *Rest) webLogoutCtrl(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{Name: webSessionCookie, Value: "", Path: "/web", MaxAge: -1,
+		HttpOnly: true, Secure: r.TLS != nil, SameSite: http.SameSiteStrictMode})
+	http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+}
+
+// webAuth middleware allows requests with valid session cookie or with admin key as bearer token.
+// Unauthorized page requests redirected to login page, other requests and log polling of job page rejected with 401.
+func (s *Rest) webAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+			if keyhash.Match(s.AdminKey, token) {
+				auditRecord(r).Identity = "admin"
+				next.ServeHTTP(w, r)
+				return
+			}
+			s.authFailed(r, "invalid admin key")
+			http.Error(w, "rejected", http.StatusUnauthorized)
+			return
+		}
+		if c, err := r.Cookie(webSessionCookie); err == nil && s.validSession(c.Value) {
+			auditRecord(r).Identity = "admin"
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet || strings.HasSuffix(r.URL.Path, "/log") || strings.HasSuffix(r.URL.Path, "/lines") {
+			http.Error(w, "rejected", http.StatusUnauthorized)
+			return
+		}
+		http.Redirect(w, r, "/web/login", http.StatusSeeOther)
+	})
+}
+
+// sessionToken makes session token with expiration time, signed with admin key
+func (s *Rest) sessionToken(expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(s.AdminKey))
+	mac.Write([]byte(exp))
+	return exp + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Rest) validSession(token string) bool {
+	exp, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	ts, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil || time.Now().After(time.Unix(ts, 0)) {
+		return false
+	}
+	return hmac.Equal([]byte(token), []byte(s.sessionToken(time.Unix(ts, 0))))
+}
+
+func (s *Rest) renderPage(w http.ResponseWriter, status int, name string, data map[string]any) {
+	data["Version"] = s.Version
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := webTemplates.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("[WARN] can't render %s, %v", name, err)
+	}
+}
@@ -0,0 +1,47 @@
+// follows job log, polls the server for the new lines till the job is completed.
+// Each line shows the time since the job start, stderr lines are highlighted.
+(function () {
+  const logEl = document.getElementById("log");
+  if (!logEl) {
+    return;
+  }
+  const statusEl = document.getElementById("status");
+  const errorEl = document.getElementById("error");
+  const started = Number(logEl.dataset.started);
+  let offset = 0;
+
+  function addLine(line) {
+    const el = document.createElement("span");
+    el.className = line.stream;
+    const ts = document.createElement("span");
+    ts.className = "ts";
+    ts.textContent = "+" + ((Date.parse(line.time) - started) / 1000).toFixed(1) + "s ";
+    el.appendChild(ts);
+    el.appendChild(document.createTextNode(line.text + "\n"));
+    logEl.appendChild(el);
+  }
+
+  async function poll() {
+    try {
+      const resp = await fetch("/web/jobs/" + logEl.dataset.job + "/lines?offset=" + offset, {credentials: "same-origin"});
+      if (resp.status === 401) {
+        window.location = "/web/login"; // session expired
+        return;
+      }
+      if (!resp.ok) {
+        return;
+      }
+      const res = await resp.json();
+      res.lines.forEach(addLine);
+      offset = res.next;
+      statusEl.innerHTML = '<span class="status status-' + res.status + '">' + res.status + "</span>";
+      errorEl.textContent = res.error || "";
+      if (res.status === "running" || res.status === "queued" || res.status === "pending") {
+        setTimeout(poll, 1000);
+      }
+    } catch (e) {
+      setTimeout(poll, 5000);
+    }
+  }
+  poll();
+})();
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/umputun/updater/app/server/mocks"
+	"github.com/umputun/updater/app/task"
+)
+
+func TestRest_WebLogin(t *testing.T) {
+	srv := Rest{AdminKey: "admin-secret", Config: &mocks.ConfigMock{GetTasksFunc: func() []task.Task { return nil }}}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+	client := noRedirectClient()
+
+	resp, err := client.Get(ts.URL + "/web/")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/web/login", resp.Header.Get("Location"))
+
+	resp, err = client.Get(ts.URL + "/web/login")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = client.PostForm(ts.URL+"/web/login", url.Values{"key": {"bad"}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+
+	resp, err = client.PostForm(ts.URL+"/web/login", url.Values{"key": {"admin-secret"}})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	require.Len(t, resp.Cookies(), 1)
+	cookie := resp.Cookies()[0]
+	assert.Equal(t, webSessionCookie, cookie.Name)
+	assert.True(t, cookie.HttpOnly)
+
+	req, err := http.NewRequest(http.MethodGet, ts.URL+"/web/", http.NoBody)
+	require.NoError(t, err)
+	req.AddCookie(cookie)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	req, err = http.NewRequest(http.MethodGet, ts.URL+"/web/", http.NoBody)
+	require.NoError(t, err)
+	req.AddCookie(&http.Cookie{Name: webSessionCookie, Value: strings.Replace(cookie.Value, "a", "b", 1) + "0"})
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode, "tampered session rejected")
+}
+
+func TestRest_WebDisabled(t *testing.T) {
+	srv := Rest{}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/web/login")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestRest_WebDashboard(t *testing.T) {
+	conf := &mocks.ConfigMock{
+		GetTaskFunc:        noTask,
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, name != "unknown" },
+		GetTasksFunc: func() []task.Task {
+			return []task.Task{{Name: "task1", Command: "echo task1"}, {Name: "compose1", Type: task.TypeCompose}}
+		},
+	}
+	release := make(chan struct{})
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, cmd string, w, _ io.Writer) error {
+		_, _ = w.Write([]byte("output of " + cmd + "\n"))
+		<-release
+		return nil
+	}}
+	srv := Rest{AdminKey: "admin-secret", Config: conf, Runner: runner, SecretKey: "12345", Timeout: time.Second}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+	client := noRedirectClient()
+
+	// trigger task from dashboard
+	resp := webCall(t, client, http.MethodPost, ts.URL+"/web/tasks/task1/run")
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	jobURL := resp.Header.Get("Location")
+	require.True(t, strings.HasPrefix(jobURL, "/web/jobs/"), jobURL)
+
+	resp = webCall(t, client, http.MethodGet, ts.URL+"/web/")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "compose1")
+	assert.Contains(t, string(body), `<a href="`+jobURL+`">`)
+	assert.Contains(t, string(body), "status-running")
+
+	resp = webCall(t, client, http.MethodGet, ts.URL+jobURL)
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	close(release)
+	assert.Eventually(t, func() bool {
+		resp := webCall(t, client, http.MethodGet, ts.URL+jobURL+"/log?offset=0")
+		res := struct {
+			Data   string `json:"data"`
+			Next   int    `json:"next"`
+			Status string `json:"status"`
+		}{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+		return res.Status == JobSuccess && res.Data == "output of echo task1\n" && res.Next == len(res.Data)
+	}, 2*time.Second, 150*time.Millisecond)
+
+	resp = webCall(t, client, http.MethodPost, ts.URL+"/web/tasks/unknown/run")
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	resp = webCall(t, client, http.MethodGet, ts.URL+"/web/jobs/bad")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	// unauthorized api calls rejected
+	resp, err = client.Post(ts.URL+"/web/tasks/task1/run", "", http.NoBody)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Equal(t, 1, len(runner.RunCalls()))
+	for _, path := range []string{jobURL + "/log?offset=0", jobURL + "/lines?offset=0"} {
+		resp, err = client.Get(ts.URL + path)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode, "polled by job page, not redirected to login page")
+	}
+	resp, err = client.Get(ts.URL + jobURL)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+}
+
+func TestRest_WebRunForm(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTasksFunc: func() []task.Task {
+		return []task.Task{{Name: "deploy", Params: []task.Param{
+			{Name: "VERSION", Required: true, Description: "image tag"},
+			{Name: "ENV", Default: "prod"},
+			{Name: "TOKEN", Default: "secret-default", Secret: true},
+		}}}
+	}}
+	srv := Rest{AdminKey: "admin-secret", Config: conf}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp := webCall(t, noRedirectClient(), http.MethodGet, ts.URL+"/web/")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `<form method="post" action="/web/tasks/deploy/run" class="run">`)
+	assert.Contains(t, string(body), `<input name="VERSION" placeholder="VERSION" title="image tag" value="" required>`)
+	assert.Contains(t, string(body), `<input name="ENV" placeholder="ENV" value="prod">`)
+	assert.Contains(t, string(body), `<input name="TOKEN" placeholder="TOKEN" type="password">`)
+	assert.NotContains(t, string(body), "secret-default", "default of secret param not exposed")
+}
+
+func TestRest_WebRunParams(t *testing.T) {
+	deploy := task.Task{Name: "deploy", Params: []task.Param{{Name: "VERSION", Required: true}, {Name: "ENV", Default: "prod"}}}
+	conf := &mocks.ConfigMock{
+		GetTaskFunc:        func(name string) (task.Task, bool) { return deploy, name == "deploy" },
+		GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true },
+	}
+	params := make(chan map[string]string, 1)
+	runner := &mocks.RunnerMock{RunFunc: func(ctx context.Context, _ string, _, _ io.Writer) error {
+		params <- task.ParamsFromContext(ctx)
+		return nil
+	}}
+	srv := Rest{AdminKey: "admin-secret", Config: conf, Runner: runner, Timeout: time.Second}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	run := func(form url.Values) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/web/tasks/deploy/run", strings.NewReader(form.Encode()))
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Authorization", "Bearer admin-secret")
+		resp, err := noRedirectClient().Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { _ = resp.Body.Close() })
+		return resp
+	}
+
+	resp := run(url.Values{"VERSION": {"1.2.3"}, "ENV": {""}, "OTHER": {"ignored"}})
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	select {
+	case p := <-params:
+		assert.Equal(t, map[string]string{"VERSION": "1.2.3", "ENV": "prod"}, p)
+	case <-time.After(time.Second):
+		t.Fatal("task not started")
+	}
+
+	resp = run(url.Values{"ENV": {"stage"}})
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "required param missing")
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "parameter VERSION required\n", string(body))
+}
+
+func TestRest_WebStatic(t *testing.T) {
+	srv := Rest{AdminKey: "admin-secret"}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+	resp, err := http.Get(ts.URL + "/web/static/job.js")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestRest_JobInResponse(t *testing.T) {
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(_ context.Context, _ string, w, _ io.Writer) error {
+		_, _ = w.Write([]byte("done\n"))
+		return nil
+	}}
+	srv := Rest{Config: conf, Runner: runner, SecretKey: "12345"}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/update/task1/12345")
+	require.NoError(t, err)
+	res := struct {
+		Job string `json:"job"`
+	}{}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&res))
+	job, ok := srv.getJobs().get(res.Job)
+	require.True(t, ok)
+	assert.Equal(t, JobSuccess, job.Status)
+	data, _, _ := srv.getJobs().logs(res.Job, 0)
+	assert.Equal(t, "done\n", string(data))
+}
+
+func noRedirectClient() *http.Client {
+	return &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+}
+
+func webCall(t *testing.T, client *http.Client, method, u string) *http.Response {
+	req, err := http.NewRequest(method, u, http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer admin-secret")
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	return resp
+}
+
+func TestRest_WebRunFreezeQueue(t *testing.T) {
+	queueCheckInterval = 10 * time.Millisecond
+	defer func() { queueCheckInterval = time.Second }()
+
+	conf := &mocks.ConfigMock{GetTaskFunc: noTask, GetTaskCommandFunc: func(name string) (string, bool) { return "echo " + name, true }}
+	runner := &mocks.RunnerMock{RunFunc: func(context.Context, string, io.Writer, io.Writer) error { return nil }}
+	srv := Rest{Config: conf, Runner: runner, AdminKey: "admin", FreezeQueue: true, Timeout: time.Second}
+	ts := httptest.NewServer(srv.router())
+	defer ts.Close()
+
+	code, _ := adminCall(t, ts.URL+"/admin/freeze", http.MethodPost, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/web/tasks/task1/run", http.NoBody)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer admin")
+	resp, err := noRedirectClient().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusSeeOther, resp.StatusCode, "queued during freeze, not rejected")
+	jobID := strings.TrimPrefix(resp.Header.Get("Location"), "/web/jobs/")
+
+	time.Sleep(50 * time.Millisecond)
+	job, ok := srv.getJobs().get(jobID)
+	require.True(t, ok)
+	assert.Equal(t, JobQueued, job.Status)
+	assert.Equal(t, "web", job.Trigger)
+	assert.Equal(t, 0, len(runner.RunCalls()))
+
+	code, _ = adminCall(t, ts.URL+"/admin/freeze", http.MethodDelete, "admin", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Eventually(t, func() bool {
+		job, _ := srv.getJobs().get(jobID)
+		return job.Status == JobSuccess
+	}, time.Second, 10*time.Millisecond)
+}
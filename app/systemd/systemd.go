@@ -0,0 +1,112 @@
+// Package systemd implements socket activation and service notifications of systemd, without libsystemd.
+// All functions are no-op if updater is not started by systemd.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+)
+
+// notification states
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+const listenFdsStart = 3 // first passed file descriptor, SD_LISTEN_FDS_START
+
+// Listeners returns listeners passed by systemd socket activation, empty if not activated.
+// Environment variables of activation are removed, so child processes don't inherit them.
+func Listeners() ([]net.Listener, error) {
+	defer func() {
+		_ = os.Unsetenv("LISTEN_PID")
+		_ = os.Unsetenv("LISTEN_FDS")
+		_ = os.Unsetenv("LISTEN_FDNAMES")
+	}()
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	res := make([]net.Listener, 0, count)
+	for fd := listenFdsStart; fd < listenFdsStart+count; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		_ = f.Close() // FileListener duplicates the descriptor with close-on-exec, the inherited one is closed
+		if err != nil {
+			return nil, fmt.Errorf("can't make listener from fd %d: %w", fd, err)
+		}
+		res = append(res, l)
+	}
+	return res, nil
+}
+
+// Notify sends state to systemd, i.e. Ready. Returns false if notifications not supported.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("can't connect to notify socket: %w", err)
+	}
+	defer conn.Close() //nolint
+	if _, err = conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("can't send %s: %w", state, err)
+	}
+	return true, nil
+}
+
+// ExtendTimeout returns state asking systemd to extend the start or stop timeout of the service for d,
+// sent along with Stopping to let the shutdown complete.
+func ExtendTimeout(d time.Duration) string {
+	return "EXTEND_TIMEOUT_USEC=" + strconv.FormatInt(d.Microseconds(), 10)
+}
+
+// WatchdogInterval returns watchdog timeout set by systemd for the service, false if watchdog not enabled
+func WatchdogInterval() (time.Duration, bool) {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0, false
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0, false
+	}
+	return time.Duration(usec) * time.Microsecond, true
+}
+
+// RunWatchdog sends watchdog keep-alive notifications twice per watchdog timeout, until context canceled.
+// Returns immediately if watchdog not enabled.
+func RunWatchdog(ctx context.Context) {
+	interval, ok := WatchdogInterval()
+	if !ok {
+		return
+	}
+	log.Printf("[DEBUG] systemd watchdog enabled, %v", interval)
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := Notify(Watchdog); err != nil {
+				log.Printf("[WARN] watchdog notification failed, %v", err)
+			}
+		}
+	}
+}
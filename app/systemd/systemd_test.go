@@ -0,0 +1,130 @@
+package systemd
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := Notify(Ready)
+	require.NoError(t, err)
+	assert.False(t, ok, "not started by systemd")
+
+	sock := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sock)
+
+	ok, err = Notify(Ready)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "READY=1", string(buf[:n]))
+
+	assert.Equal(t, "EXTEND_TIMEOUT_USEC=90000000", ExtendTimeout(90*time.Second))
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "no-such.sock"))
+	_, err = Notify(Ready)
+	assert.Error(t, err)
+}
+
+func TestRunWatchdog(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: sock, Net: "unixgram"})
+	require.NoError(t, err)
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", sock)
+	t.Setenv("WATCHDOG_USEC", "40000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+
+	interval, ok := WatchdogInterval()
+	require.True(t, ok)
+	assert.Equal(t, 40*time.Millisecond, interval)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunWatchdog(ctx)
+		close(done)
+	}()
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "WATCHDOG=1", string(buf[:n]))
+	cancel()
+	<-done
+
+	t.Setenv("WATCHDOG_PID", "1")
+	_, ok = WatchdogInterval()
+	assert.False(t, ok, "watchdog of other process")
+	t.Setenv("WATCHDOG_USEC", "")
+	_, ok = WatchdogInterval()
+	assert.False(t, ok)
+}
+
+func TestListeners(t *testing.T) {
+	t.Setenv("LISTEN_PID", "")
+	t.Setenv("LISTEN_FDS", "")
+	res, err := Listeners()
+	require.NoError(t, err)
+	assert.Empty(t, res, "not activated")
+
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	res, err = Listeners()
+	require.NoError(t, err)
+	assert.Empty(t, res, "activated for other process")
+	_, found := os.LookupEnv("LISTEN_FDS")
+	assert.False(t, found, "activation variables removed")
+}
+
+func TestListeners_Activated(t *testing.T) {
+	if os.Getenv("TEST_SYSTEMD_CHILD") == "1" {
+		// child process, gets the listener as fd 3 like from systemd
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		res, err := Listeners()
+		require.NoError(t, err)
+		require.Equal(t, 1, len(res))
+		conn, err := res[0].Accept()
+		require.NoError(t, err)
+		_, err = conn.Write([]byte("activated"))
+		require.NoError(t, err)
+		conn.Close()
+		return
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f, err := l.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer f.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestListeners_Activated$") //nolint:gosec // test binary
+	cmd.Env = append(os.Environ(), "TEST_SYSTEMD_CHILD=1", "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{f}
+	require.NoError(t, cmd.Start())
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	l.Close() // only the child accepts now
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "activated", string(buf[:n]))
+	require.NoError(t, cmd.Wait())
+}
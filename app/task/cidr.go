@@ -0,0 +1,59 @@
+package task
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+)
+
+// ParseCIDRs parses list of networks, i.e. 10.0.0.0/8, single addresses allowed as well
+func ParseCIDRs(vals []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(vals))
+	for _, v := range vals {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		if !strings.Contains(v, "/") {
+			addr, err := netip.ParseAddr(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %q: %w", v, err)
+			}
+			res = append(res, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", v, err)
+		}
+		res = append(res, p.Masked())
+	}
+	return res, nil
+}
+
+// IPAllowed checks ip against allowed and denied networks. Denied networks take precedence,
+// any ip not denied is allowed if allowed networks are empty.
+func IPAllowed(ip netip.Addr, allow, deny []netip.Prefix) bool {
+	ip = ip.Unmap()
+	for _, p := range deny {
+		if p.Contains(ip) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, p := range allow {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsIP returns true if the task can be triggered from ip, according to allow_cidrs and deny_cidrs of the task
+func (t Task) AllowsIP(ip netip.Addr) bool {
+	allow, _ := ParseCIDRs(t.AllowCIDRs) // validated on config load
+	deny, _ := ParseCIDRs(t.DenyCIDRs)
+	return IPAllowed(ip, allow, deny)
+}
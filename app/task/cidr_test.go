@@ -0,0 +1,43 @@
+package task
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCIDRs(t *testing.T) {
+	res, err := ParseCIDRs([]string{"10.0.0.0/8", " 192.168.1.5 ", "", "2001:db8::/32", "10.1.2.3/16"})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("192.168.1.5/32"),
+		netip.MustParsePrefix("2001:db8::/32"), netip.MustParsePrefix("10.1.0.0/16")}, res)
+
+	_, err = ParseCIDRs([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseCIDRs([]string{"bad"})
+	assert.Error(t, err)
+}
+
+func TestTask_AllowsIP(t *testing.T) {
+	tbl := []struct {
+		allow, deny []string
+		ip          string
+		res         bool
+	}{
+		{nil, nil, "1.2.3.4", true},
+		{[]string{"10.0.0.0/8"}, nil, "10.1.2.3", true},
+		{[]string{"10.0.0.0/8"}, nil, "1.2.3.4", false},
+		{[]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.1.2.3", false},
+		{[]string{"10.0.0.0/8"}, []string{"10.1.0.0/16"}, "10.2.2.3", true},
+		{nil, []string{"1.2.3.4"}, "1.2.3.4", false},
+		{nil, []string{"1.2.3.4"}, "1.2.3.5", true},
+		{[]string{"10.0.0.0/8"}, nil, "::ffff:10.0.0.1", true},
+		{[]string{"2001:db8::/32"}, nil, "2001:db8::1", true},
+	}
+	for _, tt := range tbl {
+		tsk := Task{Name: "t", AllowCIDRs: tt.allow, DenyCIDRs: tt.deny}
+		assert.Equal(t, tt.res, tsk.AllowsIP(netip.MustParseAddr(tt.ip)), "%+v", tt)
+	}
+}
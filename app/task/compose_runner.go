@@ -0,0 +1,120 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ComposeParams defines options of compose task
+type ComposeParams struct {
+	ProjectDir    string   `yaml:"project_dir"`
+	Files         []string `yaml:"files"`
+	Services      []string `yaml:"services"`
+	Pull          bool     `yaml:"pull"`
+	RemoveOrphans bool     `yaml:"remove_orphans"`
+	Wait          bool     `yaml:"wait"`
+}
+
+// ComposeRunner updates docker compose services, i.e. pulls images and recreates containers.
+// Each service updated separately and reported with its own result line.
+type ComposeRunner struct {
+	Command string // compose invocation, i.e. "docker compose" or "docker-compose"
+	Params  ComposeParams
+	Limiter sync.Locker
+}
+
+// Run updates compose services. The command is ignored, the invocation is made from compose params.
+func (c *ComposeRunner) Run(ctx context.Context, _ string, stdout, stderr io.Writer) error {
+	if c.Limiter != nil {
+		c.Limiter.Lock()
+		defer c.Limiter.Unlock()
+	}
+
+	services := c.Params.Services
+	if len(services) == 0 {
+		services = []string{""} // empty service means all services of the project
+	}
+
+	errs := new(multierror.Error)
+	for _, svc := range services {
+		name := svc
+		if name == "" {
+			name = "all services"
+		}
+		started := time.Now()
+		err := c.update(ctx, svc, stdout, stderr)
+		stepDone(ctx, "compose "+name, started, err)
+		if err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("service %s: %w", name, err))
+			_, _ = fmt.Fprintf(stderr, "compose: %s failed, %v\n", name, err)
+			continue
+		}
+		_, _ = fmt.Fprintf(stdout, "compose: %s updated\n", name)
+	}
+	return errs.ErrorOrNil()
+}
+
+func (c *ComposeRunner) update(ctx context.Context, svc string, stdout, stderr io.Writer) error {
+	if c.Params.Pull {
+		if err := c.exec(ctx, stdout, stderr, c.args("pull", svc)...); err != nil {
+			return fmt.Errorf("pull: %w", err)
+		}
+	}
+	if err := c.exec(ctx, stdout, stderr, c.args("up", svc)...); err != nil {
+		return fmt.Errorf("up: %w", err)
+	}
+	return nil
+}
+
+// args makes compose arguments for the given sub-command and service
+func (c *ComposeRunner) args(subCmd, svc string) []string {
+	res := strings.Fields(c.Command)
+	if len(res) == 0 {
+		res = []string{"docker", "compose"}
+	}
+	if c.Params.ProjectDir != "" {
+		res = append(res, "--project-directory", c.Params.ProjectDir)
+	}
+	for _, f := range c.Params.Files {
+		res = append(res, "-f", f)
+	}
+	res = append(res, subCmd)
+	if subCmd == "up" {
+		res = append(res, "-d")
+		if c.Params.RemoveOrphans {
+			res = append(res, "--remove-orphans")
+		}
+		if c.Params.Wait {
+			res = append(res, "--wait")
+		}
+	}
+	if svc != "" {
+		res = append(res, svc)
+	}
+	return res
+}
+
+func (c *ComposeRunner) exec(ctx context.Context, stdout, stderr io.Writer, args ...string) (err error) {
+	log.Printf("[INFO] execute %q", strings.Join(args, " "))
+	ctx, span := tracer.Start(ctx, "command", trace.WithAttributes(attribute.String("command", strings.Join(args, " "))))
+	defer func() { endSpan(span, err) }()
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...) // nolint
+	cmd.Dir = c.Params.ProjectDir
+	if env := paramsEnv(ctx); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
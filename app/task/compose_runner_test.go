@@ -0,0 +1,65 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeRunner_Run(t *testing.T) {
+	cr := ComposeRunner{Command: "testdata/fake-compose.sh", Params: ComposeParams{Files: []string{"c1.yml", "c2.yml"},
+		Services: []string{"web", "worker"}, Pull: true, RemoveOrphans: true, Wait: true}}
+
+	lw := bytes.NewBuffer(nil)
+	err := cr.Run(context.Background(), "", lw, lw)
+	require.NoError(t, err)
+	t.Log(lw.String())
+	assert.Equal(t, "compose -f c1.yml -f c2.yml pull web\n"+
+		"compose -f c1.yml -f c2.yml up -d --remove-orphans --wait web\n"+
+		"compose: web updated\n"+
+		"compose -f c1.yml -f c2.yml pull worker\n"+
+		"compose -f c1.yml -f c2.yml up -d --remove-orphans --wait worker\n"+
+		"compose: worker updated\n", lw.String())
+}
+
+func TestComposeRunner_RunAllServices(t *testing.T) {
+	cr := ComposeRunner{Command: "testdata/fake-compose.sh"}
+	lw := bytes.NewBuffer(nil)
+	err := cr.Run(context.Background(), "", lw, lw)
+	require.NoError(t, err)
+	assert.Equal(t, "compose up -d\ncompose: all services updated\n", lw.String())
+}
+
+func TestComposeRunner_RunFailedService(t *testing.T) {
+	cr := ComposeRunner{Command: "testdata/fake-compose.sh", Params: ComposeParams{Services: []string{"bad", "web"}, Pull: true}}
+	lw := bytes.NewBuffer(nil)
+	err := cr.Run(context.Background(), "", lw, lw)
+	require.Error(t, err)
+	t.Log(lw.String())
+	assert.Contains(t, err.Error(), "service bad: pull: exit status 1")
+	assert.NotContains(t, err.Error(), "service web")
+	assert.Contains(t, lw.String(), "can't update bad\n")
+	assert.Contains(t, lw.String(), "compose: bad failed, pull: exit status 1\n")
+	assert.Contains(t, lw.String(), "compose: web updated\n")
+}
+
+func TestComposeRunner_RunStreams(t *testing.T) {
+	cr := ComposeRunner{Command: "testdata/fake-compose.sh", Params: ComposeParams{Services: []string{"bad", "web"}}}
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	err := cr.Run(context.Background(), "", stdout, stderr)
+	require.Error(t, err)
+	assert.Equal(t, "compose up -d bad\ncompose up -d web\ncompose: web updated\n", stdout.String())
+	assert.Equal(t, "can't update bad\ncompose: bad failed, up: exit status 1\n", stderr.String())
+}
+
+func TestComposeRunner_args(t *testing.T) {
+	cr := ComposeRunner{Params: ComposeParams{ProjectDir: "/srv", Files: []string{"c.yml"}}}
+	assert.Equal(t, []string{"docker", "compose", "--project-directory", "/srv", "-f", "c.yml", "pull", "web"}, cr.args("pull", "web"))
+	assert.Equal(t, []string{"docker", "compose", "--project-directory", "/srv", "-f", "c.yml", "up", "-d"}, cr.args("up", ""))
+
+	cr = ComposeRunner{Command: "docker-compose"}
+	assert.Equal(t, []string{"docker-compose", "up", "-d", "web"}, cr.args("up", "web"))
+}
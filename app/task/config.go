@@ -1,21 +1,105 @@
 package task
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
+
+	"github.com/umputun/updater/app/mask"
 )
 
 // Config defiles list of tasks
 type Config struct {
-	Tasks []struct {
-		Name    string `yaml:"name"`
-		Command string `yaml:"command"`
-	} `yaml:"tasks"`
+	Hosts map[string][]Host `yaml:"hosts"` // named groups of hosts
+	Tasks []Task            `yaml:"tasks"`
+
+	AllowCIDRs []string `yaml:"allow_cidrs"` // networks allowed to trigger any task, any if empty
+	DenyCIDRs  []string `yaml:"deny_cidrs"`  // networks not allowed to trigger any task
+
+	Secrets []Secret   `yaml:"secrets"` // secrets passed to tasks declaring them
+	Mask    MaskParams `yaml:"mask"`    // secrets masked in the output of tasks
+}
+
+// MaskParams defines secrets masked in the output of tasks, in addition to values of secret parameters
+type MaskParams struct {
+	Values   []string `yaml:"values"`   // secret values
+	Env      []string `yaml:"env"`      // environment variables with secret values
+	Patterns []string `yaml:"patterns"` // regex patterns, only groups masked if the pattern has them
+}
+
+// Task defines a single named task
+type Task struct {
+	Name        string        `yaml:"name"`
+	Description string        `yaml:"description"`
+	Command     string        `yaml:"command"`
+	Type        string        `yaml:"type"` // shell (default) or compose
+	Compose     ComposeParams `yaml:",inline"`
+	SSH         *SSHParams    `yaml:"ssh"` // execute command on remote host
+	FanOut      FanOutParams  `yaml:",inline"`
+	Params      []Param       `yaml:"params"`  // declared parameters, passed as environment variables
+	Key         string        `yaml:"key"`     // task key, plain or bcrypt/argon2id hash, accepted in addition to the secret key
+	Secrets     []string      `yaml:"secrets"` // names of secrets passed as environment variables
+
+	Maintenance *MaintenanceParams `yaml:"maintenance"` // allowed windows and blackouts, any time if not set
+
+	Approval        string        `yaml:"approval"`         // "required" makes the task wait for manual approval
+	ApprovalTimeout time.Duration `yaml:"approval_timeout"` // pending approval expires after, default 1h
+
+	OIDC map[string]string `yaml:"oidc"` // claims required from JWT bearer token, values are glob patterns
+
+	AllowCIDRs []string `yaml:"allow_cidrs"` // networks allowed to trigger the task, checked after global ones
+	DenyCIDRs  []string `yaml:"deny_cidrs"`  // networks not allowed to trigger the task
+
+	ClientCerts []string `yaml:"client_certs"` // CN or SAN of client certificates allowed to trigger the task, glob patterns
+}
+
+// ApprovalRequired returns true if the task runs only after manual approval
+func (t Task) ApprovalRequired() bool {
+	return t.Approval == ApprovalRequired
+}
+
+// AllowsClaims returns true if token claims match all oidc claims of the task.
+// Tasks without oidc claims can't be triggered with tokens.
+func (t Task) AllowsClaims(claim func(name string) string) bool {
+	if len(t.OIDC) == 0 {
+		return false
+	}
+	for name, pattern := range t.OIDC {
+		if ok, err := path.Match(pattern, claim(name)); err != nil || !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// AllowsCert returns true if any of the names, common name or SANs of client certificate, matches client_certs
+// of the task. Tasks without client_certs can't be triggered with client certificates.
+func (t Task) AllowsCert(names []string) bool {
+	for _, pattern := range t.ClientCerts {
+		for _, name := range names {
+			if ok, err := path.Match(pattern, name); err == nil && ok {
+				return true
+			}
+		}
+	}
+	return false
 }
 
+// task types
+const (
+	TypeShell   = "shell"
+	TypeCompose = "compose"
+)
+
+// ApprovalRequired is the value of task approval requiring manual approval
+const ApprovalRequired = "required"
+
 // LoadConfig reads and parses yaml config
 func LoadConfig(file string) (*Config, error) {
 	fh, err := os.Open(file) //nolint
@@ -28,15 +112,172 @@ func LoadConfig(file string) (*Config, error) {
 	if err := yaml.NewDecoder(fh).Decode(&res); err != nil {
 		return nil, fmt.Errorf("can't parse config: %w", err)
 	}
+	if err := res.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	// compose runs in the project dir and gets it as --project-directory, so relative dir made absolute
+	// to be resolved once, against the working directory of updater
+	for i, t := range res.Tasks {
+		if t.Compose.ProjectDir == "" || filepath.IsAbs(t.Compose.ProjectDir) {
+			continue
+		}
+		dir, err := filepath.Abs(t.Compose.ProjectDir)
+		if err != nil {
+			return nil, fmt.Errorf("task %s: can't make absolute project dir: %w", t.Name, err)
+		}
+		res.Tasks[i].Compose.ProjectDir = dir
+	}
 	return &res, nil
 }
 
 // GetTaskCommand retrieves the command for given task name
 func (c *Config) GetTaskCommand(name string) (command string, ok bool) {
+	t, ok := c.GetTask(name)
+	return t.Command, ok
+}
+
+// GetTasks returns all tasks
+func (c *Config) GetTasks() []Task {
+	return c.Tasks
+}
+
+// GetTask retrieves the task for given name
+func (c *Config) GetTask(name string) (Task, bool) {
 	for _, t := range c.Tasks {
 		if strings.EqualFold(name, t.Name) {
-			return t.Command, true
+			return t, true
+		}
+	}
+	return Task{}, false
+}
+
+// Masker makes masker of secrets defined in mask section, values of environment variables taken on call
+func (c *Config) Masker() (*mask.Masker, error) {
+	res, err := mask.New(c.Mask.Patterns...)
+	if err != nil {
+		return nil, err
+	}
+	res.Add(c.Mask.Values...)
+	for _, name := range c.Mask.Env {
+		res.Add(os.Getenv(name))
+	}
+	return res, nil
+}
+
+func (c *Config) validate() error {
+	if err := validateCIDRs(c.AllowCIDRs, c.DenyCIDRs); err != nil {
+		return err
+	}
+	if _, err := mask.New(c.Mask.Patterns...); err != nil {
+		return err
+	}
+	secrets := map[string]bool{}
+	for _, s := range c.Secrets {
+		if err := s.validate(); err != nil {
+			return err
+		}
+		if secrets[s.Name] {
+			return fmt.Errorf("secret %s defined twice", s.Name)
+		}
+		secrets[s.Name] = true
+	}
+	for group, hosts := range c.Hosts {
+		if len(hosts) == 0 {
+			return fmt.Errorf("hosts group %s is empty", group)
+		}
+		for _, h := range hosts {
+			if err := h.validate(); err != nil {
+				return fmt.Errorf("hosts group %s: %w", group, err)
+			}
+		}
+	}
+
+	for _, t := range c.Tasks {
+		for _, p := range t.Params {
+			if err := p.validate(); err != nil {
+				return fmt.Errorf("task %s: %w", t.Name, err)
+			}
+		}
+		for _, name := range t.Secrets {
+			if !secrets[name] {
+				return fmt.Errorf("task %s: unknown secret %s", t.Name, name)
+			}
+			if t.hasParam(name) {
+				return fmt.Errorf("task %s: secret %s conflicts with parameter", t.Name, name)
+			}
 		}
+		if t.Maintenance != nil {
+			if err := t.Maintenance.validate(); err != nil {
+				return fmt.Errorf("task %s: %w", t.Name, err)
+			}
+		}
+		if err := validateCIDRs(t.AllowCIDRs, t.DenyCIDRs); err != nil {
+			return fmt.Errorf("task %s: %w", t.Name, err)
+		}
+		for _, pattern := range t.ClientCerts {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("task %s: invalid client cert %s: %w", t.Name, pattern, err)
+			}
+		}
+		for name, pattern := range t.OIDC {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("task %s: invalid oidc claim %s: %w", t.Name, name, err)
+			}
+		}
+		if t.Approval != "" && t.Approval != ApprovalRequired {
+			return fmt.Errorf("task %s: unknown approval %q", t.Name, t.Approval)
+		}
+		switch t.Type {
+		case "", TypeShell:
+			if t.SSH != nil {
+				if err := t.SSH.validate(); err != nil {
+					return fmt.Errorf("task %s: %w", t.Name, err)
+				}
+			}
+		case TypeCompose:
+			if t.Command != "" {
+				return fmt.Errorf("task %s: compose task can't have command", t.Name)
+			}
+			if t.SSH != nil || t.FanOut.Hosts != "" {
+				return fmt.Errorf("task %s: compose task can't run on remote hosts", t.Name)
+			}
+		default:
+			return fmt.Errorf("task %s: unknown type %q", t.Name, t.Type)
+		}
+		if err := c.validateFanOut(t); err != nil {
+			return fmt.Errorf("task %s: %w", t.Name, err)
+		}
+	}
+	return nil
+}
+
+func validateCIDRs(allow, deny []string) error {
+	if _, err := ParseCIDRs(allow); err != nil {
+		return fmt.Errorf("allow_cidrs: %w", err)
+	}
+	if _, err := ParseCIDRs(deny); err != nil {
+		return fmt.Errorf("deny_cidrs: %w", err)
+	}
+	return nil
+}
+
+func (c *Config) validateFanOut(t Task) error {
+	if t.FanOut.Hosts == "" {
+		if t.FanOut.Strategy != "" {
+			return errors.New("strategy requires hosts")
+		}
+		return nil
+	}
+	if t.SSH != nil {
+		return errors.New("ssh and hosts can't be used together")
+	}
+	if _, ok := c.Hosts[t.FanOut.Hosts]; !ok {
+		return fmt.Errorf("unknown hosts group %s", t.FanOut.Hosts)
+	}
+	switch t.FanOut.Strategy {
+	case "", StrategyAllParallel, StrategyRolling, StrategyCanaryFirst:
+	default:
+		return fmt.Errorf("unknown strategy %q", t.FanOut.Strategy)
 	}
-	return "", false
+	return nil
 }
@@ -1,7 +1,10 @@
 package task
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -35,3 +38,139 @@ func TestConfig_GetTaskCommand(t *testing.T) {
 	_, ok = c.GetTaskCommand("bad-task")
 	require.False(t, ok)
 }
+
+func TestLoadConfig_Compose(t *testing.T) {
+	c, err := LoadConfig("testdata/compose.yml")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(c.Tasks))
+
+	tsk, ok := c.GetTask("compose1")
+	require.True(t, ok)
+	assert.Equal(t, TypeCompose, tsk.Type)
+	assert.Equal(t, ComposeParams{ProjectDir: "/srv", Files: []string{"docker-compose.yml", "docker-compose.prod.yml"},
+		Services: []string{"web", "worker"}, Pull: true, RemoveOrphans: true, Wait: true}, tsk.Compose)
+
+	tsk, ok = c.GetTask("compose2")
+	require.True(t, ok)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(wd, "deploy", "app"), tsk.Compose.ProjectDir, "relative project dir made absolute")
+
+	tsk, ok = c.GetTask("shell1")
+	require.True(t, ok)
+	assert.Equal(t, "", tsk.Type)
+	assert.Equal(t, ComposeParams{}, tsk.Compose)
+
+	_, err = LoadConfig("testdata/bad-type.yml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `unknown type "blah"`)
+}
+
+func TestLoadConfig_SSH(t *testing.T) {
+	c, err := LoadConfig("testdata/ssh.yml")
+	require.NoError(t, err)
+
+	tsk, ok := c.GetTask("remote1")
+	require.True(t, ok)
+	require.NotNil(t, tsk.SSH)
+	assert.Equal(t, SSHParams{Host: "10.0.0.1", Port: 2222, User: "app", KeyFile: "/home/app/.ssh/id_ed25519",
+		Fingerprint: "SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+		Jump:        &SSHParams{Host: "bastion.example.com", KnownHosts: "/home/app/.ssh/known_hosts"}}, *tsk.SSH)
+
+	tsk, ok = c.GetTask("local1")
+	require.True(t, ok)
+	assert.Nil(t, tsk.SSH)
+
+	_, err = LoadConfig("testdata/bad-ssh.yml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "task remote1: ssh known_hosts or fingerprint required")
+}
+
+func TestLoadConfig_Hosts(t *testing.T) {
+	c, err := LoadConfig("testdata/hosts.yml")
+	require.NoError(t, err)
+	require.Equal(t, 3, len(c.Hosts["web"]))
+	assert.Equal(t, "web1", c.Hosts["web"][0].Name)
+	assert.Equal(t, "10.0.0.1", c.Hosts["web"][0].SSH.Host)
+	assert.Equal(t, &RemoteParams{URL: "https://web3.example.com", Key: "secret"}, c.Hosts["web"][2].Updater)
+
+	tsk, ok := c.GetTask("deploy-web")
+	require.True(t, ok)
+	assert.Equal(t, FanOutParams{Hosts: "web", Strategy: StrategyRolling, Batch: 2, Pause: 10 * time.Second}, tsk.FanOut)
+
+	_, err = LoadConfig("testdata/bad-hosts.yml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "task deploy-web: unknown hosts group db")
+}
+
+func TestLoadConfig_Params(t *testing.T) {
+	_, err := LoadConfig("testdata/params.yml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `task bad: invalid parameter name "bad-name"`)
+
+	c := Config{Tasks: []Task{{Name: "deploy", Command: "deploy.sh", Params: []Param{{Name: "VERSION", Required: true}}}}}
+	require.NoError(t, c.validate())
+}
+
+func TestConfig_validateApproval(t *testing.T) {
+	c := Config{Tasks: []Task{{Name: "migrate", Command: "migrate.sh", Approval: ApprovalRequired, ApprovalTimeout: time.Hour}}}
+	require.NoError(t, c.validate())
+	assert.True(t, c.Tasks[0].ApprovalRequired())
+
+	c = Config{Tasks: []Task{{Name: "migrate", Command: "migrate.sh", Approval: "maybe"}}}
+	assert.EqualError(t, c.validate(), `task migrate: unknown approval "maybe"`)
+}
+
+func TestTask_AllowsClaims(t *testing.T) {
+	tsk := Task{Name: "deploy", OIDC: map[string]string{"repository": "umputun/updater", "ref": "refs/tags/v*"}}
+	claims := map[string]string{"repository": "umputun/updater", "ref": "refs/tags/v1.2.3"}
+	assert.True(t, tsk.AllowsClaims(func(name string) string { return claims[name] }))
+
+	claims["ref"] = "refs/heads/master"
+	assert.False(t, tsk.AllowsClaims(func(name string) string { return claims[name] }))
+
+	assert.False(t, Task{Name: "no-oidc"}.AllowsClaims(func(string) string { return "" }), "tasks without claims not allowed")
+
+	c := Config{Tasks: []Task{tsk}}
+	require.NoError(t, c.validate())
+	c = Config{Tasks: []Task{{Name: "bad", Command: "bad.sh", OIDC: map[string]string{"ref": "refs/[heads"}}}}
+	assert.EqualError(t, c.validate(), "task bad: invalid oidc claim ref: syntax error in pattern")
+}
+
+func TestConfig_validateCIDRs(t *testing.T) {
+	c := Config{AllowCIDRs: []string{"10.0.0.0/8"}, Tasks: []Task{{Name: "deploy", Command: "deploy.sh", DenyCIDRs: []string{"10.1.0.0/16"}}}}
+	require.NoError(t, c.validate())
+
+	c = Config{AllowCIDRs: []string{"10.0.0.0/80"}}
+	assert.EqualError(t, c.validate(), `allow_cidrs: invalid network "10.0.0.0/80": netip.ParsePrefix("10.0.0.0/80"): prefix length out of range`)
+
+	c = Config{Tasks: []Task{{Name: "deploy", Command: "deploy.sh", DenyCIDRs: []string{"bad"}}}}
+	assert.EqualError(t, c.validate(), `task deploy: deny_cidrs: invalid address "bad": ParseAddr("bad"): unable to parse IP`)
+}
+
+func TestConfig_Masker(t *testing.T) {
+	t.Setenv("TEST_REGISTRY_PASSWORD", "registry-pass")
+	c := Config{Mask: MaskParams{Values: []string{"api-token"}, Env: []string{"TEST_REGISTRY_PASSWORD", "TEST_MISSING"},
+		Patterns: []string{`password=(\S+)`}}}
+	require.NoError(t, c.validate())
+	m, err := c.Masker()
+	require.NoError(t, err)
+	assert.Equal(t, "login **** **** password=****\n", m.String("login api-token registry-pass password=123\n"))
+
+	c = Config{Mask: MaskParams{Patterns: []string{"bad("}}}
+	assert.EqualError(t, c.validate(), "invalid mask pattern \"bad(\": error parsing regexp: missing closing ): `bad(`")
+}
+
+func TestTask_AllowsCert(t *testing.T) {
+	tsk := Task{Name: "deploy", ClientCerts: []string{"ci.example.com", "*.deploy.internal"}}
+	assert.True(t, tsk.AllowsCert([]string{"ci.example.com"}))
+	assert.True(t, tsk.AllowsCert([]string{"other", "runner1.deploy.internal"}))
+	assert.False(t, tsk.AllowsCert([]string{"ci.example.org"}))
+	assert.False(t, tsk.AllowsCert(nil))
+	assert.False(t, Task{Name: "no-certs"}.AllowsCert([]string{"ci.example.com"}))
+
+	c := Config{Tasks: []Task{tsk}}
+	require.NoError(t, c.validate())
+	c = Config{Tasks: []Task{{Name: "bad", Command: "bad.sh", ClientCerts: []string{"[bad"}}}}
+	assert.EqualError(t, c.validate(), "task bad: invalid client cert [bad: syntax error in pattern")
+}
@@ -0,0 +1,58 @@
+package task
+
+import (
+	"context"
+	"io"
+	"sync"
+)
+
+// Runner executes commands
+type Runner interface {
+	Run(ctx context.Context, command string, stdout, stderr io.Writer) error
+}
+
+// Dispatcher makes dedicated runners for tasks which are not executed by the default shell runner
+type Dispatcher struct {
+	Config     *Config
+	ComposeCmd string
+	BatchMode  bool
+	Limiter    sync.Locker
+}
+
+// Runner returns a runner for given task name. ok is false for shell tasks, handled by the default runner.
+func (d *Dispatcher) Runner(name string) (r Runner, ok bool) {
+	t, ok := d.Config.GetTask(name)
+	if !ok {
+		return nil, false
+	}
+	switch t.Type {
+	case TypeCompose:
+		return &ComposeRunner{Command: d.ComposeCmd, Params: t.Compose, Limiter: d.Limiter}, true
+	case "", TypeShell:
+		if t.FanOut.Hosts != "" {
+			return d.fanOutRunner(t), true
+		}
+		if t.SSH == nil {
+			return nil, false
+		}
+		return &SSHRunner{Params: *t.SSH, BatchMode: d.BatchMode, Limiter: d.Limiter}, true
+	default:
+		return nil, false
+	}
+}
+
+// fanOutRunner makes runner for all hosts of the task's group. Limiter is applied to the whole rollout.
+func (d *Dispatcher) fanOutRunner(t Task) *FanOutRunner {
+	res := &FanOutRunner{Strategy: t.FanOut.Strategy, Batch: t.FanOut.Batch, Pause: t.FanOut.Pause, Limiter: d.Limiter}
+	for _, h := range d.Config.Hosts[t.FanOut.Hosts] {
+		hr := HostRunner{Name: h.Name}
+		switch {
+		case h.SSH != nil:
+			hr.Runner = &SSHRunner{Params: *h.SSH, BatchMode: d.BatchMode}
+		case h.Updater != nil:
+			hr.Runner = &RemoteRunner{Params: *h.Updater, TaskName: t.Name}
+		}
+		res.Hosts = append(res.Hosts, hr)
+	}
+	return res
+}
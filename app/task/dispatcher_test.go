@@ -0,0 +1,60 @@
+package task
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcher_Runner(t *testing.T) {
+	c, err := LoadConfig("testdata/compose.yml")
+	require.NoError(t, err)
+	d := Dispatcher{Config: c, ComposeCmd: "docker-compose"}
+
+	r, ok := d.Runner("compose1")
+	require.True(t, ok)
+	cr, ok := r.(*ComposeRunner)
+	require.True(t, ok)
+	assert.Equal(t, "docker-compose", cr.Command)
+	assert.Equal(t, []string{"web", "worker"}, cr.Params.Services)
+
+	_, ok = d.Runner("shell1")
+	assert.False(t, ok, "shell tasks handled by default runner")
+
+	_, ok = d.Runner("unknown")
+	assert.False(t, ok)
+}
+
+func TestDispatcher_RunnerSSH(t *testing.T) {
+	c, err := LoadConfig("testdata/ssh.yml")
+	require.NoError(t, err)
+	d := Dispatcher{Config: c, BatchMode: true}
+
+	r, ok := d.Runner("remote1")
+	require.True(t, ok)
+	sr, ok := r.(*SSHRunner)
+	require.True(t, ok)
+	assert.Equal(t, "10.0.0.1", sr.Params.Host)
+	assert.True(t, sr.BatchMode)
+
+	_, ok = d.Runner("local1")
+	assert.False(t, ok)
+}
+
+func TestDispatcher_RunnerFanOut(t *testing.T) {
+	c, err := LoadConfig("testdata/hosts.yml")
+	require.NoError(t, err)
+	d := Dispatcher{Config: c}
+
+	r, ok := d.Runner("deploy-web")
+	require.True(t, ok)
+	fr, ok := r.(*FanOutRunner)
+	require.True(t, ok)
+	assert.Equal(t, StrategyRolling, fr.Strategy)
+	assert.Equal(t, 2, fr.Batch)
+	require.Equal(t, 3, len(fr.Hosts))
+	assert.IsType(t, &SSHRunner{}, fr.Hosts[0].Runner)
+	assert.IsType(t, &RemoteRunner{}, fr.Hosts[2].Runner)
+	assert.Equal(t, "deploy-web", fr.Hosts[2].Runner.(*RemoteRunner).TaskName)
+}
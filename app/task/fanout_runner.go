@@ -0,0 +1,225 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"github.com/hashicorp/go-multierror"
+)
+
+// Host defines a single target of hosts group, either ssh host or remote updater instance
+type Host struct {
+	Name    string        `yaml:"name"`
+	SSH     *SSHParams    `yaml:"ssh"`
+	Updater *RemoteParams `yaml:"updater"`
+}
+
+// FanOutParams defines how the task is rolled out over the hosts group
+type FanOutParams struct {
+	Hosts    string        `yaml:"hosts"`    // name of hosts group
+	Strategy string        `yaml:"strategy"` // all-parallel (default), rolling or canary-first
+	Batch    int           `yaml:"batch"`    // number of hosts updated at once for rolling and canary-first
+	Pause    time.Duration `yaml:"pause"`    // pause between batches
+}
+
+func (h Host) validate() error {
+	if h.Name == "" {
+		return errors.New("host name required")
+	}
+	if (h.SSH == nil) == (h.Updater == nil) {
+		return fmt.Errorf("host %s: either ssh or updater required", h.Name)
+	}
+	if h.SSH != nil {
+		if err := h.SSH.validate(); err != nil {
+			return fmt.Errorf("host %s: %w", h.Name, err)
+		}
+	}
+	if h.Updater != nil && (h.Updater.URL == "" || h.Updater.Key == "") {
+		return fmt.Errorf("host %s: updater url and key required", h.Name)
+	}
+	return nil
+}
+
+// fan-out strategies
+const (
+	StrategyAllParallel = "all-parallel"
+	StrategyRolling     = "rolling"
+	StrategyCanaryFirst = "canary-first"
+)
+
+// HostRunner is a runner bound to a named host
+type HostRunner struct {
+	Name   string
+	Runner Runner
+}
+
+// FanOutRunner executes command on multiple hosts, in batches defined by strategy. The rollout stops
+// after the batch with a failed host, the rest of hosts are skipped. Output of each host is prefixed by host name
+// and the result of each host is reported at the end, failed hosts to stderr.
+type FanOutRunner struct {
+	Hosts    []HostRunner
+	Strategy string
+	Batch    int
+	Pause    time.Duration
+	Limiter  sync.Locker
+}
+
+// Run command on all hosts, output of hosts written to stdout and stderr writers
+func (f *FanOutRunner) Run(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	if f.Limiter != nil {
+		f.Limiter.Lock()
+		defer f.Limiter.Unlock()
+	}
+
+	outW, errW := syncWriters(stdout, stderr)
+	results := make([]string, len(f.Hosts))
+	errs := new(multierror.Error)
+	batches := f.batches()
+	for i, batch := range batches {
+		if i > 0 && f.Pause > 0 {
+			log.Printf("[DEBUG] pause %v before next batch", f.Pause)
+			select {
+			case <-ctx.Done():
+				errs = multierror.Append(errs, ctx.Err())
+			case <-time.After(f.Pause):
+			}
+		}
+		if errs.ErrorOrNil() != nil {
+			break
+		}
+
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		for _, idx := range batch {
+			wg.Add(1)
+			go func(idx int) {
+				defer wg.Done()
+				h := f.Hosts[idx]
+				log.Printf("[INFO] run on host %s", h.Name)
+				prefix := "[" + h.Name + "] "
+				pw, pe := &prefixWriter{w: outW, prefix: prefix}, &prefixWriter{w: errW, prefix: prefix}
+				started := time.Now()
+				err := h.Runner.Run(ctx, command, pw, pe)
+				pw.Flush()
+				pe.Flush()
+				stepDone(ctx, "host "+h.Name, started, err)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					results[idx] = "failed, " + err.Error()
+					errs = multierror.Append(errs, fmt.Errorf("host %s: %w", h.Name, err))
+					return
+				}
+				results[idx] = "ok"
+			}(idx)
+		}
+		wg.Wait()
+	}
+
+	for i, h := range f.Hosts {
+		res, w := results[i], outW
+		if res == "" {
+			res = "skipped"
+		}
+		if strings.HasPrefix(res, "failed") {
+			w = errW
+		}
+		_, _ = fmt.Fprintf(w, "host %s: %s\n", h.Name, res)
+	}
+	return errs.ErrorOrNil()
+}
+
+// batches splits hosts indexes into batches according to strategy
+func (f *FanOutRunner) batches() (res [][]int) {
+	idxs := make([]int, len(f.Hosts))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	if len(idxs) == 0 {
+		return nil
+	}
+
+	size := f.Batch
+	switch f.Strategy {
+	case StrategyRolling:
+		if size <= 0 {
+			size = 1
+		}
+	case StrategyCanaryFirst:
+		res = append(res, idxs[:1])
+		idxs = idxs[1:]
+		if size <= 0 {
+			size = len(idxs)
+		}
+	default:
+		size = len(idxs)
+	}
+
+	for len(idxs) > 0 {
+		n := min(size, len(idxs))
+		res = append(res, idxs[:n])
+		idxs = idxs[n:]
+	}
+	return res
+}
+
+// syncWriter serializes writes from multiple goroutines
+type syncWriter struct {
+	mu *sync.Mutex
+	w  io.Writer
+}
+
+// syncWriters makes writers of stdout and stderr sharing the lock, as both may write to the same destination
+func syncWriters(stdout, stderr io.Writer) (outW, errW io.Writer) {
+	mu := &sync.Mutex{}
+	return &syncWriter{mu: mu, w: stdout}, &syncWriter{mu: mu, w: stderr}
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// prefixWriter adds prefix to each line, incomplete line kept till the next write or flush
+type prefixWriter struct {
+	mu     sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(data []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.buf = append(p.buf, data...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := p.w.Write(append([]byte(p.prefix), p.buf[:i+1]...)); err != nil {
+			return 0, err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(data), nil
+}
+
+// Flush writes incomplete line, if any
+func (p *prefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.buf) == 0 {
+		return
+	}
+	_, _ = p.w.Write(append([]byte(p.prefix), append(p.buf, '\n')...))
+	p.buf = nil
+}
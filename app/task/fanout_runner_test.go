@@ -0,0 +1,146 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFanOutRunner_Run(t *testing.T) {
+	rec := &recordingRunner{failOn: map[string]bool{}}
+	fr := FanOutRunner{Hosts: rec.hosts("h1", "h2", "h3"), Strategy: StrategyRolling}
+
+	lw := bytes.NewBuffer(nil)
+	err := fr.Run(context.Background(), "echo 123", lw, lw)
+	require.NoError(t, err)
+	assert.Equal(t, "[h1] run echo 123\n[h2] run echo 123\n[h3] run echo 123\nhost h1: ok\nhost h2: ok\nhost h3: ok\n", lw.String())
+	assert.Equal(t, []string{"h1", "h2", "h3"}, rec.order())
+}
+
+func TestFanOutRunner_RunStopsOnFailure(t *testing.T) {
+	rec := &recordingRunner{failOn: map[string]bool{"h2": true}}
+	fr := FanOutRunner{Hosts: rec.hosts("h1", "h2", "h3", "h4"), Strategy: StrategyRolling, Batch: 2}
+
+	lw := bytes.NewBuffer(nil)
+	err := fr.Run(context.Background(), "echo 123", lw, lw)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "host h2: failed on h2")
+	assert.ElementsMatch(t, []string{"h1", "h2"}, rec.order())
+	assert.Contains(t, lw.String(), "host h1: ok\nhost h2: failed, failed on h2\nhost h3: skipped\nhost h4: skipped\n")
+}
+
+func TestFanOutRunner_RunStreams(t *testing.T) {
+	rec := &recordingRunner{failOn: map[string]bool{"h2": true}}
+	fr := FanOutRunner{Hosts: rec.hosts("h1", "h2"), Strategy: StrategyRolling}
+
+	stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+	err := fr.Run(context.Background(), "echo 123", stdout, stderr)
+	require.Error(t, err)
+	assert.Equal(t, "[h1] run echo 123\n[h2] run echo 123\nhost h1: ok\n", stdout.String())
+	assert.Equal(t, "[h2] failed on h2\nhost h2: failed, failed on h2\n", stderr.String())
+}
+
+func TestFanOutRunner_RunCanaryFailed(t *testing.T) {
+	rec := &recordingRunner{failOn: map[string]bool{"h1": true}}
+	fr := FanOutRunner{Hosts: rec.hosts("h1", "h2", "h3"), Strategy: StrategyCanaryFirst}
+	err := fr.Run(context.Background(), "echo 123", io.Discard, io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, []string{"h1"}, rec.order())
+}
+
+func TestFanOutRunner_RunPause(t *testing.T) {
+	rec := &recordingRunner{failOn: map[string]bool{}}
+	fr := FanOutRunner{Hosts: rec.hosts("h1", "h2", "h3"), Strategy: StrategyRolling, Pause: 50 * time.Millisecond}
+	st := time.Now()
+	require.NoError(t, fr.Run(context.Background(), "echo 123", io.Discard, io.Discard))
+	assert.GreaterOrEqual(t, time.Since(st), 100*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	rec = &recordingRunner{failOn: map[string]bool{}}
+	fr.Hosts = rec.hosts("h1", "h2", "h3")
+	err := fr.Run(ctx, "echo 123", io.Discard, io.Discard)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, []string{"h1"}, rec.order())
+}
+
+func TestFanOutRunner_batches(t *testing.T) {
+	hosts := make([]HostRunner, 5)
+	tbl := []struct {
+		strategy string
+		batch    int
+		res      [][]int
+	}{
+		{"", 0, [][]int{{0, 1, 2, 3, 4}}},
+		{StrategyAllParallel, 2, [][]int{{0, 1, 2, 3, 4}}},
+		{StrategyRolling, 0, [][]int{{0}, {1}, {2}, {3}, {4}}},
+		{StrategyRolling, 2, [][]int{{0, 1}, {2, 3}, {4}}},
+		{StrategyCanaryFirst, 0, [][]int{{0}, {1, 2, 3, 4}}},
+		{StrategyCanaryFirst, 3, [][]int{{0}, {1, 2, 3}, {4}}},
+	}
+	for _, tt := range tbl {
+		t.Run(fmt.Sprintf("%s-%d", tt.strategy, tt.batch), func(t *testing.T) {
+			fr := FanOutRunner{Hosts: hosts, Strategy: tt.strategy, Batch: tt.batch}
+			assert.Equal(t, tt.res, fr.batches())
+		})
+	}
+	assert.Nil(t, (&FanOutRunner{Strategy: StrategyCanaryFirst}).batches())
+}
+
+func TestPrefixWriter(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	pw := &prefixWriter{w: buf, prefix: "[h1] "}
+	_, err := pw.Write([]byte("line1\nli"))
+	require.NoError(t, err)
+	_, err = pw.Write([]byte("ne2\nline3"))
+	require.NoError(t, err)
+	assert.Equal(t, "[h1] line1\n[h1] line2\n", buf.String())
+	pw.Flush()
+	assert.Equal(t, "[h1] line1\n[h1] line2\n[h1] line3\n", buf.String())
+}
+
+// recordingRunner records hosts it was called for and fails for hosts in failOn
+type recordingRunner struct {
+	mu     sync.Mutex
+	calls  []string
+	failOn map[string]bool
+}
+
+func (r *recordingRunner) hosts(names ...string) []HostRunner {
+	res := make([]HostRunner, 0, len(names))
+	for _, name := range names {
+		res = append(res, HostRunner{Name: name, Runner: &hostRunner{name: name, rec: r}})
+	}
+	return res
+}
+
+func (r *recordingRunner) order() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.calls
+}
+
+type hostRunner struct {
+	name string
+	rec  *recordingRunner
+}
+
+func (h *hostRunner) Run(_ context.Context, command string, stdout, stderr io.Writer) error {
+	h.rec.mu.Lock()
+	h.rec.calls = append(h.rec.calls, h.name)
+	h.rec.mu.Unlock()
+	_, _ = fmt.Fprintf(stdout, "run %s\n", command)
+	if h.rec.failOn[h.name] {
+		_, _ = fmt.Fprintf(stderr, "failed on %s\n", h.name)
+		return errors.New("failed on " + h.name)
+	}
+	return nil
+}
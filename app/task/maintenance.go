@@ -0,0 +1,205 @@
+package task
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaintenanceParams defines when the task is allowed to run. Windows are ranges of time of the day with optional
+// days of the week, i.e. "Mon-Fri 01:00-05:00", "Sat,Sun 22:00-06:00" or "02:00-04:00". Blackouts are dates or
+// ranges of dates when the task is not allowed at all, i.e. "2026-12-24" or "2026-12-30..2027-01-02".
+type MaintenanceParams struct {
+	Timezone  string   `yaml:"timezone" json:"timezone,omitempty"` // timezone of windows and blackouts, local if not set
+	Windows   []string `yaml:"windows" json:"windows,omitempty"`   // allowed windows, any time if empty
+	Blackouts []string `yaml:"blackouts" json:"blackouts,omitempty"`
+	Defer     bool     `yaml:"defer" json:"defer"` // defer trigger until the next window instead of rejecting
+}
+
+// maxWindowLookup limits how far the next allowed time searched
+const maxWindowLookup = 366 * 24 * time.Hour
+
+type window struct {
+	days       [7]bool
+	start, end time.Duration // from the start of the day, end before start means the window crosses midnight
+}
+
+type blackout struct {
+	from, to time.Time // dates, inclusive
+}
+
+// Allowed returns true if the task can run at the given time
+func (m MaintenanceParams) Allowed(t time.Time) bool {
+	loc, windows, blackouts, err := m.parse()
+	if err != nil {
+		return false
+	}
+	return allowed(t.In(loc), windows, blackouts)
+}
+
+// Next returns the nearest time starting from t when the task can run. False returned if there is no such time
+// within a year, i.e. all windows are in blackouts.
+func (m MaintenanceParams) Next(t time.Time) (time.Time, bool) {
+	loc, windows, blackouts, err := m.parse()
+	if err != nil {
+		return time.Time{}, false
+	}
+	t = t.In(loc)
+	if allowed(t, windows, blackouts) {
+		return t, true
+	}
+	// the task becomes allowed either at start of a window or at midnight, when blackout is over
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+	for d := day; d.Sub(day) < maxWindowLookup; d = d.AddDate(0, 0, 1) {
+		candidates := []time.Time{d}
+		for _, w := range windows {
+			candidates = append(candidates, d.Add(w.start))
+		}
+		var res time.Time
+		for _, c := range candidates {
+			if c.After(t) && allowed(c, windows, blackouts) && (res.IsZero() || c.Before(res)) {
+				res = c
+			}
+		}
+		if !res.IsZero() {
+			return res, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (m MaintenanceParams) validate() error {
+	_, _, _, err := m.parse()
+	return err
+}
+
+func (m MaintenanceParams) parse() (loc *time.Location, windows []window, blackouts []blackout, err error) {
+	loc = time.Local
+	if m.Timezone != "" {
+		if loc, err = time.LoadLocation(m.Timezone); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid timezone %q: %w", m.Timezone, err)
+		}
+	}
+	for _, s := range m.Windows {
+		w, err := parseWindow(s)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid window %q: %w", s, err)
+		}
+		windows = append(windows, w)
+	}
+	for _, s := range m.Blackouts {
+		from, to, isRange := strings.Cut(s, "..")
+		if !isRange {
+			to = from
+		}
+		b := blackout{}
+		if b.from, err = time.ParseInLocation("2006-01-02", strings.TrimSpace(from), loc); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid blackout %q: %w", s, err)
+		}
+		if b.to, err = time.ParseInLocation("2006-01-02", strings.TrimSpace(to), loc); err != nil {
+			return nil, nil, nil, fmt.Errorf("invalid blackout %q: %w", s, err)
+		}
+		if b.to.Before(b.from) {
+			return nil, nil, nil, fmt.Errorf("invalid blackout %q: end before start", s)
+		}
+		blackouts = append(blackouts, b)
+	}
+	return loc, windows, blackouts, nil
+}
+
+// allowed checks if time, already in the location of windows, is within any window and not in blackout
+func allowed(t time.Time, windows []window, blackouts []blackout) bool {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	for _, b := range blackouts {
+		if !day.Before(b.from) && !day.After(b.to) {
+			return false
+		}
+	}
+	if len(windows) == 0 {
+		return true
+	}
+	tod := t.Sub(day)
+	wd := t.Weekday()
+	prev := (wd + 6) % 7
+	for _, w := range windows {
+		if w.start < w.end {
+			if w.days[wd] && tod >= w.start && tod < w.end {
+				return true
+			}
+			continue
+		}
+		// window crosses midnight, started either today or yesterday
+		if (w.days[wd] && tod >= w.start) || (w.days[prev] && tod < w.end) {
+			return true
+		}
+	}
+	return false
+}
+
+var weekdays = map[string]time.Weekday{"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday,
+	"wed": time.Wednesday, "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday}
+
+// parseWindow parses "[days] HH:MM-HH:MM", days are comma separated names or ranges, i.e. "Mon-Fri,Sun"
+func parseWindow(s string) (window, error) {
+	res := window{}
+	fields := strings.Fields(s)
+	var days, hours string
+	switch len(fields) {
+	case 1:
+		days, hours = "*", fields[0]
+	case 2:
+		days, hours = fields[0], fields[1]
+	default:
+		return res, fmt.Errorf("expected [days] HH:MM-HH:MM")
+	}
+
+	if days == "*" {
+		res.days = [7]bool{true, true, true, true, true, true, true}
+	} else {
+		for _, d := range strings.Split(days, ",") {
+			from, to, isRange := strings.Cut(d, "-")
+			if !isRange {
+				to = from
+			}
+			f, ok1 := weekdays[strings.ToLower(from)]
+			l, ok2 := weekdays[strings.ToLower(to)]
+			if !ok1 || !ok2 {
+				return res, fmt.Errorf("invalid days %q", d)
+			}
+			for i := f; ; i = (i + 1) % 7 {
+				res.days[i] = true
+				if i == l {
+					break
+				}
+			}
+		}
+	}
+
+	start, end, ok := strings.Cut(hours, "-")
+	if !ok {
+		return res, fmt.Errorf("invalid time range %q", hours)
+	}
+	var err error
+	if res.start, err = parseTimeOfDay(start); err != nil {
+		return res, err
+	}
+	if res.end, err = parseTimeOfDay(end); err != nil {
+		return res, err
+	}
+	if res.start == res.end {
+		return res, fmt.Errorf("empty time range %q", hours)
+	}
+	return res, nil
+}
+
+// parseTimeOfDay parses HH:MM, 24:00 allowed as the end of the day
+func parseTimeOfDay(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hh, err1 := strconv.Atoi(h)
+	mm, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hh < 0 || mm < 0 || mm > 59 || hh > 24 || (hh == 24 && mm != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(hh)*time.Hour + time.Duration(mm)*time.Minute, nil
+}
@@ -0,0 +1,94 @@
+package task
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaintenanceParams_Allowed(t *testing.T) {
+	m := MaintenanceParams{Timezone: "America/Chicago", Windows: []string{"Mon-Fri 01:00-05:00", "Sat,Sun 22:00-02:00"},
+		Blackouts: []string{"2026-12-24", "2026-12-30..2027-01-02"}}
+	require.NoError(t, m.validate())
+	loc, err := time.LoadLocation("America/Chicago")
+	require.NoError(t, err)
+
+	tbl := []struct {
+		ts  time.Time
+		res bool
+	}{
+		{time.Date(2026, 10, 19, 1, 0, 0, 0, loc), true},       // monday
+		{time.Date(2026, 10, 19, 4, 59, 0, 0, loc), true},      // monday
+		{time.Date(2026, 10, 19, 5, 0, 0, 0, loc), false},      // monday, window closed
+		{time.Date(2026, 10, 19, 0, 30, 0, 0, loc), true},      // monday, sunday's overnight window
+		{time.Date(2026, 10, 19, 12, 0, 0, 0, loc), false},     // monday, no window
+		{time.Date(2026, 10, 19, 6, 30, 0, 0, time.UTC), true}, // monday 01:30 in chicago
+		{time.Date(2026, 10, 24, 23, 0, 0, 0, loc), true},      // saturday night
+		{time.Date(2026, 10, 25, 1, 0, 0, 0, loc), true},       // sunday, saturday's window
+		{time.Date(2026, 10, 26, 1, 30, 0, 0, loc), true},      // monday, both windows
+		{time.Date(2026, 10, 27, 0, 30, 0, 0, loc), false},     // tuesday, no overnight window from monday
+		{time.Date(2026, 12, 24, 2, 0, 0, 0, loc), false},      // thursday, blackout
+		{time.Date(2026, 12, 31, 2, 0, 0, 0, loc), false},      // blackout range
+		{time.Date(2027, 1, 4, 2, 0, 0, 0, loc), true},         // monday after blackout
+	}
+	for i, tt := range tbl {
+		assert.Equal(t, tt.res, m.Allowed(tt.ts), "case %d, %v", i, tt.ts)
+	}
+
+	assert.True(t, MaintenanceParams{}.Allowed(time.Now()), "no windows means any time")
+	assert.False(t, MaintenanceParams{Blackouts: []string{time.Now().Format("2006-01-02")}}.Allowed(time.Now()))
+}
+
+func TestMaintenanceParams_Next(t *testing.T) {
+	m := MaintenanceParams{Timezone: "UTC", Windows: []string{"Mon-Fri 01:00-05:00"}, Blackouts: []string{"2026-10-20"}}
+	tbl := []struct {
+		ts, next time.Time
+	}{
+		{time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC), time.Date(2026, 10, 19, 2, 0, 0, 0, time.UTC)},  // in window
+		{time.Date(2026, 10, 19, 0, 10, 0, 0, time.UTC), time.Date(2026, 10, 19, 1, 0, 0, 0, time.UTC)}, // same day
+		{time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC), time.Date(2026, 10, 21, 1, 0, 0, 0, time.UTC)},  // skip blackout
+		{time.Date(2026, 10, 23, 6, 0, 0, 0, time.UTC), time.Date(2026, 10, 26, 1, 0, 0, 0, time.UTC)},  // skip weekend
+	}
+	for i, tt := range tbl {
+		next, ok := m.Next(tt.ts)
+		require.True(t, ok, "case %d", i)
+		assert.Equal(t, tt.next, next.UTC(), "case %d", i)
+	}
+
+	m = MaintenanceParams{Timezone: "UTC", Blackouts: []string{"2026-10-19..2026-10-21"}}
+	next, ok := m.Next(time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC))
+	require.True(t, ok)
+	assert.Equal(t, time.Date(2026, 10, 22, 0, 0, 0, 0, time.UTC), next)
+
+	m = MaintenanceParams{Timezone: "UTC", Blackouts: []string{"2026-01-01..2028-01-01"}}
+	_, ok = m.Next(time.Date(2026, 10, 19, 6, 0, 0, 0, time.UTC))
+	assert.False(t, ok)
+}
+
+func TestMaintenanceParams_validate(t *testing.T) {
+	tbl := []struct {
+		m   MaintenanceParams
+		err string
+	}{
+		{MaintenanceParams{Windows: []string{"02:00-04:00", "Fri-Mon 22:00-24:00", "*  00:00-01:00"}}, ""},
+		{MaintenanceParams{Timezone: "Bad/Zone"}, `invalid timezone "Bad/Zone"`},
+		{MaintenanceParams{Windows: []string{"Mon 02:00"}}, `invalid window "Mon 02:00": invalid time range "02:00"`},
+		{MaintenanceParams{Windows: []string{"Xyz 02:00-03:00"}}, `invalid window "Xyz 02:00-03:00": invalid days "Xyz"`},
+		{MaintenanceParams{Windows: []string{"02:00-25:00"}}, `invalid window "02:00-25:00": invalid time "25:00"`},
+		{MaintenanceParams{Windows: []string{"02:00-02:00"}}, `invalid window "02:00-02:00": empty time range "02:00-02:00"`},
+		{MaintenanceParams{Windows: []string{"Mon Tue 02:00-03:00"}}, `invalid window "Mon Tue 02:00-03:00": expected [days] HH:MM-HH:MM`},
+		{MaintenanceParams{Blackouts: []string{"2026-13-01"}}, `invalid blackout "2026-13-01"`},
+		{MaintenanceParams{Blackouts: []string{"2026-12-02..2026-12-01"}}, `invalid blackout "2026-12-02..2026-12-01": end before start`},
+	}
+	for i, tt := range tbl {
+		err := tt.m.validate()
+		if tt.err == "" {
+			assert.NoError(t, err, "case %d", i)
+			continue
+		}
+		require.Error(t, err, "case %d", i)
+		assert.Contains(t, err.Error(), tt.err, "case %d", i)
+	}
+}
@@ -0,0 +1,143 @@
+package task
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Param declares task parameter. Parameters passed to the task command as environment variables.
+type Param struct {
+	Name        string `yaml:"name" json:"name"`
+	Default     string `yaml:"default" json:"default,omitempty"`
+	Required    bool   `yaml:"required" json:"required"`
+	Description string `yaml:"description" json:"description,omitempty"`
+	Secret      bool   `yaml:"secret" json:"secret,omitempty"` // value masked in audit log and output
+}
+
+// paramMask replaces values of secret parameters
+const paramMask = "****"
+
+var reParamName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+type paramsCtxKey struct{}
+
+// WithParams returns context with task parameters, used by runners to pass them to commands
+func WithParams(ctx context.Context, params map[string]string) context.Context {
+	if len(params) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, paramsCtxKey{}, params)
+}
+
+// ParamsFromContext returns task parameters set by WithParams
+func ParamsFromContext(ctx context.Context) map[string]string {
+	res, _ := ctx.Value(paramsCtxKey{}).(map[string]string)
+	return res
+}
+
+// ResolveParams checks given parameters against declared ones and applies defaults.
+// Undeclared and missing required parameters rejected.
+func (t Task) ResolveParams(given map[string]string) (map[string]string, error) {
+	res := map[string]string{}
+	for k, v := range given {
+		if !t.hasParam(k) {
+			return nil, fmt.Errorf("unknown parameter %s", k)
+		}
+		res[k] = v
+	}
+	for _, p := range t.Params {
+		if _, ok := res[p.Name]; ok {
+			continue
+		}
+		if p.Required {
+			return nil, fmt.Errorf("parameter %s required", p.Name)
+		}
+		if p.Default != "" {
+			res[p.Name] = p.Default
+		}
+	}
+	return res, nil
+}
+
+// MaskParams returns copy of parameters with values of secret parameters masked
+func (t Task) MaskParams(params map[string]string) map[string]string {
+	if len(params) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(params))
+	for k, v := range params {
+		res[k] = v
+	}
+	for _, p := range t.Params {
+		if _, ok := res[p.Name]; ok && p.Secret {
+			res[p.Name] = paramMask
+		}
+	}
+	return res
+}
+
+// SecretValues returns values of secret parameters
+func (t Task) SecretValues(params map[string]string) []string {
+	var res []string
+	for _, p := range t.Params {
+		if v, ok := params[p.Name]; ok && p.Secret {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func (t Task) hasParam(name string) bool {
+	for _, p := range t.Params {
+		if p.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (p Param) validate() error {
+	if !reParamName.MatchString(p.Name) {
+		return fmt.Errorf("invalid parameter name %q", p.Name)
+	}
+	return nil
+}
+
+// paramsEnv returns parameters and secrets from context as sorted list of KEY=value. Trace context of the span
+// in context passed as TRACEPARENT and TRACESTATE, so commands can continue the trace.
+func paramsEnv(ctx context.Context) []string {
+	params, secrets := ParamsFromContext(ctx), secretsFromContext(ctx)
+	traceCtx := propagation.MapCarrier{}
+	propagation.TraceContext{}.Inject(ctx, traceCtx)
+	res := make([]string, 0, len(params)+len(secrets)+len(traceCtx))
+	for k, v := range params {
+		res = append(res, k+"="+v)
+	}
+	for k, v := range secrets {
+		res = append(res, k+"="+v)
+	}
+	for k, v := range traceCtx {
+		res = append(res, strings.ToUpper(k)+"="+v)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// paramsExport returns shell export statement for parameters and secrets from context, used for remote commands
+func paramsExport(ctx context.Context) string {
+	env := paramsEnv(ctx)
+	if len(env) == 0 {
+		return ""
+	}
+	res := make([]string, 0, len(env))
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		res = append(res, k+"='"+strings.ReplaceAll(v, "'", `'\''`)+"'")
+	}
+	return "export " + strings.Join(res, " ") + "; "
+}
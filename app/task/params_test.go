@@ -0,0 +1,73 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTask_ResolveParams(t *testing.T) {
+	tsk := Task{Name: "t1", Params: []Param{{Name: "VERSION", Required: true}, {Name: "ENV", Default: "prod"}, {Name: "EXTRA"}}}
+
+	tbl := []struct {
+		given map[string]string
+		res   map[string]string
+		err   string
+	}{
+		{map[string]string{"VERSION": "1.2"}, map[string]string{"VERSION": "1.2", "ENV": "prod"}, ""},
+		{map[string]string{"VERSION": "1.2", "ENV": "dev", "EXTRA": "x"}, map[string]string{"VERSION": "1.2", "ENV": "dev", "EXTRA": "x"}, ""},
+		{map[string]string{"ENV": "dev"}, nil, "parameter VERSION required"},
+		{map[string]string{"VERSION": "1.2", "BAD": "x"}, nil, "unknown parameter BAD"},
+	}
+	for i, tt := range tbl {
+		res, err := tsk.ResolveParams(tt.given)
+		if tt.err != "" {
+			require.EqualError(t, err, tt.err, "case %d", i)
+			continue
+		}
+		require.NoError(t, err, "case %d", i)
+		assert.Equal(t, tt.res, res, "case %d", i)
+	}
+}
+
+func TestTask_MaskParams(t *testing.T) {
+	tsk := Task{Name: "t1", Params: []Param{{Name: "VERSION"}, {Name: "TOKEN", Secret: true}}}
+	params := map[string]string{"VERSION": "1.2", "TOKEN": "abc"}
+	assert.Equal(t, map[string]string{"VERSION": "1.2", "TOKEN": "****"}, tsk.MaskParams(params))
+	assert.Equal(t, "abc", params["TOKEN"], "original parameters not changed")
+	assert.Equal(t, map[string]string{"VERSION": "1.2"}, tsk.MaskParams(map[string]string{"VERSION": "1.2"}))
+	assert.Nil(t, tsk.MaskParams(nil))
+	assert.Equal(t, []string{"abc"}, tsk.SecretValues(params))
+	assert.Empty(t, tsk.SecretValues(map[string]string{"VERSION": "1.2"}))
+}
+
+func TestParams_context(t *testing.T) {
+	assert.Nil(t, ParamsFromContext(context.Background()))
+	ctx := WithParams(context.Background(), map[string]string{"B": "it's", "A": "1"})
+	assert.Equal(t, map[string]string{"B": "it's", "A": "1"}, ParamsFromContext(ctx))
+	assert.Equal(t, []string{"A=1", "B=it's"}, paramsEnv(ctx))
+	assert.Equal(t, `export A='1' B='it'\''s'; `, paramsExport(ctx))
+	assert.Equal(t, "", paramsExport(context.Background()))
+}
+
+func TestParams_traceContext(t *testing.T) {
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: trace.TraceID{1, 2, 3}, SpanID: trace.SpanID{4, 5, 6},
+		TraceFlags: trace.FlagsSampled})
+	ctx := trace.ContextWithSpanContext(WithParams(context.Background(), map[string]string{"A": "1"}), sc)
+	assert.Equal(t, []string{"A=1", "TRACEPARENT=00-01020300000000000000000000000000-0405060000000000-01"}, paramsEnv(ctx))
+}
+
+func TestShellRunner_RunWithParams(t *testing.T) {
+	ctx := WithParams(context.Background(), map[string]string{"VERSION": "1.2.3"})
+	for _, batch := range []bool{false, true} {
+		sr := ShellRunner{BatchMode: batch, TimeOut: time.Second}
+		lw := bytes.NewBuffer(nil)
+		require.NoError(t, sr.Run(ctx, "echo version $VERSION", lw, lw))
+		assert.Equal(t, "version 1.2.3\n", lw.String(), "batch %v", batch)
+	}
+}
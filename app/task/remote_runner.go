@@ -0,0 +1,71 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// RemoteParams defines remote updater instance
+type RemoteParams struct {
+	URL  string `yaml:"url"`  // base url of remote updater, i.e. https://updater.example.com
+	Key  string `yaml:"key"`  // secret key of remote updater
+	Task string `yaml:"task"` // task name on remote updater, the same as local task name if not set
+}
+
+// RemoteRunner triggers a task on remote updater instance and waits for completion.
+// The command is ignored, remote updater runs its own task.
+type RemoteRunner struct {
+	Params   RemoteParams
+	TaskName string // used if remote task is not set
+	Client   *http.Client
+}
+
+// Run triggers remote task with POST /update and writes response to stdout, or to stderr if the remote task failed
+func (r *RemoteRunner) Run(ctx context.Context, _ string, stdout, stderr io.Writer) error {
+	taskName := r.Params.Task
+	if taskName == "" {
+		taskName = r.TaskName
+	}
+	body, err := json.Marshal(struct {
+		Task   string            `json:"task"`
+		Secret string            `json:"secret"`
+		Params map[string]string `json:"params,omitempty"`
+	}{Task: taskName, Secret: r.Params.Key, Params: ParamsFromContext(ctx)})
+	if err != nil {
+		return fmt.Errorf("can't marshal request: %w", err)
+	}
+
+	url := strings.TrimSuffix(r.Params.URL, "/") + "/update"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("can't make request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Minute}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("can't call %s: %w", url, err)
+	}
+	defer resp.Body.Close() //nolint
+
+	out := stdout
+	if resp.StatusCode != http.StatusOK {
+		out = stderr
+	}
+	_, _ = fmt.Fprintf(out, "remote %s task %s: ", r.Params.URL, taskName)
+	_, _ = io.Copy(out, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote task %s failed, status %d", taskName, resp.StatusCode)
+	}
+	return nil
+}
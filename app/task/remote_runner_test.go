@@ -0,0 +1,51 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteRunner_Run(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/update", r.URL.Path)
+		req := struct {
+			Task   string `json:"task"`
+			Secret string `json:"secret"`
+		}{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.Secret != "12345" {
+			http.Error(w, "rejected", http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"task":"` + req.Task + `","updated":"ok"}` + "\n"))
+	}))
+	defer ts.Close()
+
+	{
+		rr := RemoteRunner{Params: RemoteParams{URL: ts.URL + "/", Key: "12345"}, TaskName: "task1"}
+		lw := bytes.NewBuffer(nil)
+		require.NoError(t, rr.Run(context.Background(), "", lw, lw))
+		assert.Equal(t, "remote "+ts.URL+"/ task task1: {\"task\":\"task1\",\"updated\":\"ok\"}\n", lw.String())
+	}
+	{
+		rr := RemoteRunner{Params: RemoteParams{URL: ts.URL, Key: "12345", Task: "remote-task"}, TaskName: "task1"}
+		lw := bytes.NewBuffer(nil)
+		require.NoError(t, rr.Run(context.Background(), "", lw, lw))
+		assert.Contains(t, lw.String(), `"task":"remote-task"`)
+	}
+	{
+		rr := RemoteRunner{Params: RemoteParams{URL: ts.URL, Key: "bad"}, TaskName: "task1"}
+		lw := bytes.NewBuffer(nil)
+		err := rr.Run(context.Background(), "", lw, lw)
+		require.Error(t, err)
+		assert.Equal(t, "remote task task1 failed, status 403", err.Error())
+		assert.Contains(t, lw.String(), "rejected")
+	}
+}
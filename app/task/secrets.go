@@ -0,0 +1,145 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"gopkg.in/yaml.v2"
+)
+
+// Secret defines named secret passed to commands of tasks as environment variable.
+// The value is taken from one of the sources: file, environment variable of updater or encrypted secrets file.
+type Secret struct {
+	Name      string `yaml:"name"`
+	File      string `yaml:"file"`      // file with the value, i.e. docker or kubernetes secret
+	Env       string `yaml:"env"`       // environment variable with the value
+	Encrypted string `yaml:"encrypted"` // key of the value in encrypted secrets file
+}
+
+// Secrets keeps values of secrets by name
+type Secrets map[string]string
+
+type secretsCtxKey struct{}
+
+// WithSecrets returns context with secrets passed to commands as environment variables, along with parameters
+func WithSecrets(ctx context.Context, secrets map[string]string) context.Context {
+	if len(secrets) == 0 {
+		return ctx
+	}
+	return context.WithValue(ctx, secretsCtxKey{}, secrets)
+}
+
+// secretsFromContext returns secrets set by WithSecrets
+func secretsFromContext(ctx context.Context) map[string]string {
+	res, _ := ctx.Value(secretsCtxKey{}).(map[string]string)
+	return res
+}
+
+// For returns secrets of the task
+func (s Secrets) For(t Task) map[string]string {
+	if len(t.Secrets) == 0 {
+		return nil
+	}
+	res := make(map[string]string, len(t.Secrets))
+	for _, name := range t.Secrets {
+		if v, ok := s[name]; ok {
+			res[name] = v
+		}
+	}
+	return res
+}
+
+// LoadSecrets reads values of secrets. Encrypted secrets are taken from age encrypted yaml file with name: value
+// pairs, decrypted with age identity from keyFile. All secrets required, missing file, variable or key is an error.
+func (c *Config) LoadSecrets(encryptedFile, keyFile string) (Secrets, error) {
+	var encrypted map[string]string
+	res := Secrets{}
+	for _, s := range c.Secrets {
+		switch {
+		case s.File != "":
+			data, err := os.ReadFile(s.File)
+			if err != nil {
+				return nil, fmt.Errorf("secret %s: %w", s.Name, err)
+			}
+			res[s.Name] = strings.TrimSuffix(strings.TrimSuffix(string(data), "\n"), "\r")
+		case s.Env != "":
+			v, ok := os.LookupEnv(s.Env)
+			if !ok {
+				return nil, fmt.Errorf("secret %s: environment variable %s not set", s.Name, s.Env)
+			}
+			res[s.Name] = v
+		case s.Encrypted != "":
+			if encrypted == nil {
+				var err error
+				if encrypted, err = decryptSecrets(encryptedFile, keyFile); err != nil {
+					return nil, err
+				}
+			}
+			v, ok := encrypted[s.Encrypted]
+			if !ok {
+				return nil, fmt.Errorf("secret %s: key %s not found in %s", s.Name, s.Encrypted, encryptedFile)
+			}
+			res[s.Name] = v
+		}
+	}
+	return res, nil
+}
+
+// decryptSecrets reads age encrypted yaml file, binary or armored
+func decryptSecrets(file, keyFile string) (map[string]string, error) {
+	if file == "" || keyFile == "" {
+		return nil, errors.New("encrypted secrets require secrets file and key file")
+	}
+	kf, err := os.Open(keyFile) //nolint:gosec // file from options
+	if err != nil {
+		return nil, fmt.Errorf("can't open secrets key: %w", err)
+	}
+	defer kf.Close() //nolint
+	ids, err := age.ParseIdentities(kf)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse secrets key %s: %w", keyFile, err)
+	}
+
+	f, err := os.Open(file) //nolint:gosec // file from options
+	if err != nil {
+		return nil, fmt.Errorf("can't open secrets file: %w", err)
+	}
+	defer f.Close() //nolint
+	var in io.Reader = bufio.NewReader(f)
+	if head, _ := in.(*bufio.Reader).Peek(len(armor.Header)); bytes.Equal(head, []byte(armor.Header)) {
+		in = armor.NewReader(in)
+	}
+	r, err := age.Decrypt(in, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("can't decrypt secrets file %s: %w", file, err)
+	}
+	res := map[string]string{}
+	if err = yaml.NewDecoder(r).Decode(&res); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("can't parse secrets file %s: %w", file, err)
+	}
+	return res, nil
+}
+
+func (s Secret) validate() error {
+	if !reParamName.MatchString(s.Name) {
+		return fmt.Errorf("invalid secret name %q", s.Name)
+	}
+	sources := 0
+	for _, v := range []string{s.File, s.Env, s.Encrypted} {
+		if v != "" {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("secret %s: one of file, env or encrypted required", s.Name)
+	}
+	return nil
+}
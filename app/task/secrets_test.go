@@ -0,0 +1,131 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_LoadSecrets(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "registry"), []byte("registry-pass\n"), 0o600))
+	t.Setenv("TEST_API_TOKEN", "api-token")
+	encFile, keyFile := writeEncryptedSecrets(t, dir, "db_password: db-pass\nmulti: |\n  line1\n  line2\n", false)
+
+	c := Config{Secrets: []Secret{
+		{Name: "REGISTRY_PASSWORD", File: filepath.Join(dir, "registry")},
+		{Name: "API_TOKEN", Env: "TEST_API_TOKEN"},
+		{Name: "DB_PASSWORD", Encrypted: "db_password"},
+		{Name: "MULTI", Encrypted: "multi"},
+	}}
+	require.NoError(t, c.validate())
+	secrets, err := c.LoadSecrets(encFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, Secrets{"REGISTRY_PASSWORD": "registry-pass", "API_TOKEN": "api-token", "DB_PASSWORD": "db-pass",
+		"MULTI": "line1\nline2\n"}, secrets)
+
+	tsk := Task{Name: "deploy", Secrets: []string{"API_TOKEN", "DB_PASSWORD"}}
+	assert.Equal(t, map[string]string{"API_TOKEN": "api-token", "DB_PASSWORD": "db-pass"}, secrets.For(tsk))
+	assert.Nil(t, secrets.For(Task{Name: "no-secrets"}))
+}
+
+func TestConfig_LoadSecretsArmored(t *testing.T) {
+	encFile, keyFile := writeEncryptedSecrets(t, t.TempDir(), "token: armored-token\n", true)
+	c := Config{Secrets: []Secret{{Name: "TOKEN", Encrypted: "token"}}}
+	secrets, err := c.LoadSecrets(encFile, keyFile)
+	require.NoError(t, err)
+	assert.Equal(t, Secrets{"TOKEN": "armored-token"}, secrets)
+}
+
+func TestConfig_LoadSecretsErrors(t *testing.T) {
+	dir := t.TempDir()
+	encFile, keyFile := writeEncryptedSecrets(t, dir, "token: value\n", false)
+	_, otherKey := writeEncryptedSecrets(t, t.TempDir(), "", false)
+
+	tbl := []struct {
+		secret       Secret
+		file, key    string
+		errSubstring string
+	}{
+		{Secret{Name: "S", File: filepath.Join(dir, "missing")}, "", "", "secret S: open "},
+		{Secret{Name: "S", Env: "TEST_MISSING_SECRET_ENV"}, "", "", "secret S: environment variable TEST_MISSING_SECRET_ENV not set"},
+		{Secret{Name: "S", Encrypted: "token"}, "", "", "encrypted secrets require secrets file and key file"},
+		{Secret{Name: "S", Encrypted: "other"}, encFile, keyFile, "secret S: key other not found in " + encFile},
+		{Secret{Name: "S", Encrypted: "token"}, encFile, otherKey, "can't decrypt secrets file " + encFile},
+		{Secret{Name: "S", Encrypted: "token"}, encFile, encFile, "can't parse secrets key " + encFile},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.errSubstring, func(t *testing.T) {
+			c := Config{Secrets: []Secret{tt.secret}}
+			_, err := c.LoadSecrets(tt.file, tt.key)
+			require.ErrorContains(t, err, tt.errSubstring)
+		})
+	}
+}
+
+func TestConfig_validateSecrets(t *testing.T) {
+	tbl := []struct {
+		conf Config
+		err  string
+	}{
+		{Config{Secrets: []Secret{{Name: "A", Env: "X"}}, Tasks: []Task{{Name: "t1", Command: "t1.sh", Secrets: []string{"A"}}}}, ""},
+		{Config{Secrets: []Secret{{Name: "bad-name", Env: "X"}}}, `invalid secret name "bad-name"`},
+		{Config{Secrets: []Secret{{Name: "A"}}}, "secret A: one of file, env or encrypted required"},
+		{Config{Secrets: []Secret{{Name: "A", Env: "X", File: "f"}}}, "secret A: one of file, env or encrypted required"},
+		{Config{Secrets: []Secret{{Name: "A", Env: "X"}, {Name: "A", File: "f"}}}, "secret A defined twice"},
+		{Config{Tasks: []Task{{Name: "t1", Command: "t1.sh", Secrets: []string{"A"}}}}, "task t1: unknown secret A"},
+		{Config{Secrets: []Secret{{Name: "A", Env: "X"}}, Tasks: []Task{{Name: "t1", Command: "t1.sh",
+			Secrets: []string{"A"}, Params: []Param{{Name: "A"}}}}}, "task t1: secret A conflicts with parameter"},
+	}
+	for _, tt := range tbl {
+		err := tt.conf.validate()
+		if tt.err == "" {
+			assert.NoError(t, err)
+			continue
+		}
+		assert.EqualError(t, err, tt.err)
+	}
+}
+
+func TestSecrets_env(t *testing.T) {
+	ctx := WithParams(context.Background(), map[string]string{"VERSION": "1.2"})
+	ctx = WithSecrets(ctx, map[string]string{"TOKEN": "abc"})
+	assert.Equal(t, []string{"TOKEN=abc", "VERSION=1.2"}, paramsEnv(ctx))
+	assert.Equal(t, map[string]string{"VERSION": "1.2"}, ParamsFromContext(ctx), "secrets are not parameters")
+	assert.Equal(t, ctx, WithSecrets(ctx, nil))
+}
+
+// writeEncryptedSecrets makes age identity and secrets file encrypted for it, returns paths of both
+func writeEncryptedSecrets(t *testing.T, dir, secrets string, armored bool) (encFile, keyFile string) {
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+	keyFile = filepath.Join(dir, "secrets.key")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# test key\n"+id.String()+"\n"), 0o600))
+
+	buf := bytes.Buffer{}
+	var out io.WriteCloser = nopWriteCloser{&buf}
+	if armored {
+		out = armor.NewWriter(&buf)
+	}
+	w, err := age.Encrypt(out, id.Recipient())
+	require.NoError(t, err)
+	_, err = io.WriteString(w, secrets)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.NoError(t, out.Close())
+	encFile = filepath.Join(dir, "secrets.age")
+	require.NoError(t, os.WriteFile(encFile, buf.Bytes(), 0o600))
+	return encFile, keyFile
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
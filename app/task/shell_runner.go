@@ -14,6 +14,8 @@ import (
 	log "github.com/go-pkgz/lgr"
 	"github.com/hashicorp/go-multierror"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ShellRunner executes commands with shell
@@ -23,8 +25,8 @@ type ShellRunner struct {
 	TimeOut   time.Duration
 }
 
-// Run command in shell with provided logger
-func (s *ShellRunner) Run(ctx context.Context, command string, logWriter io.Writer) error {
+// Run command in shell, output of the command written to stdout and stderr writers
+func (s *ShellRunner) Run(ctx context.Context, command string, stdout, stderr io.Writer) error {
 	if command == "" {
 		return nil
 	}
@@ -40,10 +42,16 @@ func (s *ShellRunner) Run(ctx context.Context, command string, logWriter io.Writ
 		if err != nil {
 			return fmt.Errorf("can't prepare batch: %w", err)
 		}
-		return s.runBatch(batchFile, logWriter, s.TimeOut)
+		ctx, span := tracer.Start(ctx, "batch", trace.WithAttributes(attribute.String("command", command)))
+		started := time.Now()
+		err = s.runBatch(ctx, batchFile, stdout, stderr, s.TimeOut, paramsEnv(ctx))
+		stepDone(ctx, "batch", started, err)
+		endSpan(span, err)
+		return err
 	}
 
-	execCmd := func(command string) error {
+	// each command made a span and reported as a step, the command gets trace context of its span
+	execCmd := func(command string) (err error) {
 		log.Printf("[INFO] execute %q", command)
 		var suppressError bool
 		if command[0] == '@' {
@@ -51,13 +59,23 @@ func (s *ShellRunner) Run(ctx context.Context, command string, logWriter io.Writ
 			suppressError = true
 			log.Printf("[DEBUG] suppress error for %s", command)
 		}
+		ctx, span := tracer.Start(ctx, "command", trace.WithAttributes(attribute.String("command", command)))
+		started := time.Now()
+		defer func() {
+			stepDone(ctx, command, started, err)
+			endSpan(span, err)
+		}()
 		cmd := exec.CommandContext(ctx, "sh", "-c", command) // nolint
-		cmd.Stdout = logWriter
-		cmd.Stderr = logWriter
+		if env := paramsEnv(ctx); len(env) > 0 {
+			cmd.Env = append(os.Environ(), env...)
+		}
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
 		cmd.Stdin = os.Stdin
 		if err := cmd.Run(); err != nil {
 			if suppressError {
 				log.Printf("[WARN] suppressed error executing %q, %v", command, err)
+				span.AddEvent("error suppressed", trace.WithAttributes(attribute.String("error", err.Error())))
 				return nil
 			}
 			return fmt.Errorf("failed to execute %s: %w", command, err)
@@ -77,8 +95,9 @@ func (s *ShellRunner) Run(ctx context.Context, command string, logWriter io.Writ
 	return nil
 }
 
-func (s *ShellRunner) runBatch(batchFile string, logWriter io.Writer, timeout time.Duration) error {
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+func (s *ShellRunner) runBatch(ctx context.Context, batchFile string, stdout, stderr io.Writer, timeout time.Duration,
+	env []string) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer func() {
 		cancel()
 		if e := os.Remove(batchFile); e != nil {
@@ -86,8 +105,11 @@ func (s *ShellRunner) runBatch(batchFile string, logWriter io.Writer, timeout ti
 		}
 	}()
 	cmd := exec.CommandContext(ctx, "sh", batchFile) // nolint
-	cmd.Stdout = logWriter
-	cmd.Stderr = logWriter
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
 	cmd.Stdin = os.Stdin
 	log.Printf("[DEBUG] executing batch commands: %s", batchFile)
 
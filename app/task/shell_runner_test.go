@@ -15,7 +15,7 @@ func TestShellRunner_Run(t *testing.T) {
 
 	{
 		lw := bytes.NewBuffer(nil)
-		err := sr.Run(context.Background(), "echo 123", lw)
+		err := sr.Run(context.Background(), "echo 123", lw, lw)
 		t.Log(lw.String())
 		require.NoError(t, err)
 		assert.Equal(t, "123\n", lw.String())
@@ -23,7 +23,7 @@ func TestShellRunner_Run(t *testing.T) {
 
 	{
 		lw := bytes.NewBuffer(nil)
-		err := sr.Run(context.Background(), "no-such-command 123", lw)
+		err := sr.Run(context.Background(), "no-such-command 123", lw, lw)
 		require.Error(t, err)
 		t.Log(lw.String())
 		assert.Contains(t, lw.String(), "not found")
@@ -31,7 +31,7 @@ func TestShellRunner_Run(t *testing.T) {
 
 	{
 		lw := bytes.NewBuffer(nil)
-		err := sr.Run(context.Background(), "@no-such-command 123", lw)
+		err := sr.Run(context.Background(), "@no-such-command 123", lw, lw)
 		t.Log(lw.String())
 		require.NoError(t, err)
 		assert.Contains(t, lw.String(), "not found")
@@ -44,30 +44,42 @@ func TestShellRunner_RunMultiLine(t *testing.T) {
 
 	{
 		lw := bytes.NewBuffer(nil)
-		err := sr.Run(context.Background(), "echo 123\necho 567\n", lw)
+		err := sr.Run(context.Background(), "echo 123\necho 567\n", lw, lw)
 		require.NoError(t, err)
 		assert.Equal(t, "123\n567\n", lw.String())
 	}
 
 	{
 		lw := bytes.NewBuffer(nil)
-		err := sr.Run(context.Background(), "echo 123\nno-such-command 123", lw)
+		err := sr.Run(context.Background(), "echo 123\nno-such-command 123", lw, lw)
 		require.Error(t, err)
 		assert.Contains(t, lw.String(), "not found")
 	}
 
 	{
 		lw := bytes.NewBuffer(nil)
-		err := sr.Run(context.Background(), "echo 123\n@no-such-command 123", lw)
+		err := sr.Run(context.Background(), "echo 123\n@no-such-command 123", lw, lw)
 		require.NoError(t, err)
 		assert.Contains(t, lw.String(), "not found")
 	}
 
 }
+
+func TestShellRunner_RunStreams(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		sr := ShellRunner{BatchMode: batch, TimeOut: time.Second}
+		stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		err := sr.Run(context.Background(), "echo 123\necho warning >&2\necho 345", stdout, stderr)
+		require.NoError(t, err)
+		assert.Equal(t, "123\n345\n", stdout.String(), "batch: %v", batch)
+		assert.Equal(t, "warning\n", stderr.String(), "batch: %v", batch)
+	}
+}
+
 func TestShellRunner_RunBatch(t *testing.T) {
 	sr := ShellRunner{BatchMode: true, TimeOut: time.Second}
 	lw := bytes.NewBuffer(nil)
-	err := sr.Run(context.Background(), "echo 123\necho 345", lw)
+	err := sr.Run(context.Background(), "echo 123\necho 345", lw, lw)
 	require.NoError(t, err)
 	assert.Equal(t, "123\n345\n", lw.String())
 }
@@ -76,7 +88,7 @@ func TestShellRunner_RunBatchTimeOut(t *testing.T) {
 	sr := ShellRunner{BatchMode: true, TimeOut: time.Millisecond * 100}
 	lw := bytes.NewBuffer(nil)
 	st := time.Now()
-	err := sr.Run(context.Background(), "sleep 1 && sleep 1 && echo 123\necho 345", lw)
+	err := sr.Run(context.Background(), "sleep 1 && sleep 1 && echo 123\necho 345", lw, lw)
 	require.Error(t, err)
 	assert.True(t, time.Since(st) < time.Second*2)
 }
@@ -0,0 +1,258 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/go-pkgz/lgr"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHParams defines remote host for ssh task. Host key must be verified either with known_hosts file
+// or with pinned fingerprint, unverified hosts are rejected.
+type SSHParams struct {
+	Host        string     `yaml:"host"`
+	Port        int        `yaml:"port"`
+	User        string     `yaml:"user"`
+	KeyFile     string     `yaml:"key_file"`
+	KnownHosts  string     `yaml:"known_hosts"`
+	Fingerprint string     `yaml:"fingerprint"` // SHA256 fingerprint, i.e. SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8
+	Jump        *SSHParams `yaml:"jump"`        // optional jump (bastion) host, inherits user, key and known_hosts if not set
+}
+
+// SSHRunner executes commands on remote host via ssh
+type SSHRunner struct {
+	Params    SSHParams
+	BatchMode bool
+	Limiter   sync.Locker
+	Timeout   time.Duration // connection timeout
+}
+
+// Run command on remote host, output written to stdout and stderr writers. Multi-line command executed
+// line by line, each line in its own ssh session, unless batch mode is set.
+func (s *SSHRunner) Run(ctx context.Context, command string, stdout, stderr io.Writer) error {
+	command = strings.TrimSpace(command)
+	if command == "" {
+		return nil
+	}
+
+	if s.Limiter != nil {
+		s.Limiter.Lock()
+		defer s.Limiter.Unlock()
+	}
+
+	client, err := s.connect(ctx)
+	if err != nil {
+		return fmt.Errorf("can't connect to %s: %w", s.Params.addr(), err)
+	}
+	defer client.Close() //nolint
+
+	// close connection on context cancellation, this interrupts running session
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = client.Close()
+		case <-done:
+		}
+	}()
+
+	if s.BatchMode {
+		log.Printf("[DEBUG] executing batch commands on %s", s.Params.addr())
+		err = s.exec(client, "sh -s", strings.NewReader(paramsExport(ctx)+"\n"+command), stdout, stderr)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	for _, c := range strings.Split(command, "\n") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		log.Printf("[INFO] execute %q on %s", c, s.Params.addr())
+		var suppressError bool
+		if c[0] == '@' {
+			c = c[1:]
+			suppressError = true
+			log.Printf("[DEBUG] suppress error for %s", c)
+		}
+		if err := s.execLine(ctx, client, c, stdout, stderr); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if suppressError {
+				log.Printf("[WARN] suppressed error executing %q on %s, %v", c, s.Params.addr(), err)
+				continue
+			}
+			return fmt.Errorf("failed to execute %s on %s: %w", c, s.Params.addr(), err)
+		}
+	}
+	return nil
+}
+
+// execLine executes a single command line. With parameters or secrets in context the command sent to the shell
+// via stdin, after export of them, so their values not visible in the command line on the remote host.
+func (s *SSHRunner) execLine(ctx context.Context, client *ssh.Client, command string, stdout, stderr io.Writer) error {
+	export := paramsExport(ctx)
+	if export == "" {
+		return s.exec(client, command, nil, stdout, stderr)
+	}
+	return s.exec(client, "sh -s", strings.NewReader(export+"\n"+command+"\n"), stdout, stderr)
+}
+
+func (s *SSHRunner) exec(client *ssh.Client, command string, stdin io.Reader, stdout, stderr io.Writer) error {
+	session, err := client.NewSession()
+	if err != nil {
+		return fmt.Errorf("can't open session: %w", err)
+	}
+	defer session.Close() //nolint
+
+	session.Stdout, session.Stderr = syncWriters(stdout, stderr) // session copies stdout and stderr concurrently
+	session.Stdin = stdin
+	return session.Run(command)
+}
+
+// connect makes ssh client, directly or via jump host
+func (s *SSHRunner) connect(ctx context.Context) (*ssh.Client, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := (&net.Dialer{}).DialContext
+	var jumpClient *ssh.Client
+	if s.Params.Jump != nil {
+		jump := s.Params.Jump.inherit(s.Params)
+		jumpRunner := SSHRunner{Params: jump, Timeout: timeout}
+		var err error
+		if jumpClient, err = jumpRunner.connect(ctx); err != nil {
+			return nil, fmt.Errorf("can't connect to jump host %s: %w", jump.addr(), err)
+		}
+		dial = jumpClient.DialContext
+		defer func() {
+			if jumpClient != nil {
+				_ = jumpClient.Close()
+			}
+		}()
+	}
+
+	conf, err := s.Params.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(ctx, "tcp", s.Params.addr())
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, s.Params.addr(), conf)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = conn.SetDeadline(time.Time{})
+	client := ssh.NewClient(c, chans, reqs)
+	if jumpClient != nil {
+		// keep jump connection open while the target client is alive
+		go func(jc *ssh.Client) {
+			_ = client.Wait()
+			_ = jc.Close()
+		}(jumpClient)
+		jumpClient = nil
+	}
+	return client, nil
+}
+
+func (p SSHParams) addr() string {
+	port := p.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(p.Host, strconv.Itoa(port))
+}
+
+// inherit fills empty user, key and host key settings from parent params
+func (p SSHParams) inherit(parent SSHParams) SSHParams {
+	if p.User == "" {
+		p.User = parent.User
+	}
+	if p.KeyFile == "" {
+		p.KeyFile = parent.KeyFile
+	}
+	if p.KnownHosts == "" && p.Fingerprint == "" {
+		p.KnownHosts = parent.KnownHosts
+	}
+	return p
+}
+
+func (p SSHParams) validate() error {
+	if p.Host == "" {
+		return errors.New("ssh host required")
+	}
+	if p.User == "" {
+		return errors.New("ssh user required")
+	}
+	if p.KeyFile == "" {
+		return errors.New("ssh key_file required")
+	}
+	if p.KnownHosts == "" && p.Fingerprint == "" {
+		return errors.New("ssh known_hosts or fingerprint required")
+	}
+	if p.Jump != nil {
+		if err := p.Jump.inherit(p).validate(); err != nil {
+			return fmt.Errorf("jump: %w", err)
+		}
+	}
+	return nil
+}
+
+func (p SSHParams) clientConfig() (*ssh.ClientConfig, error) {
+	key, err := os.ReadFile(p.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("can't read key file: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("can't parse key file %s: %w", p.KeyFile, err)
+	}
+	hostKeyCallback, err := p.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	return &ssh.ClientConfig{
+		User:            p.User,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		HostKeyCallback: hostKeyCallback,
+	}, nil
+}
+
+func (p SSHParams) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	if p.Fingerprint != "" {
+		return func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			if fp := ssh.FingerprintSHA256(key); fp != p.Fingerprint {
+				return fmt.Errorf("host key fingerprint mismatch for %s, got %s", p.Host, fp)
+			}
+			return nil
+		}, nil
+	}
+	res, err := knownhosts.New(p.KnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("can't load known_hosts %s: %w", p.KnownHosts, err)
+	}
+	return res, nil
+}
@@ -0,0 +1,357 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHRunner_Run(t *testing.T) {
+	keyFile, clientKey := makeTestKey(t)
+	srv := startTestSSHServer(t, clientKey)
+	params := srv.params("app", keyFile)
+
+	sr := SSHRunner{Params: params}
+	{
+		lw := bytes.NewBuffer(nil)
+		err := sr.Run(context.Background(), "echo 123\necho 567\n", lw, lw)
+		require.NoError(t, err)
+		assert.Equal(t, "123\n567\n", lw.String())
+	}
+	{
+		lw := bytes.NewBuffer(nil)
+		err := sr.Run(context.Background(), "echo 123\nno-such-command 123\necho 567", lw, lw)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to execute no-such-command 123 on 127.0.0.1")
+		assert.Contains(t, lw.String(), "not found")
+		assert.NotContains(t, lw.String(), "567")
+	}
+	{
+		lw := bytes.NewBuffer(nil)
+		err := sr.Run(context.Background(), "@no-such-command 123\necho 567", lw, lw)
+		require.NoError(t, err)
+		assert.Contains(t, lw.String(), "567\n")
+	}
+	{
+		stdout, stderr := bytes.NewBuffer(nil), bytes.NewBuffer(nil)
+		err := sr.Run(context.Background(), "echo 123\n@no-such-command 123", stdout, stderr)
+		require.NoError(t, err)
+		assert.Equal(t, "123\n", stdout.String())
+		assert.Contains(t, stderr.String(), "not found")
+	}
+}
+
+func TestSSHRunner_RunBatch(t *testing.T) {
+	keyFile, clientKey := makeTestKey(t)
+	srv := startTestSSHServer(t, clientKey)
+
+	sr := SSHRunner{Params: srv.params("app", keyFile), BatchMode: true}
+	lw := bytes.NewBuffer(nil)
+	err := sr.Run(context.Background(), "A=123\necho $A\necho 345", lw, lw)
+	require.NoError(t, err)
+	assert.Equal(t, "123\n345\n", lw.String())
+}
+
+func TestSSHRunner_RunSecrets(t *testing.T) {
+	keyFile, clientKey := makeTestKey(t)
+	srv := startTestSSHServer(t, clientKey)
+
+	ctx := WithSecrets(WithParams(context.Background(), map[string]string{"VERSION": "1.2"}),
+		map[string]string{"API_TOKEN": "secret-value"})
+	for _, batch := range []bool{false, true} {
+		sr := SSHRunner{Params: srv.params("app", keyFile), BatchMode: batch}
+		lw := bytes.NewBuffer(nil)
+		err := sr.Run(ctx, "echo $VERSION\necho $API_TOKEN", lw, lw)
+		require.NoError(t, err)
+		assert.Equal(t, "1.2\nsecret-value\n", lw.String(), "batch: %v", batch)
+	}
+	commands := srv.execCommands()
+	require.Len(t, commands, 3)
+	for _, c := range commands {
+		assert.NotContains(t, c, "secret-value", "secrets not passed in command line")
+		assert.NotContains(t, c, "1.2", "params not passed in command line")
+	}
+}
+
+func TestSSHRunner_RunCancel(t *testing.T) {
+	keyFile, clientKey := makeTestKey(t)
+	srv := startTestSSHServer(t, clientKey)
+
+	sr := SSHRunner{Params: srv.params("app", keyFile)}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	st := time.Now()
+	err := sr.Run(ctx, "sleep 5\necho 123", io.Discard, io.Discard)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(st), 2*time.Second)
+}
+
+func TestSSHRunner_HostKey(t *testing.T) {
+	keyFile, clientKey := makeTestKey(t)
+	srv := startTestSSHServer(t, clientKey)
+
+	t.Run("known_hosts", func(t *testing.T) {
+		knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+		line := knownhosts.Line([]string{knownhosts.Normalize(srv.addr)}, srv.hostKey.PublicKey())
+		require.NoError(t, os.WriteFile(knownHostsFile, []byte(line+"\n"), 0o600))
+		params := srv.params("app", keyFile)
+		params.Fingerprint, params.KnownHosts = "", knownHostsFile
+		sr := SSHRunner{Params: params}
+		lw := bytes.NewBuffer(nil)
+		require.NoError(t, sr.Run(context.Background(), "echo 123", lw, lw))
+		assert.Equal(t, "123\n", lw.String())
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		knownHostsFile := filepath.Join(t.TempDir(), "known_hosts")
+		require.NoError(t, os.WriteFile(knownHostsFile, nil, 0o600))
+		params := srv.params("app", keyFile)
+		params.Fingerprint, params.KnownHosts = "", knownHostsFile
+		sr := SSHRunner{Params: params}
+		err := sr.Run(context.Background(), "echo 123", io.Discard, io.Discard)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "key is unknown")
+	})
+
+	t.Run("fingerprint mismatch", func(t *testing.T) {
+		params := srv.params("app", keyFile)
+		params.Fingerprint = "SHA256:bad"
+		sr := SSHRunner{Params: params}
+		err := sr.Run(context.Background(), "echo 123", io.Discard, io.Discard)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "host key fingerprint mismatch")
+	})
+
+	t.Run("unauthorized client", func(t *testing.T) {
+		otherKeyFile, _ := makeTestKey(t)
+		sr := SSHRunner{Params: srv.params("app", otherKeyFile)}
+		err := sr.Run(context.Background(), "echo 123", io.Discard, io.Discard)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "unable to authenticate")
+	})
+}
+
+func TestSSHRunner_RunJump(t *testing.T) {
+	keyFile, clientKey := makeTestKey(t)
+	target := startTestSSHServer(t, clientKey)
+	jump := startTestSSHServer(t, clientKey)
+
+	params := target.params("app", keyFile)
+	jumpParams := jump.params("", "")
+	params.Jump = &jumpParams
+	require.NoError(t, params.validate())
+
+	sr := SSHRunner{Params: params}
+	lw := bytes.NewBuffer(nil)
+	require.NoError(t, sr.Run(context.Background(), "echo 123", lw, lw))
+	assert.Equal(t, "123\n", lw.String())
+	assert.Equal(t, 1, jump.forwarded(), "target connection forwarded by jump host")
+}
+
+func TestSSHParams_validate(t *testing.T) {
+	tbl := []struct {
+		params SSHParams
+		err    string
+	}{
+		{SSHParams{Host: "h", User: "u", KeyFile: "k", Fingerprint: "f"}, ""},
+		{SSHParams{Host: "h", User: "u", KeyFile: "k", KnownHosts: "kh"}, ""},
+		{SSHParams{User: "u", KeyFile: "k", KnownHosts: "kh"}, "ssh host required"},
+		{SSHParams{Host: "h", KeyFile: "k", KnownHosts: "kh"}, "ssh user required"},
+		{SSHParams{Host: "h", User: "u", KnownHosts: "kh"}, "ssh key_file required"},
+		{SSHParams{Host: "h", User: "u", KeyFile: "k"}, "ssh known_hosts or fingerprint required"},
+		{SSHParams{Host: "h", User: "u", KeyFile: "k", KnownHosts: "kh", Jump: &SSHParams{Host: "j"}}, ""},
+		{SSHParams{Host: "h", User: "u", KeyFile: "k", KnownHosts: "kh", Jump: &SSHParams{}}, "jump: ssh host required"},
+	}
+	for i, tt := range tbl {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			err := tt.params.validate()
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Equal(t, tt.err, err.Error())
+		})
+	}
+}
+
+// makeTestKey generates client key and writes it to a temp file
+func makeTestKey(t *testing.T) (keyFile string, pub ssh.PublicKey) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	block, err := ssh.MarshalPrivateKey(priv, "test")
+	require.NoError(t, err)
+	keyFile = filepath.Join(t.TempDir(), "id_ed25519")
+	require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(block), 0o600))
+	signer, err := ssh.NewSignerFromKey(priv)
+	require.NoError(t, err)
+	return keyFile, signer.PublicKey()
+}
+
+// testSSHServer is a minimal in-process ssh server executing commands with local shell
+// and forwarding direct-tcpip channels, to be used as a jump host
+type testSSHServer struct {
+	addr    string
+	hostKey ssh.Signer
+
+	mu       sync.Mutex
+	fwds     int
+	commands []string // commands of exec requests
+}
+
+func (s *testSSHServer) params(user, keyFile string) SSHParams {
+	host, port, _ := net.SplitHostPort(s.addr)
+	p, _ := strconv.Atoi(port)
+	return SSHParams{Host: host, Port: p, User: user, KeyFile: keyFile, Fingerprint: ssh.FingerprintSHA256(s.hostKey.PublicKey())}
+}
+
+func (s *testSSHServer) execCommands() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.commands...)
+}
+
+func (s *testSSHServer) forwarded() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.fwds
+}
+
+func startTestSSHServer(t *testing.T, authorized ssh.PublicKey) *testSSHServer {
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	require.NoError(t, err)
+
+	conf := &ssh.ServerConfig{PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if bytes.Equal(key.Marshal(), authorized.Marshal()) {
+			return &ssh.Permissions{}, nil
+		}
+		return nil, errors.New("unauthorized")
+	}}
+	conf.AddHostKey(hostKey)
+
+	lst, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = lst.Close() })
+
+	srv := &testSSHServer{addr: lst.Addr().String(), hostKey: hostKey}
+	go func() {
+		for {
+			conn, err := lst.Accept()
+			if err != nil {
+				return
+			}
+			go srv.handleConn(conn, conf)
+		}
+	}()
+	return srv
+}
+
+func (s *testSSHServer) handleConn(conn net.Conn, conf *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, conf)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		switch nc.ChannelType() {
+		case "session":
+			go s.handleSession(nc)
+		case "direct-tcpip":
+			go s.handleForward(nc)
+		default:
+			_ = nc.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *testSSHServer) handleSession(nc ssh.NewChannel) {
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		return
+	}
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := ssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			return
+		}
+		_ = req.Reply(true, nil)
+		s.mu.Lock()
+		s.commands = append(s.commands, payload.Command)
+		s.mu.Unlock()
+
+		cmd := exec.Command("sh", "-c", payload.Command) //nolint
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+		status := uint32(0)
+		if err := cmd.Run(); err != nil {
+			status = 1
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) {
+				status = uint32(exitErr.ExitCode()) //nolint
+			}
+		}
+		res := make([]byte, 4)
+		binary.BigEndian.PutUint32(res, status)
+		_, _ = ch.SendRequest("exit-status", false, res)
+		return
+	}
+}
+
+func (s *testSSHServer) handleForward(nc ssh.NewChannel) {
+	var payload struct {
+		Host     string
+		Port     uint32
+		OrigHost string
+		OrigPort uint32
+	}
+	if err := ssh.Unmarshal(nc.ExtraData(), &payload); err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, "bad payload")
+		return
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+	if err != nil {
+		_ = nc.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	ch, reqs, err := nc.Accept()
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.fwds++
+	s.mu.Unlock()
+	go ssh.DiscardRequests(reqs)
+	go func() {
+		_, _ = io.Copy(ch, conn)
+		_ = ch.CloseWrite()
+	}()
+	_, _ = io.Copy(conn, ch)
+	_ = conn.Close()
+}
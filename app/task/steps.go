@@ -0,0 +1,34 @@
+package task
+
+import (
+	"context"
+	"time"
+)
+
+// Step is a completed step of the task, i.e. a command of shell task, a service of compose task
+// or a host of multi-host task
+type Step struct {
+	Name     string        `json:"name"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+type stepsCtxKey struct{}
+
+// WithSteps returns context with the func called by runners on completion of each step of the task
+func WithSteps(ctx context.Context, fn func(Step)) context.Context {
+	return context.WithValue(ctx, stepsCtxKey{}, fn)
+}
+
+// stepDone reports completed step to the func set by WithSteps, if any
+func stepDone(ctx context.Context, name string, started time.Time, err error) {
+	fn, ok := ctx.Value(stepsCtxKey{}).(func(Step))
+	if !ok || fn == nil {
+		return
+	}
+	step := Step{Name: name, Duration: time.Since(started)}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	fn(step)
+}
@@ -0,0 +1,9 @@
+#!/bin/sh
+# fake compose used in tests, prints arguments and fails for "bad" service
+echo "compose $*"
+for a in "$@"; do
+  if [ "$a" = "bad" ]; then
+    echo "can't update $a" >&2
+    exit 1
+  fi
+done
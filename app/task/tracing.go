@@ -0,0 +1,19 @@
+package task
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer makes spans of commands, it is no-op unless tracing set up
+var tracer = otel.Tracer("github.com/umputun/updater/app/task")
+
+// endSpan ends the span with error status if err is not nil
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
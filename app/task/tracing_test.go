@@ -0,0 +1,79 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestShellRunner_Tracing(t *testing.T) {
+	ctx, spans := tracedContext(t)
+	sr := ShellRunner{}
+	out := bytes.Buffer{}
+	require.NoError(t, sr.Run(ctx, "echo $TRACEPARENT\n@false", &out, &out))
+
+	ended := spans()
+	require.Len(t, ended, 2)
+	for _, s := range ended {
+		assert.Equal(t, "command", s.Name())
+		assert.Equal(t, codes.Unset, s.Status().Code)
+	}
+	assert.Equal(t, "echo $TRACEPARENT", ended[0].Attributes()[0].Value.AsString())
+	sc := ended[0].SpanContext()
+	assert.Equal(t, "00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01\n", out.String(),
+		"command gets trace context of its span")
+	require.Len(t, ended[1].Events(), 1)
+	assert.Equal(t, "error suppressed", ended[1].Events()[0].Name)
+
+	ctx, spans = tracedContext(t)
+	require.Error(t, sr.Run(ctx, "false", &out, &out))
+	ended = spans()
+	require.Len(t, ended, 1)
+	assert.Equal(t, codes.Error, ended[0].Status().Code)
+	assert.Equal(t, "failed to execute false: exit status 1", ended[0].Status().Description)
+
+	ctx, spans = tracedContext(t)
+	sr = ShellRunner{BatchMode: true, TimeOut: time.Second}
+	out.Reset()
+	require.NoError(t, sr.Run(ctx, "echo 1\necho $TRACEPARENT", &out, &out))
+	ended = spans()
+	require.Len(t, ended, 1)
+	assert.Equal(t, "batch", ended[0].Name())
+	assert.True(t, strings.HasSuffix(out.String(), ended[0].SpanContext().SpanID().String()+"-01\n"))
+}
+
+var (
+	recorderOnce sync.Once
+	recorder     *tracetest.SpanRecorder
+)
+
+// tracedContext returns context with the root span of the test and func returning ended spans of its trace.
+// Tracer provider set once, as tracers made before it are bound to the first one.
+func tracedContext(t *testing.T) (context.Context, func() []sdktrace.ReadOnlySpan) {
+	t.Helper()
+	recorderOnce.Do(func() {
+		recorder = tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	})
+	ctx, root := otel.Tracer("test").Start(context.Background(), t.Name())
+	t.Cleanup(func() { root.End() })
+	return ctx, func() []sdktrace.ReadOnlySpan {
+		var res []sdktrace.ReadOnlySpan
+		for _, s := range recorder.Ended() {
+			if s.SpanContext().TraceID() == root.SpanContext().TraceID() {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+}
@@ -0,0 +1,114 @@
+// Package tracing sets up OpenTelemetry tracing. Spans exported with OTLP over http, or written as json
+// to stdout or file for offline use. Trace context propagated with W3C traceparent header.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Exporters of spans
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+// Opts defines exporter of spans and sampling
+type Opts struct {
+	Exporter string  // none, otlp, stdout or file, tracing disabled if empty or none
+	Endpoint string  // url of otlp http endpoint, OTEL_EXPORTER_OTLP_ENDPOINT or http://localhost:4318 if empty
+	File     string  // file of spans for file exporter, spans appended one json per line
+	Ratio    float64 // ratio of sampled traces, traces with sampled parent sampled anyway
+	Service  string  // name of the service in spans, OTEL_SERVICE_NAME overrides it
+	Version  string  // version of the service
+}
+
+// Enabled returns true if spans exported
+func (o Opts) Enabled() bool {
+	return o.Exporter != "" && o.Exporter != ExporterNone
+}
+
+// Setup sets global tracer provider and propagator, returns shutdown func flushing pending spans.
+// Nothing is set with tracing disabled, spans are not recorded and shutdown does nothing.
+func Setup(ctx context.Context, opts Opts) (shutdown func(context.Context) error, err error) {
+	if !opts.Enabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+	exp, closer, err := makeExporter(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx, resource.WithAttributes(attribute.String("service.name", opts.Service),
+		attribute.String("service.version", opts.Version)), resource.WithTelemetrySDK(), resource.WithFromEnv())
+	if err != nil {
+		return nil, fmt.Errorf("can't make tracing resource: %w", err)
+	}
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.Ratio))))
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		errs := []error{}
+		if err := tp.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("can't shutdown tracing: %w", err))
+		}
+		if closer != nil {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, fmt.Errorf("can't close spans file: %w", err))
+			}
+		}
+		return errors.Join(errs...)
+	}, nil
+}
+
+// makeExporter makes exporter of spans, the closer returned for file exporter only
+func makeExporter(ctx context.Context, opts Opts) (sdktrace.SpanExporter, io.Closer, error) {
+	switch opts.Exporter {
+	case ExporterOTLP:
+		var otlpOpts []otlptracehttp.Option
+		if opts.Endpoint != "" {
+			otlpOpts = append(otlpOpts, otlptracehttp.WithEndpointURL(opts.Endpoint))
+		}
+		exp, err := otlptracehttp.New(ctx, otlpOpts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't make otlp exporter: %w", err)
+		}
+		return exp, nil, nil
+	case ExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't make stdout exporter: %w", err)
+		}
+		return exp, nil, nil
+	case ExporterFile:
+		if opts.File == "" {
+			return nil, nil, errors.New("spans file required for file exporter")
+		}
+		fh, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o640) //nolint:gosec // file from options
+		if err != nil {
+			return nil, nil, fmt.Errorf("can't open spans file: %w", err)
+		}
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(fh))
+		if err != nil {
+			_ = fh.Close()
+			return nil, nil, fmt.Errorf("can't make file exporter: %w", err)
+		}
+		return exp, fh, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q", opts.Exporter)
+	}
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestSetup_File(t *testing.T) {
+	t.Cleanup(resetGlobals)
+	file := filepath.Join(t.TempDir(), "spans.json")
+	shutdown, err := Setup(context.Background(), Opts{Exporter: ExporterFile, File: file, Ratio: 1,
+		Service: "updater", Version: "v1.2.3"})
+	require.NoError(t, err)
+
+	// incoming traceparent continues the trace
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.HeaderCarrier{
+		"Traceparent": []string{"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}})
+	_, span := otel.Tracer("test").Start(ctx, "test span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+
+	data, err := os.ReadFile(file) //nolint:gosec // test file
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	rec := struct {
+		Name        string
+		SpanContext struct{ TraceID string }
+		Parent      struct{ SpanID string }
+		Resource    []struct {
+			Key   string
+			Value struct{ Value any }
+		}
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &rec))
+	assert.Equal(t, "test span", rec.Name)
+	assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", rec.SpanContext.TraceID)
+	assert.Equal(t, "b7ad6b7169203331", rec.Parent.SpanID)
+	res := map[string]any{}
+	for _, kv := range rec.Resource {
+		res[kv.Key] = kv.Value.Value
+	}
+	assert.Equal(t, "updater", res["service.name"])
+	assert.Equal(t, "v1.2.3", res["service.version"])
+}
+
+func TestSetup_OTLP(t *testing.T) {
+	t.Cleanup(resetGlobals)
+	var received atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/traces", r.URL.Path)
+		assert.Equal(t, "application/x-protobuf", r.Header.Get("Content-Type"))
+		received.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	shutdown, err := Setup(context.Background(), Opts{Exporter: ExporterOTLP, Endpoint: ts.URL + "/v1/traces", Ratio: 1,
+		Service: "updater"})
+	require.NoError(t, err)
+	_, span := otel.Tracer("test").Start(context.Background(), "test span")
+	span.End()
+	require.NoError(t, shutdown(context.Background()))
+	assert.Equal(t, int32(1), received.Load())
+}
+
+func TestSetup_Disabled(t *testing.T) {
+	t.Cleanup(resetGlobals)
+	for _, exporter := range []string{"", ExporterNone} {
+		shutdown, err := Setup(context.Background(), Opts{Exporter: exporter})
+		require.NoError(t, err)
+		_, span := otel.Tracer("test").Start(context.Background(), "test span")
+		assert.False(t, span.IsRecording())
+		span.End()
+		require.NoError(t, shutdown(context.Background()))
+	}
+}
+
+func TestSetup_Errors(t *testing.T) {
+	t.Cleanup(resetGlobals)
+	_, err := Setup(context.Background(), Opts{Exporter: "bad"})
+	require.EqualError(t, err, `unknown trace exporter "bad"`)
+	_, err = Setup(context.Background(), Opts{Exporter: ExporterFile})
+	require.EqualError(t, err, "spans file required for file exporter")
+	_, err = Setup(context.Background(), Opts{Exporter: ExporterFile, File: filepath.Join(t.TempDir(), "no", "spans.json")})
+	require.ErrorContains(t, err, "can't open spans file")
+}
+
+func resetGlobals() {
+	otel.SetTracerProvider(noop.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+}